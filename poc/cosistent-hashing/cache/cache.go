@@ -0,0 +1,85 @@
+// Package cache is the small interface shared by the distributed caches of this
+// directory, so groupcache and Olric can be swapped and run against the same workload.
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotFound is returned by Get for a missing or expired key.
+var ErrNotFound = errors.New("cache: key not found")
+
+// Cache is a distributed cache of byte values.
+type Cache interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores the value for ttl, or without expiry when ttl is 0.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	Stats() Stats
+	Close(ctx context.Context) error
+}
+
+// Stats counts the calls made through a Cache.
+type Stats struct {
+	Gets    int64 `json:"gets"`
+	Hits    int64 `json:"hits"`
+	Misses  int64 `json:"misses"`
+	Sets    int64 `json:"sets"`
+	Deletes int64 `json:"deletes"`
+	// Errors counts the failed calls, misses excluded.
+	Errors int64 `json:"errors"`
+}
+
+// HitRate returns the fraction of the gets that found the key.
+func (s Stats) HitRate() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Gets)
+}
+
+// counters is embedded by the backends to count the calls the same way.
+type counters struct {
+	gets, hits, misses, sets, deletes, errors atomic.Int64
+}
+
+func (c *counters) get(err error) {
+	c.gets.Add(1)
+	switch {
+	case err == nil:
+		c.hits.Add(1)
+	case errors.Is(err, ErrNotFound):
+		c.misses.Add(1)
+	default:
+		c.errors.Add(1)
+	}
+}
+
+func (c *counters) set(err error) {
+	c.sets.Add(1)
+	if err != nil {
+		c.errors.Add(1)
+	}
+}
+
+func (c *counters) delete(err error) {
+	c.deletes.Add(1)
+	if err != nil {
+		c.errors.Add(1)
+	}
+}
+
+// Stats returns the counters.
+func (c *counters) Stats() Stats {
+	return Stats{
+		Gets:    c.gets.Load(),
+		Hits:    c.hits.Load(),
+		Misses:  c.misses.Load(),
+		Sets:    c.sets.Load(),
+		Deletes: c.deletes.Load(),
+		Errors:  c.errors.Load(),
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/buraksezer/olric/config"
+)
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// testCache checks the behavior every backend must share.
+func testCache(t *testing.T, c Cache) {
+	ctx := context.Background()
+	if _, err := c.Get(ctx, "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+	if err := c.Set(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := c.Get(ctx, "a"); err != nil || !bytes.Equal(v, []byte("1")) {
+		t.Fatalf("Get(a) = %q, %v", v, err)
+	}
+	if err := c.Delete(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(a) after Delete error = %v, want ErrNotFound", err)
+	}
+
+	if err := c.Set(ctx, "b", []byte("2"), 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Get(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := c.Get(ctx, "b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get(b) after ttl error = %v, want ErrNotFound", err)
+	}
+
+	want := Stats{Gets: 5, Hits: 2, Misses: 3, Sets: 2, Deletes: 1}
+	if got := c.Stats(); got != want {
+		t.Fatalf("Stats() = %+v, want %+v", got, want)
+	}
+	if err := c.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGroupcache(t *testing.T) {
+	testCache(t, NewGroupcache("test-"+t.Name(), 1<<20))
+}
+
+func TestOlric(t *testing.T) {
+	c := config.New("local")
+	c.BindAddr, c.BindPort = "127.0.0.1", freePort(t)
+	c.MemberlistConfig.BindAddr, c.MemberlistConfig.BindPort = "127.0.0.1", freePort(t)
+	c.LogOutput, c.LogLevel = io.Discard, config.LogLevelError
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	o, err := NewOlric(ctx, c, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testCache(t, o)
+}
@@ -0,0 +1,55 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+)
+
+// Groupcache is a Cache on a groupcache group. groupcache is a read-through cache,
+// here its getter has no source and reports every key it is asked to load as
+// missing, so values only come from Set. Keys are placed on the peers of the
+// HTTPPool of the process, if any.
+type Groupcache struct {
+	counters
+	group *groupcache.Group
+}
+
+// NewGroupcache creates the group. Group names are unique in a process.
+func NewGroupcache(name string, cacheBytes int64) *Groupcache {
+	return &Groupcache{group: groupcache.NewGroup(name, cacheBytes, groupcache.GetterFunc(
+		func(ctx context.Context, key string, dest groupcache.Sink) error {
+			return ErrNotFound
+		},
+	))}
+}
+
+func (g *Groupcache) Get(ctx context.Context, key string) ([]byte, error) {
+	var value []byte
+	err := g.group.Get(ctx, key, groupcache.AllocatingByteSliceSink(&value))
+	g.get(err)
+	return value, err
+}
+
+func (g *Groupcache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var expire time.Time
+	if ttl > 0 {
+		expire = time.Now().Add(ttl)
+	}
+	err := g.group.Set(ctx, key, value, expire, false)
+	g.set(err)
+	return err
+}
+
+// Delete removes the key from its owner and the hot cache of every peer.
+func (g *Groupcache) Delete(ctx context.Context, key string) error {
+	err := g.group.Remove(ctx, key)
+	g.delete(err)
+	return err
+}
+
+// Close does nothing, groups live as long as the process.
+func (g *Groupcache) Close(ctx context.Context) error {
+	return nil
+}
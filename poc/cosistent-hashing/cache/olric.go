@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/buraksezer/olric"
+	"github.com/buraksezer/olric/config"
+)
+
+// Olric is a Cache on a DMap of an embedded Olric member, as in olricTest.go.
+type Olric struct {
+	counters
+	db *olric.Olric
+	dm olric.DMap
+}
+
+// NewOlric starts an embedded member with the config and returns once it accepts
+// requests on the DMap. The Started callback of the config is replaced.
+func NewOlric(ctx context.Context, c *config.Config, dmap string) (*Olric, error) {
+	started := make(chan struct{})
+	c.Started = func() { close(started) }
+	db, err := olric.New(c)
+	if err != nil {
+		return nil, err
+	}
+	failed := make(chan error, 1)
+	go func() {
+		if err := db.Start(); err != nil {
+			failed <- err
+		}
+	}()
+	select {
+	case <-started:
+	case err := <-failed:
+		return nil, fmt.Errorf("starting olric: %w", err)
+	case <-ctx.Done():
+		db.Shutdown(context.Background())
+		return nil, ctx.Err()
+	}
+
+	dm, err := db.NewEmbeddedClient().NewDMap(dmap)
+	if err != nil {
+		db.Shutdown(ctx)
+		return nil, err
+	}
+	return &Olric{db: db, dm: dm}, nil
+}
+
+func (o *Olric) Get(ctx context.Context, key string) ([]byte, error) {
+	value, err := o.lookup(ctx, key)
+	o.get(err)
+	return value, err
+}
+
+func (o *Olric) lookup(ctx context.Context, key string) ([]byte, error) {
+	gr, err := o.dm.Get(ctx, key)
+	if errors.Is(err, olric.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return gr.Byte()
+}
+
+func (o *Olric) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	var opts []olric.PutOption
+	if ttl > 0 {
+		opts = append(opts, olric.PX(ttl))
+	}
+	err := o.dm.Put(ctx, key, value, opts...)
+	o.set(err)
+	return err
+}
+
+func (o *Olric) Delete(ctx context.Context, key string) error {
+	_, err := o.dm.Delete(ctx, key)
+	o.delete(err)
+	return err
+}
+
+// Close leaves the cluster and stops the member.
+func (o *Olric) Close(ctx context.Context) error {
+	return o.db.Shutdown(ctx)
+}
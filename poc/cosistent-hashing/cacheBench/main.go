@@ -0,0 +1,171 @@
+// cacheBench runs the same cache-aside workload against groupcache and an embedded
+// Olric member in this process and compares their latency and hit rate. Workers read
+// keys with a zipf distribution and, on a miss, load the value from a fake source
+// and set it with a TTL.
+//
+//	go run ./cacheBench -ops 200000 -keys 10000 -ttl 1s
+package main
+
+import (
+	"context"
+	"distCache/cache"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/buraksezer/olric/config"
+)
+
+type workload struct {
+	ops, workers, keys, valueSize int
+	zipf                          float64
+	ttl, loadLatency              time.Duration
+	seed                          int64
+}
+
+// result is the report of one backend.
+type result struct {
+	backend  string
+	elapsed  time.Duration
+	gets     []time.Duration
+	sets     []time.Duration
+	stats    cache.Stats
+	firstErr error
+}
+
+var backends = map[string]func(ctx context.Context) (cache.Cache, error){
+	"groupcache": func(ctx context.Context) (cache.Cache, error) {
+		return cache.NewGroupcache("bench", 64<<20), nil
+	},
+	"olric": func(ctx context.Context) (cache.Cache, error) {
+		c := config.New("local")
+		c.LogOutput, c.LogLevel = io.Discard, config.LogLevelError
+		return cache.NewOlric(ctx, c, "bench")
+	},
+}
+
+func run(name string, c cache.Cache, w workload) result {
+	res := result{backend: name}
+	value := make([]byte, w.valueSize)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < w.workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(w.seed + int64(i)))
+			zipf := rand.NewZipf(rnd, w.zipf, 1, uint64(w.keys-1))
+			var gets, sets []time.Duration
+			var firstErr error
+			for op := i; op < w.ops; op += w.workers {
+				key := fmt.Sprintf("key-%d", zipf.Uint64())
+				t := time.Now()
+				_, err := c.Get(context.Background(), key)
+				gets = append(gets, time.Since(t))
+				if err == nil {
+					continue
+				}
+				if !errors.Is(err, cache.ErrNotFound) {
+					firstErr = err
+					continue
+				}
+				time.Sleep(w.loadLatency)
+				t = time.Now()
+				if err := c.Set(context.Background(), key, value, w.ttl); err != nil {
+					firstErr = err
+				}
+				sets = append(sets, time.Since(t))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			res.gets = append(res.gets, gets...)
+			res.sets = append(res.sets, sets...)
+			if res.firstErr == nil {
+				res.firstErr = firstErr
+			}
+		}(i)
+	}
+	wg.Wait()
+	res.elapsed = time.Since(start)
+	res.stats = c.Stats()
+	return res
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(p*float64(len(sorted)-1))]
+}
+
+func main() {
+	backendList := flag.String("backends", "groupcache,olric", "backends to compare: groupcache and olric")
+	var w workload
+	flag.IntVar(&w.ops, "ops", 100000, "number of gets")
+	flag.IntVar(&w.workers, "workers", 8, "concurrent workers")
+	flag.IntVar(&w.keys, "keys", 10000, "number of distinct keys")
+	flag.IntVar(&w.valueSize, "value-size", 256, "size of the values in bytes")
+	flag.Float64Var(&w.zipf, "zipf", 1.1, "zipf exponent of the key distribution, must be > 1")
+	flag.DurationVar(&w.ttl, "ttl", 10*time.Second, "ttl of the values set after a miss")
+	flag.DurationVar(&w.loadLatency, "load-latency", 0, "latency of the fake source on a miss")
+	flag.Int64Var(&w.seed, "seed", 1, "seed of the key distribution")
+	flag.Parse()
+
+	if w.zipf <= 1 || w.keys < 2 || w.workers < 1 || w.ops < 1 {
+		fmt.Fprintln(os.Stderr, "-zipf must be > 1, -keys at least 2, -workers and -ops positive")
+		os.Exit(2)
+	}
+	var names []string
+	for _, name := range strings.Split(*backendList, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := backends[name]; !ok {
+			fmt.Fprintf(os.Stderr, "unknown backend %q\n", name)
+			os.Exit(2)
+		}
+		names = append(names, name)
+	}
+
+	var results []result
+	for _, name := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		c, err := backends[name](ctx)
+		cancel()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+			os.Exit(1)
+		}
+		results = append(results, run(name, c, w))
+		ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		if err := c.Close(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "closing %s: %v\n", name, err)
+		}
+		cancel()
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "backend\tops/s\thit rate\tget p50\tget p99\tset p50\tset p99\terrors")
+	for _, r := range results {
+		sort.Slice(r.gets, func(i, j int) bool { return r.gets[i] < r.gets[j] })
+		sort.Slice(r.sets, func(i, j int) bool { return r.sets[i] < r.sets[j] })
+		fmt.Fprintf(tw, "%s\t%.0f\t%.4f\t%v\t%v\t%v\t%v\t%d\n", r.backend,
+			float64(len(r.gets))/r.elapsed.Seconds(), r.stats.HitRate(),
+			percentile(r.gets, 0.5), percentile(r.gets, 0.99),
+			percentile(r.sets, 0.5), percentile(r.sets, 0.99), r.stats.Errors)
+	}
+	tw.Flush()
+	for _, r := range results {
+		if r.firstErr != nil {
+			fmt.Fprintf(os.Stderr, "%s: first error: %v\n", r.backend, r.firstErr)
+		}
+	}
+}
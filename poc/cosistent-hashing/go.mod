@@ -3,6 +3,7 @@ module distCache
 go 1.21.3
 
 require (
+	distributed-lb v0.0.0-00010101000000-000000000000
 	github.com/buraksezer/consistent v0.10.0
 	github.com/buraksezer/olric v0.5.4
 	github.com/golang/protobuf v1.5.3
@@ -13,6 +14,8 @@ require (
 	github.com/RoaringBitmap/roaring v1.2.1 // indirect
 	github.com/armon/go-metrics v0.0.0-20180917152333-f0300d1749da // indirect
 	github.com/bits-and-blooms/bitset v1.2.0 // indirect
+	github.com/cenkalti/backoff/v4 v4.2.1 // indirect
+	github.com/cespare/xxhash v1.1.0 // indirect
 	github.com/cespare/xxhash/v2 v2.1.2 // indirect
 	github.com/dgryski/go-rendezvous v0.0.0-20200823014737-9f7001d12a5f // indirect
 	github.com/go-redis/redis/v8 v8.11.5 // indirect
@@ -45,3 +48,8 @@ require (
 	google.golang.org/protobuf v1.28.1 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
 )
+
+replace distributed-lb => ../distributed-lb
+
+// distributed-lb requires lifecycle, which sits next to it.
+replace lifecycle => ../lifecycle
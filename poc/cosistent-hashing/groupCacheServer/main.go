@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"log"
@@ -12,8 +13,12 @@ import (
 	"io"
 	"math"
 	"math/big"
-	"strings"
 	"strconv"
+	"strings"
+
+	"distCache/peers"
+	"distCache/tiered"
+	"distributed-lb/client"
 
 	"github.com/golang/protobuf/proto"
 	"github.com/mailgun/groupcache/v2"
@@ -22,38 +27,45 @@ import (
 var group *groupcache.Group
 var max = big.NewInt(10000)
 var pool *groupcache.HTTPPool
+var discovery *peers.Discovery
+var cache *tiered.Cache
 
 func main() {
 
-	port := flag.String("port", "1", "port number")
+	port := flag.String("port", "1", "port number, the peer listens on 810<port> and the api on 900<port>")
+	addr := flag.String("addr", "", "peer address, overrides -port")
+	api := flag.String("api", "", "api address, overrides -port")
+	coordinator := flag.String("coordinator", "http://127.0.0.1:8081", "coordinator url")
+	ring := flag.String("ring", "", "ring name, the coordinator's default ring if empty")
+	localSize := flag.Int("local-size", 1000, "max entries of the local tier in front of groupcache")
+	localTTL := flag.Duration("local-ttl", 10*time.Second, "ttl of the local tier entries")
 	flag.Parse()
+	ctx, cancel := context.WithCancelCause(context.Background())
+	if *addr == "" {
+		*addr = "127.0.0.1:810" + *port
+	}
+	if *api == "" {
+		*api = "127.0.0.1:900" + *port
+	}
 	// NOTE: It is important to pass the same peer `http://192.168.1.1:8080` to `NewHTTPPoolOpts`
 	// which is provided to `pool.Set()` so the pool can identify which of the peers is our instance.
 	// The pool will not operate correctly if it can't identify which peer is our instance.
 
 	// Pool keeps track of peers in our cluster and identifies which peer owns a key.
-	ip := "127.0.0.1:808" + *port
+	ip := *addr
 	fmt.Println("Ip: " + ip)
 	pool = groupcache.NewHTTPPoolOpts("http://"+ip, &groupcache.HTTPPoolOptions{Replicas: 1000})
 
-	// Add more peers to the cluster You MUST Ensure our instance is included in this list else
-	// determining who owns the key accross the cluster will not be consistent, and the pool won't
-	// be able to determine if our instance owns the key.
-	pool.Set("http://127.0.0.1:8081", "http://127.0.0.1:8082", "http://127.0.0.1:8083")
-	//pool.Set("http://127.0.0.1:8082")
-	server := http.Server{
-		Addr:    ip,
-		Handler: pool,
+	// The peers are the members of the coordinator's ring, their host and port must match
+	// the -addr of each instance so the pool can identify which of the peers is our instance.
+	url := *coordinator
+	if *ring != "" {
+		url += "/rings/" + *ring
 	}
-
-	// Start a HTTP server to listen for peer requests from the groupcache
-	go func() {
-		log.Printf("Serving....\n")
-		if err := server.ListenAndServe(); err != nil {
-			log.Fatal(err)
-		}
-	}()
-	defer server.Shutdown(context.Background())
+	c := client.New(url)
+	discovery = peers.New(pool)
+	discovery.Follow(c)
+	go c.Run(cancel)
 
 	// Create a new group cache with a max cache size of 3MB
 	group = groupcache.NewGroup("users", 3000000, groupcache.GetterFunc(
@@ -76,52 +88,54 @@ func main() {
 			return dest.SetProto(&user, time.Now().Add(time.Minute*5))
 		},
 	))
+	// The local tier sits in front of the group; the peers invalidate it on the peer
+	// listener, whose URLs the pool already knows.
+	cache = tiered.New(group, tiered.NewLRU(*localSize, *localTTL), "http://"+ip, discovery.Peers)
+	peerMux := http.NewServeMux()
+	peerMux.Handle("/", pool)
+	peerMux.Handle(tiered.InvalidatePath, cache)
+	server := http.Server{
+		Addr:    ip,
+		Handler: peerMux,
+	}
+
+	// Start a HTTP server to listen for peer requests from the groupcache
+	go func() {
+		log.Printf("Serving....\n")
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", getUser)
+	mux.HandleFunc("/users/", user)
 	mux.HandleFunc("/add", addUser)
 	mux.HandleFunc("/stats", stats)
-	mux.HandleFunc("/removeNode/", removeNode)
-	mux.HandleFunc("/addNode/", addNode)
+	mux.HandleFunc("/peers", getPeers)
 
-	err := http.ListenAndServe("127.0.0.1:900"+*port, mux)
-	if err != nil {
-		fmt.Println("Error starting the http server:" + err.Error())
-	}
+	go func() {
+		err := http.ListenAndServe(*api, mux)
+		if err != nil {
+			fmt.Println("Error starting the http server:" + err.Error())
+			cancel(err)
+		}
+	}()
+	<-ctx.Done()
+	fmt.Println(context.Cause(ctx))
 }
 
+// stats returns the hit ratio of every tier as JSON.
 func stats(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("\n %+v", group.CacheStats(groupcache.MainCache))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cache.Stats())
 }
 
-func removeNode(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/removeNode/")
-	ip := "http://127.0.0.1:808" + id
-	var ips []string
-	peers := pool.GetAll()
-	for _,p := range peers {
-		url:= strings.TrimSuffix(p.GetURL(), "/_groupcache/")
-		if url != ip {
-			ips = append(ips, url)
-		} 
-	}
-	pool.Set(ips[:]...)
-	fmt.Println("Added: "+strings.Join(ips,","))
-
-}
-
-func addNode(w http.ResponseWriter, r *http.Request) {
-	id := strings.TrimPrefix(r.URL.Path, "/addNode/")
-	ip := "http://127.0.0.1:808" + id
- 	ips := []string{ip}
-	peers := pool.GetAll()
-	for _,p := range peers {
-		url:= strings.TrimSuffix(p.GetURL(), "/_groupcache/")
-		if url != ip {
-			ips = append(ips, url)
-		} 
-	}
-	pool.Set(ips[:]...)
-	fmt.Println("Added: "+strings.Join(ips,","))
+// getPeers returns the peers the pool currently knows from the coordinator.
+func getPeers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(discovery.Peers())
 }
 
 func getUser(w http.ResponseWriter, r *http.Request) {
@@ -131,7 +145,7 @@ func getUser(w http.ResponseWriter, r *http.Request) {
 	defer cancel()
 	randomNumber, _ := rand.Int(rand.Reader, max)
 	fmt.Println("Getting Key:", randomNumber)
-	if err := group.Get(ctx, randomNumber.String(), groupcache.ProtoSink(&user)); err != nil {
+	if err := getCached(ctx, randomNumber.String(), &user); err != nil {
 		fmt.Println("Key Not found " + randomNumber.String() + " : " + err.Error())
 		io.WriteString(w, "FAILED!\n")
 		return
@@ -144,6 +158,45 @@ func getUser(w http.ResponseWriter, r *http.Request) {
 	// fmt.Printf("IsSuper: %t\n", user.IsSuper)
 }
 
+// user serves GET /users/<id>, through the local tier, and DELETE /users/<id> which
+// invalidates the id on its owner and on the local tier of every peer.
+func user(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/users/")
+	if id == "" {
+		http.Error(w, "missing id", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Millisecond*500)
+	defer cancel()
+	switch r.Method {
+	case http.MethodGet:
+		var user User
+		if err := getCached(ctx, id, &user); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&user)
+	case http.MethodDelete:
+		if err := cache.Invalidate(ctx, id); err != nil {
+			fmt.Println("Error invalidating key: ", err)
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func getCached(ctx context.Context, id string, user *User) error {
+	value, err := cache.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(value, user)
+}
+
 func addUser(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*500)
 	defer cancel()
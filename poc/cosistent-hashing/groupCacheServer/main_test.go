@@ -0,0 +1,223 @@
+package main
+
+import (
+	"distCache/tiered"
+	"distributed-lb/coordinator"
+	"distributed-lb/hash"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// groupcache allows a single HTTPPool per process, so every peer of the harness runs
+// in its own process: the test binary re-executes itself and runs main with the
+// arguments passed in peerArgsEnv.
+const peerArgsEnv = "GROUPCACHE_PEER_ARGS"
+
+func TestMain(m *testing.M) {
+	if args := os.Getenv(peerArgsEnv); args != "" {
+		os.Args = append([]string{os.Args[0]}, strings.Fields(args)...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// peer is a groupcache server running in a child process.
+type peer struct {
+	member hash.Member
+	url    string
+	api    string
+}
+
+func startPeer(t *testing.T, name, coordinatorURL string) peer {
+	t.Helper()
+	addr, api := freeAddr(t), freeAddr(t)
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=-addr %s -api %s -coordinator %s", peerArgsEnv, addr, api, coordinatorURL))
+	if testing.Verbose() {
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	host, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return peer{
+		member: hash.Member{Name: name, Host: host, Port: p},
+		url:    "http://" + addr,
+		api:    "http://" + api,
+	}
+}
+
+// waitPeers polls the peers of p until they are want.
+func waitPeers(t *testing.T, p peer, want []string) {
+	t.Helper()
+	want = append([]string(nil), want...)
+	sort.Strings(want)
+	var got []string
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(20 * time.Millisecond) {
+		resp, err := http.Get(p.api + "/peers")
+		if err != nil {
+			continue
+		}
+		got = nil
+		err = json.NewDecoder(resp.Body).Decode(&got)
+		resp.Body.Close()
+		if err == nil && (reflect.DeepEqual(got, want) || len(got) == 0 && len(want) == 0) {
+			return
+		}
+	}
+	t.Fatalf("%s peers = %v, want %v", p.member.Name, got, want)
+}
+
+func TestPeersFollowMembership(t *testing.T) {
+	coord := coordinator.NewEmpty()
+	ring, err := coord.AddRing(coordinator.DefaultRing, nil, hash.Config{PartitionCount: 71, ReplicationFactor: 20, Load: 1.25}, filepath.Join(t.TempDir(), "members.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(coord)
+	t.Cleanup(srv.Close)
+
+	var cluster []peer
+	for i := 0; i < 3; i++ {
+		cluster = append(cluster, startPeer(t, fmt.Sprintf("cache%d", i), srv.URL))
+	}
+	// Before any member joins the pool is empty and every key is loaded locally.
+	for _, p := range cluster {
+		waitPeers(t, p, nil)
+	}
+
+	var members []hash.Member
+	var urls []string
+	for _, p := range cluster {
+		members = append(members, p.member)
+		urls = append(urls, p.url)
+	}
+	if err := ring.AddMember(members); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range cluster {
+		waitPeers(t, p, urls)
+	}
+	for i := 0; i < 20; i++ {
+		resp, err := http.Get(cluster[i%3].api + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "SUCCESS!\n" {
+			t.Fatalf("GET through %s: %q", cluster[i%3].member.Name, body)
+		}
+	}
+
+	if err := ring.RemoveMember(cluster[2].member); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range cluster {
+		waitPeers(t, p, []string{cluster[0].url, cluster[1].url})
+	}
+}
+
+func getStats(t *testing.T, p peer) tiered.Stats {
+	t.Helper()
+	resp, err := http.Get(p.api + "/stats")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var stats tiered.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatal(err)
+	}
+	return stats
+}
+
+func do(t *testing.T, method, url string, want int) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != want {
+		t.Fatalf("%s %s: %s", method, url, resp.Status)
+	}
+}
+
+func TestInvalidateEvictsLocalTiers(t *testing.T) {
+	coord := coordinator.NewEmpty()
+	ring, err := coord.AddRing(coordinator.DefaultRing, nil, hash.Config{PartitionCount: 71, ReplicationFactor: 20, Load: 1.25}, filepath.Join(t.TempDir(), "members.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(coord)
+	t.Cleanup(srv.Close)
+
+	var cluster []peer
+	var members []hash.Member
+	var urls []string
+	for i := 0; i < 2; i++ {
+		p := startPeer(t, fmt.Sprintf("cache%d", i), srv.URL)
+		cluster = append(cluster, p)
+		members = append(members, p.member)
+		urls = append(urls, p.url)
+	}
+	if err := ring.AddMember(members); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range cluster {
+		waitPeers(t, p, urls)
+	}
+
+	// The second read of every peer is served by its local tier.
+	for _, p := range cluster {
+		do(t, http.MethodGet, p.api+"/users/42", http.StatusOK)
+		do(t, http.MethodGet, p.api+"/users/42", http.StatusOK)
+		stats := getStats(t, p)
+		if stats.Local.Hits != 1 || stats.Local.Items != 1 || stats.Tiers.Local.HitRatio != 0.5 {
+			t.Fatalf("%s stats = %+v", p.member.Name, stats)
+		}
+	}
+
+	do(t, http.MethodDelete, cluster[0].api+"/users/42", http.StatusNoContent)
+	for _, p := range cluster {
+		if stats := getStats(t, p); stats.Local.Items != 0 {
+			t.Fatalf("%s still caches the key: %+v", p.member.Name, stats)
+		}
+	}
+	// The next read misses the local tier and loads the key again.
+	do(t, http.MethodGet, cluster[1].api+"/users/42", http.StatusOK)
+	if stats := getStats(t, cluster[1]); stats.Local.Hits != 1 || stats.Local.Items != 1 {
+		t.Fatalf("stats = %+v", getStats(t, cluster[1]))
+	}
+}
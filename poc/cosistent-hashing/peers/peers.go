@@ -0,0 +1,84 @@
+// Package peers keeps the peers of a groupcache HTTPPool in sync with the members of a
+// distributed-lb ring, so peers come and go with the ADD and REMOVE messages of the
+// coordinator instead of a hardcoded list.
+package peers
+
+import (
+	"distributed-lb/client"
+	"distributed-lb/hash"
+	"distributed-lb/message"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Pool is the part of groupcache.HTTPPool that is kept in sync.
+type Pool interface {
+	Set(peers ...string)
+}
+
+// URL returns the base URL of the member as groupcache expects it, e.g.
+// "http://10.0.0.2:8101". The scheme is http unless the member protocol is https.
+func URL(m hash.Member) (string, error) {
+	if m.Host == "" || m.Port == 0 {
+		return "", fmt.Errorf("member %s has no address", m.Name)
+	}
+	scheme := "http"
+	if strings.EqualFold(m.Protocol, "https") {
+		scheme = "https"
+	}
+	u := url.URL{Scheme: scheme, Host: net.JoinHostPort(m.Host, strconv.Itoa(m.Port))}
+	return u.String(), nil
+}
+
+// Discovery sets the peers of a pool from the members of a ring.
+type Discovery struct {
+	pool Pool
+
+	mu    sync.Mutex
+	peers []string
+}
+
+func New(pool Pool) *Discovery {
+	return &Discovery{pool: pool}
+}
+
+// Apply makes the members the peers of the pool. Members without an address are
+// skipped. An empty ring leaves the pool empty and every key is loaded locally.
+func (d *Discovery) Apply(members []hash.Member) {
+	peers := make([]string, 0, len(members))
+	for _, m := range members {
+		u, err := URL(m)
+		if err != nil {
+			log.Println("Skipping peer: ", err)
+			continue
+		}
+		peers = append(peers, u)
+	}
+	sort.Strings(peers)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.peers = peers
+	d.pool.Set(peers...)
+}
+
+// Peers returns the current peers, sorted.
+func (d *Discovery) Peers() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.peers...)
+}
+
+// Follow applies the members of the client's ring after every membership change.
+// The URL of this instance in the pool must match its member address in the ring.
+func (d *Discovery) Follow(c *client.Client) {
+	c.OnChange(func(message.Message) {
+		d.Apply(c.GetMembers())
+	})
+}
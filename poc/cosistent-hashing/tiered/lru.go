@@ -0,0 +1,101 @@
+// Package tiered puts a small in-process LRU in front of a groupcache group, so hot
+// keys are served without a hop to their owner peer, and invalidates a key on the
+// owner and in the local tier of every peer.
+package tiered
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// LRU is a size bounded cache whose entries expire after a TTL.
+type LRU struct {
+	mu    sync.Mutex
+	max   int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+	stats LRUStats
+}
+
+// LRUStats counts the lookups of an LRU.
+type LRUStats struct {
+	Gets      int64 `json:"gets"`
+	Hits      int64 `json:"hits"`
+	Evictions int64 `json:"evictions"`
+	Expired   int64 `json:"expired"`
+	Items     int64 `json:"items"`
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewLRU creates an LRU holding up to max entries for ttl each.
+func NewLRU(max int, ttl time.Duration) *LRU {
+	return &LRU{max: max, ttl: ttl, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns the value of the key unless it is missing or expired.
+func (c *LRU) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Gets++
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if !time.Now().Before(e.expires) {
+		c.removeElement(el)
+		c.stats.Expired++
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	c.stats.Hits++
+	return e.value, true
+}
+
+// Set stores the value for a TTL, evicting the least recently used entry when full.
+func (c *LRU) Set(key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	expires := time.Now().Add(c.ttl)
+	if el, ok := c.items[key]; ok {
+		e := el.Value.(*entry)
+		e.value, e.expires = value, expires
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&entry{key: key, value: value, expires: expires})
+	if c.ll.Len() > c.max {
+		c.removeElement(c.ll.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Remove drops the key.
+func (c *LRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*entry).key)
+}
+
+// Stats returns the counters of the LRU.
+func (c *LRU) Stats() LRUStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := c.stats
+	stats.Items = int64(c.ll.Len())
+	return stats
+}
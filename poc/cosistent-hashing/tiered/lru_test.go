@@ -0,0 +1,46 @@
+package tiered
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	c := NewLRU(2, time.Minute)
+	c.Set("a", []byte("1"))
+	c.Set("b", []byte("2"))
+	c.Get("a")
+	c.Set("c", []byte("3"))
+
+	if _, ok := c.Get("b"); ok {
+		t.Fatal("b should have been evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Fatalf("%s missing", key)
+		}
+	}
+	c.Remove("a")
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should have been removed")
+	}
+	stats := c.Stats()
+	if stats.Gets != 5 || stats.Hits != 3 || stats.Evictions != 1 || stats.Items != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestLRUExpires(t *testing.T) {
+	c := NewLRU(10, 20*time.Millisecond)
+	c.Set("a", []byte("1"))
+	if v, ok := c.Get("a"); !ok || string(v) != "1" {
+		t.Fatalf("Get = %q, %v", v, ok)
+	}
+	time.Sleep(30 * time.Millisecond)
+	if _, ok := c.Get("a"); ok {
+		t.Fatal("a should have expired")
+	}
+	if stats := c.Stats(); stats.Expired != 1 || stats.Items != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -0,0 +1,187 @@
+package tiered
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mailgun/groupcache/v2"
+)
+
+// InvalidatePath is served on the peer listener, next to the groupcache pool:
+// DELETE /_tier/<key> drops the key from the local tier of the peer.
+const InvalidatePath = "/_tier/"
+
+// Cache is a local LRU tier in front of a groupcache group.
+type Cache struct {
+	local *LRU
+	group *groupcache.Group
+	// self and peers are the base URLs of the groupcache peers, as given to the pool.
+	self       string
+	peers      func() []string
+	httpClient *http.Client
+
+	// fills are the Gets loading a key from groupcache. An invalidation marks them
+	// stale, so a value loaded before it doesn't go back in the local tier.
+	mu    sync.Mutex
+	fills map[string]*fill
+}
+
+type fill struct {
+	gets  int
+	stale bool
+}
+
+// New creates the cache. peers returns the current peers of the pool, self included.
+func New(group *groupcache.Group, local *LRU, self string, peers func() []string) *Cache {
+	return &Cache{local: local, group: group, self: self, peers: peers, httpClient: &http.Client{}, fills: make(map[string]*fill)}
+}
+
+// Get returns the value from the local tier, or from groupcache which asks the owner
+// peer, and keeps it in the local tier unless the key was invalidated meanwhile.
+func (c *Cache) Get(ctx context.Context, key string) ([]byte, error) {
+	if value, ok := c.local.Get(key); ok {
+		return value, nil
+	}
+	c.mu.Lock()
+	f, ok := c.fills[key]
+	if !ok {
+		f = &fill{}
+		c.fills[key] = f
+	}
+	f.gets++
+	c.mu.Unlock()
+
+	var value []byte
+	err := c.group.Get(ctx, key, groupcache.AllocatingByteSliceSink(&value))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f.gets--; f.gets == 0 {
+		delete(c.fills, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !f.stale {
+		c.local.Set(key, value)
+	}
+	return value, nil
+}
+
+// removeLocal drops the key from the local tier and from the Gets loading it.
+func (c *Cache) removeLocal(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if f, ok := c.fills[key]; ok {
+		f.stale = true
+	}
+	c.local.Remove(key)
+}
+
+// Invalidate removes the key from the owner and the groupcache caches of the peers,
+// then from the local tier of every peer.
+func (c *Cache) Invalidate(ctx context.Context, key string) error {
+	// groupcache first, so a Get starting meanwhile loads the new value. The Gets that
+	// loaded the old value before are kept from storing it by removeLocal.
+	err := c.group.Remove(ctx, key)
+	c.removeLocal(key)
+
+	var mu sync.Mutex
+	errs := []error{err}
+	var wg sync.WaitGroup
+	for _, peer := range c.peers() {
+		if peer == c.self {
+			continue
+		}
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := c.invalidatePeer(ctx, peer, key); err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+			}
+		}(peer)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+func (c *Cache) invalidatePeer(ctx context.Context, peer, key string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, peer+InvalidatePath+url.PathEscape(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("invalidating %s on %s: %s", key, peer, resp.Status)
+	}
+	return nil
+}
+
+// ServeHTTP drops a key from the local tier on behalf of another peer.
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	key, err := url.PathUnescape(strings.TrimPrefix(r.URL.EscapedPath(), InvalidatePath))
+	if err != nil || key == "" {
+		http.Error(w, "invalid key", http.StatusBadRequest)
+		return
+	}
+	c.removeLocal(key)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// TierStats is the hit ratio of one tier.
+type TierStats struct {
+	Gets     int64   `json:"gets"`
+	Hits     int64   `json:"hits"`
+	HitRatio float64 `json:"hitRatio"`
+	Items    int64   `json:"items"`
+}
+
+func tierStats(gets, hits, items int64) TierStats {
+	s := TierStats{Gets: gets, Hits: hits, Items: items}
+	if gets > 0 {
+		s.HitRatio = float64(hits) / float64(gets)
+	}
+	return s
+}
+
+// Stats reports every tier: the local LRU, the groupcache hot cache holding the keys
+// of other peers and the main cache holding the keys this peer owns.
+type Stats struct {
+	Local LRUStats `json:"local"`
+	Tiers struct {
+		Local TierStats `json:"local"`
+		Hot   TierStats `json:"hot"`
+		Main  TierStats `json:"main"`
+	} `json:"tiers"`
+	// PeerLoads and LocalLoads count the misses loaded from the owner peer and from the getter.
+	PeerLoads  int64 `json:"peerLoads"`
+	LocalLoads int64 `json:"localLoads"`
+}
+
+func (c *Cache) Stats() Stats {
+	var s Stats
+	s.Local = c.local.Stats()
+	s.Tiers.Local = tierStats(s.Local.Gets, s.Local.Hits, s.Local.Items)
+	hot := c.group.CacheStats(groupcache.HotCache)
+	s.Tiers.Hot = tierStats(hot.Gets, hot.Hits, hot.Items)
+	main := c.group.CacheStats(groupcache.MainCache)
+	s.Tiers.Main = tierStats(main.Gets, main.Hits, main.Items)
+	s.PeerLoads = c.group.Stats.PeerLoads.Get()
+	s.LocalLoads = c.group.Stats.LocalLoads.Get()
+	return s
+}
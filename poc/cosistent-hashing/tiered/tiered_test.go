@@ -0,0 +1,52 @@
+package tiered
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mailgun/groupcache/v2"
+)
+
+// TestInvalidateDuringGet invalidates a key while a Get is loading its old value; the
+// old value must not be kept in the local tier.
+func TestInvalidateDuringGet(t *testing.T) {
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	value := "old"
+	group := groupcache.NewGroup("tiered-invalidate-during-get", 1<<20, groupcache.GetterFunc(
+		func(ctx context.Context, key string, dest groupcache.Sink) error {
+			v := value
+			if v == "old" {
+				close(loading)
+				<-release
+			}
+			return dest.SetString(v, time.Time{})
+		}))
+	c := New(group, NewLRU(10, time.Minute), "http://self", func() []string { return []string{"http://self"} })
+
+	got := make(chan string)
+	go func() {
+		v, err := c.Get(context.Background(), "k")
+		if err != nil {
+			t.Error(err)
+		}
+		got <- string(v)
+	}()
+	<-loading
+	value = "new"
+	if err := c.Invalidate(context.Background(), "k"); err != nil {
+		t.Fatal(err)
+	}
+	close(release)
+	if v := <-got; v != "old" {
+		t.Fatalf("the Get started before the invalidation returned %q", v)
+	}
+
+	if v, ok := c.local.Get("k"); ok {
+		t.Fatalf("the local tier kept %q loaded before the invalidation", v)
+	}
+	if len(c.fills) != 0 {
+		t.Fatalf("%d fills left", len(c.fills))
+	}
+}
@@ -8,6 +8,8 @@ import (
 	"log"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"distributed-lb/hash"
@@ -17,12 +19,21 @@ import (
 )
 
 type Client struct {
-	consistent         *hash.Consistent
+	// consistent, isConnectionActive and lastMessage are written by the listening
+	// goroutine and read by the lookups, each lookup loads the ring once.
+	consistent         atomic.Pointer[hash.Consistent]
 	httpClient         *http.Client
 	backOff            *backoff.ExponentialBackOff
-	isConnectionActive bool
-	connectionTimeout  time.Duration
-	url                string
+	isConnectionActive atomic.Bool
+	// lastMessage is the time of the last message from the coordinator in unix nanoseconds.
+	lastMessage       atomic.Int64
+	connectionTimeout time.Duration
+	url               string
+
+	mu       sync.Mutex
+	onChange []func(message.Message)
+
+	reads coalescer
 }
 
 func New(url string) *Client {
@@ -49,6 +60,7 @@ func New(url string) *Client {
 		backOff:    bo,
 		url:        url,
 		connectionTimeout: time.Minute,
+		reads:      coalescer{timeout: DefaultReadTimeout},
 	}
 }
 
@@ -56,7 +68,7 @@ func (client *Client) Run(cancel context.CancelCauseFunc) {
 	for {
 		err := client.listen()
 		if err != nil {
-			client.isConnectionActive = false
+			client.isConnectionActive.Store(false)
 			log.Println("Error: ", err)
 			log.Println("Retrying....")
 			timeout := client.backOff.NextBackOff()
@@ -85,7 +97,7 @@ func (client *Client) listen() error {
 	}
 	defer response.Body.Close()
 
-	client.isConnectionActive = true
+	client.isConnectionActive.Store(true)
 	// Check if the server supports Server-Sent Events
 	if response.Header.Get("Content-Type") != "text/event-stream" {
 		log.Fatal("Server does not support Server-Sent Events")
@@ -93,6 +105,7 @@ func (client *Client) listen() error {
 	reader := bufio.NewReader(response.Body)
 	for {
 		client.backOff.Reset()
+		client.lastMessage.Store(time.Now().UnixNano())
 		var msg message.Message
 		line, err := reader.ReadString('\n')
 		if err != nil {
@@ -105,18 +118,93 @@ func (client *Client) listen() error {
 			return err
 		}
 
-		client.consistent = msg.Update(client.consistent)
+		c, err := msg.Update(client.consistent.Load())
+		client.consistent.Store(c)
+		if err != nil {
+			return err
+		}
+		if msg.Command != message.HEALTHCHECK {
+			client.notify(msg)
+		}
+	}
+}
+
+// ring returns the current ring, nil before the first INIT or once the coordinator
+// has been unreachable for longer than the connection timeout.
+func (client *Client) ring() *hash.Consistent {
+	c := client.consistent.Load()
+	if c == nil {
+		return nil
 	}
+	lost := time.Since(time.Unix(0, client.lastMessage.Load()))
+	if !client.isConnectionActive.Load() && lost > client.connectionTimeout {
+		return nil
+	}
+	return c
 }
 
 func (client *Client) LocateKey(key []byte) (hash.Member, error) {
-	if client.consistent == nil ||
-		(!client.isConnectionActive && client.backOff.GetElapsedTime() > client.connectionTimeout) {
+	c := client.ring()
+	if c == nil {
 		return hash.Member{}, errors.New("Cluster error: Unable to fetch cluster information")
 	}
-	m := client.consistent.LocateKey(key)
+	m := c.LocateKey(key)
 	if m.Name == "" {
 		return m, errors.New("Hash Error: Node not found for the key")
 	}
 	return m, nil
 }
+
+// OnChange registers fn to be called after every INIT, ADD, REMOVE or UPDATE message
+// from the coordinator has been applied, including metadata only changes. fn is called
+// from the listening goroutine and should not block.
+func (client *Client) OnChange(fn func(message.Message)) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	client.onChange = append(client.onChange, fn)
+}
+
+func (client *Client) notify(msg message.Message) {
+	client.mu.Lock()
+	fns := client.onChange
+	client.mu.Unlock()
+	for _, fn := range fns {
+		fn(msg)
+	}
+}
+
+// GetMembers returns the members known to the client with their metadata.
+func (client *Client) GetMembers() []hash.Member {
+	c := client.consistent.Load()
+	if c == nil {
+		return nil
+	}
+	return c.GetMembers()
+}
+
+// FindPartitionID returns the partition of the key, or -1 before the first INIT.
+func (client *Client) FindPartitionID(key []byte) int {
+	c := client.consistent.Load()
+	if c == nil {
+		return -1
+	}
+	return c.FindPartitionID(key)
+}
+
+// GetClosestN returns the owner of the key followed by its closest replicas.
+func (client *Client) GetClosestN(key []byte, count int) ([]hash.Member, error) {
+	c := client.consistent.Load()
+	if c == nil {
+		return nil, errors.New("Cluster error: Unable to fetch cluster information")
+	}
+	return c.GetClosestN(key, count)
+}
+
+// GetClosestNForPartition returns the owner of the partition followed by its closest replicas.
+func (client *Client) GetClosestNForPartition(partID, count int) ([]hash.Member, error) {
+	c := client.consistent.Load()
+	if c == nil {
+		return nil, errors.New("Cluster error: Unable to fetch cluster information")
+	}
+	return c.GetClosestNForPartition(partID, count)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"distributed-lb/hash"
+	"distributed-lb/message"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// TestLookupsDuringMembershipStream is meant to be run with -race. The lookups of the
+// servers run while the listening goroutine applies the messages of the coordinator.
+func TestLookupsDuringMembershipStream(t *testing.T) {
+	members := hash.MemberList{{Name: "node0"}, {Name: "node1"}, {Name: "node2"}}
+	extra := hash.MemberList{{Name: "node3"}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		enc := json.NewEncoder(w)
+		send := func(msg message.Message) {
+			enc.Encode(msg)
+			w.(http.Flusher).Flush()
+		}
+		send(message.Message{Command: message.INIT, Members: members, PartitionCount: 71, ReplicationFactor: 20, Load: 1.25})
+		for i := 0; i < 100; i++ {
+			send(message.Message{Command: message.ADD, Members: extra})
+			send(message.Message{Command: message.REMOVE, Members: extra})
+			// A new INIT replaces the ring the lookups may be using.
+			if i%10 == 0 {
+				send(message.Message{Command: message.INIT, Members: members, PartitionCount: 71, ReplicationFactor: 20, Load: 1.25})
+			}
+		}
+	}))
+	defer srv.Close()
+
+	c := New(srv.URL)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			key := []byte("key")
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if c.consistent.Load() == nil {
+					continue
+				}
+				if m, err := c.LocateKey(key); err != nil || m.Name == "" {
+					t.Errorf("LocateKey = %v, %v", m, err)
+					return
+				}
+				if replicas, err := c.GetClosestN(key, 2); err != nil || len(replicas) != 2 {
+					t.Errorf("GetClosestN = %v, %v", replicas, err)
+					return
+				}
+				if _, err := c.GetClosestNForPartition(c.FindPartitionID(key), 2); err != nil {
+					t.Errorf("GetClosestNForPartition: %v", err)
+					return
+				}
+				if got := len(c.GetMembers()); got != 3 && got != 4 {
+					t.Errorf("GetMembers returned %d members", got)
+					return
+				}
+			}
+		}()
+	}
+
+	// listen returns once the coordinator ends the stream.
+	c.listen()
+	close(done)
+	wg.Wait()
+	if got := len(c.GetMembers()); got != 3 {
+		t.Fatalf("the client has %d members after the stream, want 3", got)
+	}
+}
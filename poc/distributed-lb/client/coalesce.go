@@ -0,0 +1,167 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultReadTimeout bounds a coalesced read when SetReadTimeout wasn't called.
+const DefaultReadTimeout = 5 * time.Second
+
+// Response is the answer of the owner to a read. Callers get their own copy.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+func (r *Response) clone() *Response {
+	body := make([]byte, len(r.Body))
+	copy(body, r.Body)
+	return &Response{StatusCode: r.StatusCode, Header: r.Header.Clone(), Body: body}
+}
+
+// CoalesceStats counts the reads made through Get and how many of them shared an
+// upstream call that was already in flight.
+type CoalesceStats struct {
+	Requests  int64
+	Upstream  int64
+	Coalesced int64
+}
+
+// Ratio returns the share of the reads that didn't reach the owner themselves.
+func (s CoalesceStats) Ratio() float64 {
+	if s.Requests == 0 {
+		return 0
+	}
+	return float64(s.Coalesced) / float64(s.Requests)
+}
+
+// flight is an upstream read shared by every caller asking for the same url meanwhile.
+type flight struct {
+	done chan struct{}
+	resp *Response
+	err  error
+}
+
+type coalescer struct {
+	mu      sync.Mutex
+	flights map[string]*flight
+	timeout time.Duration
+	// timeoutFor overrides timeout for some keys and paths, when it returns more than zero.
+	timeoutFor func(key []byte, path string) time.Duration
+
+	requests  atomic.Int64
+	upstream  atomic.Int64
+	coalesced atomic.Int64
+}
+
+// readTimeout returns the timeout of an upstream read of the path for the key. The
+// function of SetReadTimeoutFunc runs without the lock, it may call the setters.
+func (c *coalescer) readTimeout(key []byte, path string) time.Duration {
+	c.mu.Lock()
+	timeout, timeoutFor := c.timeout, c.timeoutFor
+	c.mu.Unlock()
+	if timeoutFor != nil {
+		if t := timeoutFor(key, path); t > 0 {
+			return t
+		}
+	}
+	return timeout
+}
+
+// do runs fn once for all the concurrent callers of the same key. fn gets its own
+// timeout, the one of the caller that started the flight, rather than its context,
+// so a caller giving up doesn't fail the others; each caller still stops waiting when
+// its ctx is done.
+func (c *coalescer) do(ctx context.Context, key string, timeout time.Duration, fn func(context.Context) (*Response, error)) (*Response, error) {
+	c.requests.Add(1)
+	c.mu.Lock()
+	if c.flights == nil {
+		c.flights = make(map[string]*flight)
+	}
+	f, ok := c.flights[key]
+	if ok {
+		c.coalesced.Add(1)
+	} else {
+		f = &flight{done: make(chan struct{})}
+		c.flights[key] = f
+		c.upstream.Add(1)
+		go func() {
+			fctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			f.resp, f.err = fn(fctx)
+			c.mu.Lock()
+			delete(c.flights, key)
+			c.mu.Unlock()
+			close(f.done)
+		}()
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.resp.clone(), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// SetReadTimeout sets the timeout of each upstream read made by Get.
+func (client *Client) SetReadTimeout(timeout time.Duration) {
+	client.reads.mu.Lock()
+	defer client.reads.mu.Unlock()
+	client.reads.timeout = timeout
+}
+
+// SetReadTimeoutFunc sets the timeout of the upstream reads made by Get per key and
+// path, e.g. longer for the paths of large values. Reads for which fn returns zero use
+// the timeout of SetReadTimeout, a nil fn removes the override.
+func (client *Client) SetReadTimeoutFunc(fn func(key []byte, path string) time.Duration) {
+	client.reads.mu.Lock()
+	defer client.reads.mu.Unlock()
+	client.reads.timeoutFor = fn
+}
+
+// CoalesceStats returns the counters of the reads made through Get.
+func (client *Client) CoalesceStats() CoalesceStats {
+	return CoalesceStats{
+		Requests:  client.reads.requests.Load(),
+		Upstream:  client.reads.upstream.Load(),
+		Coalesced: client.reads.coalesced.Load(),
+	}
+}
+
+// Get sends a GET for path to the owner of the key. Concurrent Gets of the same path
+// on the same owner share a single upstream request, so only use it for idempotent reads.
+func (client *Client) Get(ctx context.Context, key []byte, path string) (*Response, error) {
+	m, err := client.LocateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	url := "http://" + m.Address() + path
+	timeout := client.reads.readTimeout(key, path)
+	return client.reads.do(ctx, url, timeout, func(ctx context.Context) (*Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := client.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &Response{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, nil
+	})
+}
@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"distributed-lb/hash"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client whose ring has a single member served by handler.
+func newTestClient(t *testing.T, handler http.Handler) *Client {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	ring, err := hash.New([]hash.Member{{Name: "node0", Host: host, Port: p}}, hash.Config{PartitionCount: 7, ReplicationFactor: 5, Load: 1.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New("")
+	c.consistent.Store(ring)
+	c.isConnectionActive.Store(true)
+	return c
+}
+
+func TestGetCoalescesConcurrentReads(t *testing.T) {
+	var hits atomic.Int64
+	release := make(chan struct{})
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		<-release
+		w.Write([]byte("value of " + r.URL.Path))
+	}))
+
+	const callers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := c.Get(context.Background(), []byte("hot"), "/kv/hot")
+			if err == nil && string(resp.Body) != "value of /kv/hot" {
+				err = errors.New("unexpected body " + string(resp.Body))
+			}
+			errs <- err
+		}()
+	}
+	// Let every caller join the flight before the owner answers.
+	for c.CoalesceStats().Requests < callers {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if hits.Load() != 1 {
+		t.Fatalf("owner got %d requests, want 1", hits.Load())
+	}
+	stats := c.CoalesceStats()
+	if stats.Upstream != 1 || stats.Coalesced != callers-1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+	if ratio := stats.Ratio(); ratio != float64(callers-1)/callers {
+		t.Fatalf("ratio = %v", ratio)
+	}
+
+	// Once the flight landed, the next read goes upstream again.
+	if _, err := c.Get(context.Background(), []byte("hot"), "/kv/hot"); err != nil {
+		t.Fatal(err)
+	}
+	if hits.Load() != 2 {
+		t.Fatalf("owner got %d requests, want 2", hits.Load())
+	}
+}
+
+func TestGetTimeout(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer close(release)
+	c.SetReadTimeout(20 * time.Millisecond)
+
+	if _, err := c.Get(context.Background(), []byte("slow"), "/kv/slow"); err == nil {
+		t.Fatal("expected the read to time out")
+	}
+
+	// The context of the caller still bounds its own wait.
+	c.SetReadTimeout(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := c.Get(ctx, []byte("slow"), "/kv/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestGetTimeoutPerKey(t *testing.T) {
+	release := make(chan struct{})
+	c := newTestClient(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer close(release)
+	c.SetReadTimeout(time.Minute)
+	c.SetReadTimeoutFunc(func(key []byte, path string) time.Duration {
+		// The function may change the timeouts itself.
+		c.SetReadTimeout(time.Minute)
+		if string(key) == "slow" {
+			return 20 * time.Millisecond
+		}
+		return 0
+	})
+
+	start := time.Now()
+	if _, err := c.Get(context.Background(), []byte("slow"), "/kv/slow"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Get = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("the read took %v, the timeout of the key was ignored", elapsed)
+	}
+
+	// The other keys keep the timeout of SetReadTimeout: their flight outlives the
+	// caller and the next read joins it.
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		c.Get(ctx, []byte("other"), "/kv/other")
+		cancel()
+	}
+	if stats := c.CoalesceStats(); stats.Upstream != 2 || stats.Coalesced != 1 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
@@ -1,12 +1,14 @@
 package coordinator
 
 import (
+	"context"
 	"distributed-lb/hash"
 	"distributed-lb/message"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"sync"
 	"time"
 )
@@ -15,226 +17,207 @@ const (
 	PartitionCount    = 16000
 	ReplicationFactor = 1000
 	Load              = 1.2
+	ReplicaCount      = 3
+
+	// DefaultRing is served on "/" so that clients that don't name a ring keep working.
+	DefaultRing = "default"
+	// ringPath is followed by the ring name in the SSE URL, e.g. /rings/orders.
+	ringPath = "/rings/"
 )
 
+// ErrUnknownRing is returned by the member methods when the coordinator has no default ring.
+var ErrUnknownRing = errors.New("unknown ring")
+
 type Listeners struct {
 	Id      int64
 	Message chan message.Message
 }
 
+// Coordinator hosts named rings and streams their membership changes to the
+// clients subscribed to them.
 type Coordinator struct {
-	listeners          []*Listeners
 	mu                 sync.RWMutex
-	sequence           int64
-	consistent         *hash.Consistent
-	config             hash.Config
+	rings              map[string]*Ring
 	healthCheckTimeout time.Duration
-	StateFile          string
+	server             *http.Server
+	// done is closed by Shutdown to end the streams and the health checks.
+	done      chan struct{}
+	closeOnce sync.Once
 }
 
-func New(members []hash.Member) *Coordinator {
-	coord := Coordinator{
-		config: hash.Config{
-			PartitionCount:    PartitionCount,
-			ReplicationFactor: ReplicationFactor,
-			Load:              Load,
-		},
+// DefaultConfig returns the ring config used by New.
+func DefaultConfig() hash.Config {
+	return hash.Config{
+		PartitionCount:    PartitionCount,
+		ReplicationFactor: ReplicationFactor,
+		Load:              Load,
+		ReplicaCount:      ReplicaCount,
+	}
+}
+
+// New creates a coordinator with the default ring holding members and starts serving it.
+func New(members []hash.Member) (*Coordinator, error) {
+	coord := NewEmpty()
+	if _, err := coord.AddRing(DefaultRing, members, DefaultConfig(), "members.json"); err != nil {
+		return nil, err
+	}
+	coord.Start("8081") // TODO: Read it from env
+	return coord, nil
+}
+
+// NewEmpty creates a coordinator without any ring. It doesn't listen until Start is called,
+// the Coordinator can also be mounted on another server as an http.Handler.
+func NewEmpty() *Coordinator {
+	return &Coordinator{
+		rings:              make(map[string]*Ring),
 		healthCheckTimeout: time.Minute,
-		StateFile:          "members.json",
+		done:               make(chan struct{}),
+	}
+}
+
+// AddRing creates a ring with its own members, config and state file. The state file
+// defaults to "<name>.json".
+func (coord *Coordinator) AddRing(name string, members []hash.Member, config hash.Config, stateFile string) (*Ring, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return nil, fmt.Errorf("invalid ring name %q", name)
+	}
+	if stateFile == "" {
+		stateFile = name + ".json"
+	}
+	coord.mu.Lock()
+	defer coord.mu.Unlock()
+	if _, exists := coord.rings[name]; exists {
+		return nil, fmt.Errorf("ring %s already exists", name)
+	}
+	ring, err := newRing(name, members, config, stateFile)
+	if err != nil {
+		return nil, fmt.Errorf("ring %s: %w", name, err)
 	}
-	oldMembers := coord.readPreviousState()
-	//fmt.Println("Old Members: ", oldMembers)
-	c := hash.New(oldMembers, coord.config)
-	oldP := c.GetPartitionList()
-	coord.consistent = hash.New(members, coord.config)
-	fmt.Println("Delete Partition: ", coord.rePartition(oldP))
-	coord.saveState()
+	coord.rings[name] = ring
+	return ring, nil
+}
+
+// Ring returns the named ring, or nil if there is no such ring.
+func (coord *Coordinator) Ring(name string) *Ring {
+	coord.mu.RLock()
+	defer coord.mu.RUnlock()
+	return coord.rings[name]
+}
 
-	coord.addHttpHandler()
+// Start serves the coordinator on the port and starts the health checks.
+func (coord *Coordinator) Start(port string) {
 	go coord.healthCheck()
 
-	port := "8081" // TODO: Read it from env
 	server := &http.Server{
-		Addr: ":" + port,
+		Addr:    ":" + port,
+		Handler: coord,
 		//WriteTimeout: time.Second * 60,
 	}
+	// The streams never end on their own, they are ended once the server stopped accepting.
+	server.RegisterOnShutdown(coord.closeStreams)
+	coord.mu.Lock()
+	coord.server = server
+	coord.mu.Unlock()
 	go func() {
-		// Start the server on port 8080
 		fmt.Println("Server is running on http://localhost:" + port)
 		if err := server.ListenAndServe(); err != nil {
 			fmt.Println(err)
 		}
 	}()
+}
+
+func (coord *Coordinator) closeStreams() {
+	coord.closeOnce.Do(func() { close(coord.done) })
+}
 
-	return &coord
+// Shutdown stops accepting connections if the coordinator was started, ends the
+// streams of the listeners and waits for them to return until the context is done.
+// The ring states are saved on every change, there is nothing to flush.
+func (coord *Coordinator) Shutdown(ctx context.Context) error {
+	coord.mu.RLock()
+	server := coord.server
+	coord.mu.RUnlock()
+	if server == nil {
+		coord.closeStreams()
+		return nil
+	}
+	return server.Shutdown(ctx)
 }
 
 func (coord *Coordinator) healthCheck() {
 	for {
-		m := message.Message{
-			Command: message.HEALTHCHECK,
+		coord.mu.RLock()
+		rings := make([]*Ring, 0, len(coord.rings))
+		for _, ring := range coord.rings {
+			rings = append(rings, ring)
+		}
+		coord.mu.RUnlock()
+		for _, ring := range rings {
+			ring.healthCheck()
+		}
+		select {
+		case <-time.After(coord.healthCheckTimeout):
+		case <-coord.done:
+			return
 		}
-		coord.mu.Lock()
-		coord.broadCast(m)
-		coord.mu.Unlock()
-		time.Sleep(coord.healthCheckTimeout)
 	}
 }
 
-func (coord *Coordinator) addHttpHandler() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Set the Content-Type header to text/event-stream
-		w.Header().Set("Content-Type", "text/event-stream")
-		// Set the Cache-Control header to prevent caching
-		w.Header().Set("Cache-Control", "no-cache")
-		// Enable CORS (Cross-Origin Resource Sharing)
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Connection", "keep-alive")
-		listener := Listeners{
-			Message: make(chan message.Message),
+// ServeHTTP streams the membership of the ring named in the URL, /rings/<name>.
+// "/" streams the default ring.
+func (coord *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := DefaultRing
+	if r.URL.Path != "/" {
+		if !strings.HasPrefix(r.URL.Path, ringPath) {
+			http.NotFound(w, r)
+			return
 		}
-		go coord.AddListener(&listener)
+		name = strings.TrimPrefix(r.URL.Path, ringPath)
+	}
+	ring := coord.Ring(name)
+	if ring == nil {
+		http.Error(w, "ring not found: "+name, http.StatusNotFound)
+		return
+	}
 
-		for {
-			m := <-listener.Message
+	// Set the Content-Type header to text/event-stream
+	w.Header().Set("Content-Type", "text/event-stream")
+	// Set the Cache-Control header to prevent caching
+	w.Header().Set("Cache-Control", "no-cache")
+	// Enable CORS (Cross-Origin Resource Sharing)
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Connection", "keep-alive")
+	// The buffer holds the INIT message so AddListener doesn't wait for this loop.
+	listener := Listeners{
+		Message: make(chan message.Message, 1),
+	}
+	ring.AddListener(&listener)
+
+loop:
+	for {
+		select {
+		case m := <-listener.Message:
 			j, _ := json.Marshal(&m)
 			_, err := fmt.Fprintf(w, "%s\n", j)
 			if err != nil {
 				fmt.Println("Client disconnected : " + err.Error())
-				break
+				break loop
 			}
 			w.(http.Flusher).Flush()
+		case <-r.Context().Done():
+			fmt.Println("Client disconnected : " + context.Cause(r.Context()).Error())
+			break loop
+		case <-coord.done:
+			break loop
 		}
-		coord.RemoveListener(&listener)
-	})
-}
-
-func (coord *Coordinator) AddListener(listener *Listeners) {
-	coord.mu.Lock()
-	defer coord.mu.Unlock()
-	coord.sequence++
-	listener.Id = coord.sequence
-	coord.listeners = append(coord.listeners, listener)
-	m := message.Message{
-		Command:           message.INIT,
-		Time:              time.Now().Format(time.RFC3339),
-		Members:           coord.consistent.GetMembers(),
-		PartitionCount:    PartitionCount,
-		ReplicationFactor: ReplicationFactor,
-		Load:              Load,
-	}
-	listener.Message <- m
-	fmt.Printf("Added Listener %d, Total count: %d\n", listener.Id, len(coord.listeners))
-}
-
-func (coord *Coordinator) RemoveListener(listener *Listeners) {
-	coord.mu.Lock()
-	defer coord.mu.Unlock()
-
-	for i, c := range coord.listeners {
-		if c.Id == listener.Id {
-			close(coord.listeners[i].Message)
-			coord.listeners = append(coord.listeners[:i], coord.listeners[i+1:]...)
-			fmt.Printf("Removed Listener %d, Total count: %d\n", listener.Id, len(coord.listeners))
-			break
-		}
-	}
-}
-
-func (coord *Coordinator) broadCast(message message.Message) {
-	for _, c := range coord.listeners {
-		c.Message <- message
 	}
-}
-
-func (coord *Coordinator) rePartition(old map[int]*hash.Member) map[string][]int {
-	new := coord.consistent.GetPartitionList()
-	// for h := range old {
-	// 	fmt.Println(h, ": ", old[h].Name, ",", new[h].Name)
-	// }
-
-	delete := map[string][]int{}
-	for h := range old {
-		n := old[h].Name
-		if m, exists := new[h]; exists &&
-			m.Name != n && coord.consistent.MemberExists(n) {
-			delete[n] = append(delete[n], h)
+	// A broadcast may be waiting on the listener while holding the ring lock,
+	// keep draining until RemoveListener closes the channel.
+	go func() {
+		for range listener.Message {
 		}
-	}
-	return delete
-}
-
-// func (coord *Coordinator) updateMembers(delete map[string][]int) {
-// 	for hashes, name := range delete {
-// 		// send data to nodes
-// 		// if node is not reachable, then we need to add it
-// 		// potential dead list, if we don't see the node from
-//      // k8s service then we delete it until then we keep trying
-//      // the node in exponential
-// 	}
-
-// }
-
-func (coord *Coordinator) RemoveMember(m hash.Member) {
-	coord.mu.Lock()
-	defer coord.mu.Unlock()
-	coord.consistent.Remove(m.Name)
-	fmt.Println("Removing Node: ", m.Name)
-	msg := message.Message{
-		Command: message.REMOVE,
-		Members: []hash.Member{m},
-	}
-	coord.broadCast(msg)
-	coord.saveState()
-}
-
-func (coord *Coordinator) AddMember(members []hash.Member) {
-	coord.mu.Lock()
-	defer coord.mu.Unlock()
-	oldPartition := coord.consistent.GetPartitionList()
-	for _, m := range members {
-		coord.consistent.Add(m)
-		fmt.Println("Adding Node: ", m.Name)
-	}
-	fmt.Println("Remove Partitions", coord.rePartition(oldPartition))
-	m := message.Message{
-		Command: message.ADD,
-		Members: members}
-	coord.broadCast(m)
-	coord.saveState()
-}
-
-func (coord *Coordinator) saveState() {
-	file, _ := json.Marshal(coord.consistent.GetMembers())
-	err := os.WriteFile(coord.StateFile, file, 0644)
-	if err != nil {
-		panic(err)
-	}
-}
-
-func (coord *Coordinator) readPreviousState() []hash.Member {
-	var members []hash.Member
-	fi, err := os.Stat(coord.StateFile)
-	if err != nil {
-		panic(err)
-	}
-
-	size := fi.Size()
-	if size == 0 {
-		return members
-	}
-	data, err := os.ReadFile(coord.StateFile)
-	if err != nil {
-		panic(err)
-	}
-
-	if data != nil {
-		err = json.Unmarshal(data, &members)
-	}
-	if err != nil {
-		fmt.Println(err)
-	}
-	return members
+	}()
+	ring.RemoveListener(&listener)
 }
 
 func compareLists(oldList, newList []hash.Member) ([]hash.Member, []hash.Member) {
@@ -268,6 +251,47 @@ func compareLists(oldList, newList []hash.Member) ([]hash.Member, []hash.Member)
 	return deleted, added
 }
 
-func (coord *Coordinator) GetMembers() []hash.Member {
-	return coord.consistent.GetMembers()
+// defaultRing returns the default ring, which a coordinator made by NewEmpty may not have.
+func (coord *Coordinator) defaultRing() (*Ring, error) {
+	ring := coord.Ring(DefaultRing)
+	if ring == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownRing, DefaultRing)
+	}
+	return ring, nil
+}
+
+// RemoveMember removes the member from the default ring.
+func (coord *Coordinator) RemoveMember(m hash.Member) error {
+	ring, err := coord.defaultRing()
+	if err != nil {
+		return err
+	}
+	return ring.RemoveMember(m)
+}
+
+// UpdateMember changes the metadata of a member of the default ring.
+func (coord *Coordinator) UpdateMember(m hash.Member) error {
+	ring, err := coord.defaultRing()
+	if err != nil {
+		return err
+	}
+	return ring.UpdateMember(m)
+}
+
+// AddMember adds the members to the default ring.
+func (coord *Coordinator) AddMember(members []hash.Member) error {
+	ring, err := coord.defaultRing()
+	if err != nil {
+		return err
+	}
+	return ring.AddMember(members)
+}
+
+// GetMembers returns the members of the default ring.
+func (coord *Coordinator) GetMembers() ([]hash.Member, error) {
+	ring, err := coord.defaultRing()
+	if err != nil {
+		return nil, err
+	}
+	return ring.GetMembers(), nil
 }
@@ -0,0 +1,123 @@
+package coordinator
+
+import (
+	"bufio"
+	"context"
+	"distributed-lb/hash"
+	"distributed-lb/message"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func subscribe(t *testing.T, url string) *bufio.Reader {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: %s", url, resp.Status)
+	}
+	return bufio.NewReader(resp.Body)
+}
+
+func next(t *testing.T, r *bufio.Reader) message.Message {
+	t.Helper()
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m message.Message
+	if err := json.Unmarshal(line, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRingsAreScoped(t *testing.T) {
+	dir := t.TempDir()
+	cfg := hash.Config{PartitionCount: 71, ReplicationFactor: 10}
+	coord := NewEmpty()
+	orders, err := coord.AddRing("orders", []hash.Member{{Name: "o1"}, {Name: "o2"}}, cfg, filepath.Join(dir, "orders.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := coord.AddRing("users", []hash.Member{{Name: "u1"}}, cfg, filepath.Join(dir, "users.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := coord.AddRing("users", nil, cfg, ""); err == nil {
+		t.Fatal("expected an error for a duplicate ring")
+	}
+
+	srv := httptest.NewServer(coord)
+	t.Cleanup(srv.Close)
+
+	r := subscribe(t, srv.URL+"/rings/orders")
+	init := next(t, r)
+	if init.Command != message.INIT || init.Ring != "orders" || len(init.Members) != 2 || init.PartitionCount != 71 {
+		t.Fatalf("unexpected INIT: %+v", init)
+	}
+
+	if err := users.AddMember([]hash.Member{{Name: "u2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := orders.AddMember([]hash.Member{{Name: "o3"}}); err != nil {
+		t.Fatal(err)
+	}
+	// The users broadcast must not reach the orders listener.
+	add := next(t, r)
+	if add.Command != message.ADD || add.Ring != "orders" || add.Members[0].Name != "o3" {
+		t.Fatalf("unexpected message: %+v", add)
+	}
+
+	resp, err := http.Get(srv.URL + "/rings/unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown ring: %s", resp.Status)
+	}
+}
+
+func TestDefaultRingMethodsWithoutDefaultRing(t *testing.T) {
+	coord := NewEmpty()
+	member := hash.Member{Name: "m1"}
+	if err := coord.AddMember([]hash.Member{member}); !errors.Is(err, ErrUnknownRing) {
+		t.Fatalf("AddMember = %v, want %v", err, ErrUnknownRing)
+	}
+	if err := coord.UpdateMember(member); !errors.Is(err, ErrUnknownRing) {
+		t.Fatalf("UpdateMember = %v, want %v", err, ErrUnknownRing)
+	}
+	if err := coord.RemoveMember(member); !errors.Is(err, ErrUnknownRing) {
+		t.Fatalf("RemoveMember = %v, want %v", err, ErrUnknownRing)
+	}
+	if _, err := coord.GetMembers(); !errors.Is(err, ErrUnknownRing) {
+		t.Fatalf("GetMembers = %v, want %v", err, ErrUnknownRing)
+	}
+}
+
+func TestShutdownEndsStreams(t *testing.T) {
+	coord := NewEmpty()
+	if _, err := coord.AddRing(DefaultRing, []hash.Member{{Name: "n1"}}, hash.Config{PartitionCount: 71, ReplicationFactor: 10}, filepath.Join(t.TempDir(), "members.json")); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(coord)
+	t.Cleanup(srv.Close)
+
+	r := subscribe(t, srv.URL)
+	next(t, r)
+	if err := coord.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.ReadBytes('\n'); err != io.EOF {
+		t.Fatalf("stream after Shutdown: %v, want EOF", err)
+	}
+}
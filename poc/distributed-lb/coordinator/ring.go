@@ -0,0 +1,240 @@
+package coordinator
+
+import (
+	"distributed-lb/hash"
+	"distributed-lb/message"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Ring is a named consistent hash ring with its own members, config, state file
+// and listeners. Broadcasts of a ring only reach the listeners subscribed to it.
+type Ring struct {
+	Name      string
+	StateFile string
+
+	listeners  []*Listeners
+	mu         sync.RWMutex
+	sequence   int64
+	consistent *hash.Consistent
+	config     hash.Config
+}
+
+// newRing creates the ring and compares its members with the ones saved in the state
+// file by the previous run.
+func newRing(name string, members []hash.Member, config hash.Config, stateFile string) (*Ring, error) {
+	ring := Ring{
+		Name:      name,
+		StateFile: stateFile,
+		config:    config,
+	}
+	oldMembers, err := ring.readPreviousState()
+	if err != nil {
+		return nil, err
+	}
+	//fmt.Println("Old Members: ", oldMembers)
+	c, err := hash.New(oldMembers, ring.config)
+	if err != nil {
+		return nil, fmt.Errorf("previous state %s: %w", ring.StateFile, err)
+	}
+	oldP := c.GetPartitionList()
+	ring.consistent, err = hash.New(members, ring.config)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Ring %s, Delete Partition: %v\n", name, ring.rePartition(oldP))
+	if err := ring.saveState(); err != nil {
+		return nil, err
+	}
+	return &ring, nil
+}
+
+func (ring *Ring) AddListener(listener *Listeners) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.sequence++
+	listener.Id = ring.sequence
+	ring.listeners = append(ring.listeners, listener)
+	m := message.Message{
+		Command:           message.INIT,
+		Ring:              ring.Name,
+		Time:              time.Now().Format(time.RFC3339),
+		Members:           ring.consistent.GetMembers(),
+		PartitionCount:    ring.config.PartitionCount,
+		ReplicationFactor: ring.config.ReplicationFactor,
+		Load:              ring.config.Load,
+		ReplicaCount:      ring.config.ReplicaCount,
+	}
+	listener.Message <- m
+	fmt.Printf("Ring %s, Added Listener %d, Total count: %d\n", ring.Name, listener.Id, len(ring.listeners))
+}
+
+func (ring *Ring) RemoveListener(listener *Listeners) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	for i, c := range ring.listeners {
+		if c.Id == listener.Id {
+			close(ring.listeners[i].Message)
+			ring.listeners = append(ring.listeners[:i], ring.listeners[i+1:]...)
+			fmt.Printf("Ring %s, Removed Listener %d, Total count: %d\n", ring.Name, listener.Id, len(ring.listeners))
+			break
+		}
+	}
+}
+
+func (ring *Ring) broadCast(message message.Message) {
+	message.Ring = ring.Name
+	for _, c := range ring.listeners {
+		c.Message <- message
+	}
+}
+
+func (ring *Ring) healthCheck() {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.broadCast(message.Message{
+		Command: message.HEALTHCHECK,
+	})
+}
+
+func (ring *Ring) rePartition(old map[int]*hash.Member) map[string][]int {
+	new := ring.consistent.GetPartitionList()
+	// for h := range old {
+	// 	fmt.Println(h, ": ", old[h].Name, ",", new[h].Name)
+	// }
+
+	delete := map[string][]int{}
+	for h := range old {
+		n := old[h].Name
+		if m, exists := new[h]; exists &&
+			m.Name != n && ring.consistent.MemberExists(n) {
+			delete[n] = append(delete[n], h)
+		}
+	}
+	return delete
+}
+
+// func (ring *Ring) updateMembers(delete map[string][]int) {
+// 	for hashes, name := range delete {
+// 		// send data to nodes
+// 		// if node is not reachable, then we need to add it
+// 		// potential dead list, if we don't see the node from
+//      // k8s service then we delete it until then we keep trying
+//      // the node in exponential
+// 	}
+
+// }
+
+func (ring *Ring) RemoveMember(m hash.Member) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	if err := ring.consistent.Remove(m.Name); err != nil {
+		return err
+	}
+	fmt.Printf("Ring %s, Removing Node: %s\n", ring.Name, m.Name)
+	msg := message.Message{
+		Command: message.REMOVE,
+		Members: []hash.Member{m},
+	}
+	ring.broadCast(msg)
+	return ring.saveState()
+}
+
+// UpdateMember changes the metadata of a member and notifies the listeners. Nothing is
+// broadcast if the metadata is unchanged.
+func (ring *Ring) UpdateMember(m hash.Member) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	current, found := ring.member(m.Name)
+	if !found {
+		return fmt.Errorf("%w: %s", hash.ErrMemberNotFound, m.Name)
+	}
+	if current.Equal(m) {
+		return nil
+	}
+	if err := ring.consistent.Update(m); err != nil {
+		return err
+	}
+	fmt.Printf("Ring %s, Updating Node: %s, %s\n", ring.Name, m.Name, m.Address())
+	ring.broadCast(message.Message{
+		Command: message.UPDATE,
+		Members: []hash.Member{m},
+	})
+	return ring.saveState()
+}
+
+func (ring *Ring) member(name string) (hash.Member, bool) {
+	for _, m := range ring.consistent.GetMembers() {
+		if m.Name == name {
+			return m, true
+		}
+	}
+	return hash.Member{}, false
+}
+
+// AddMember adds the members to the ring and notifies the listeners. Members that are
+// already in the ring are skipped. On error, the members added so far are still broadcast.
+func (ring *Ring) AddMember(members []hash.Member) error {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	oldPartition := ring.consistent.GetPartitionList()
+	added := make([]hash.Member, 0, len(members))
+	var err error
+	for _, m := range members {
+		if err = ring.consistent.Add(m); err != nil {
+			if errors.Is(err, hash.ErrMemberExists) {
+				err = nil
+				continue
+			}
+			break
+		}
+		added = append(added, m)
+		fmt.Printf("Ring %s, Adding Node: %s\n", ring.Name, m.Name)
+	}
+	if len(added) == 0 {
+		return err
+	}
+	fmt.Println("Remove Partitions", ring.rePartition(oldPartition))
+	m := message.Message{
+		Command: message.ADD,
+		Members: added}
+	ring.broadCast(m)
+	return errors.Join(err, ring.saveState())
+}
+
+func (ring *Ring) saveState() error {
+	file, err := json.Marshal(ring.consistent.GetMembers())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(ring.StateFile, file, 0644)
+}
+
+// readPreviousState returns the members saved by the last run. A missing or empty
+// state file means there is no previous state.
+func (ring *Ring) readPreviousState() ([]hash.Member, error) {
+	var members []hash.Member
+	data, err := os.ReadFile(ring.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return members, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return members, nil
+	}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return nil, fmt.Errorf("state file %s: %w", ring.StateFile, err)
+	}
+	return members, nil
+}
+
+func (ring *Ring) GetMembers() []hash.Member {
+	return ring.consistent.GetMembers()
+}
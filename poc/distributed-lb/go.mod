@@ -3,8 +3,14 @@ module distributed-lb
 go 1.21.3
 
 require (
+	github.com/buraksezer/consistent v0.10.0
 	github.com/cenkalti/backoff/v4 v4.2.1
 	github.com/cespare/xxhash v1.1.0
 	github.com/montanaflynn/stats v0.7.1
 	github.com/segmentio/fasthash v1.0.3
 )
+
+require lifecycle v0.0.0-00010101000000-000000000000
+
+// lifecycle is a module of its own next to this one, shared with sse.
+replace lifecycle => ../lifecycle
@@ -36,21 +36,31 @@
 //
 // Now you can create a new Consistent instance. This function can take a list of the members.
 //
-//	c := consistent.New(members, cfg)
+//	c, err := consistent.New(members, cfg)
+//
+// New validates the configuration against the member count first (see Config.Validate) and returns an error
+// instead of panicking when the partitions can't be distributed.
 //
 // In the following sample, you add a new Member to the consistent hash ring. myMember is just a Go struct that
 // implements the Member interface. You should know that modifying the consistent hash ring distributes partitions among
 // members using the algorithm defined on Google Research Blog.
 //
-//	c.Add(myMember)
+//	err := c.Add(myMember)
 //
 // Remove a member from the consistent hash ring:
 //
-//	c.Remove(member-name)
+//	err := c.Remove(member-name)
+//
+// GetClosestN returns the owner of the key's partition followed by the next distinct members found by walking the
+// ring clockwise from the partition's position. These replica lists are calculated along with the partition table
+// for up to Config.ReplicaCount members, so GetClosestN doesn't hash or sort anything.
+//
+//	replicas, err := c.GetClosestN(key, 3)
 //
 // LocateKey hashes the key and calculates partition ID with this modulo operation: MOD(hash result, partition count)
 // The owner of the partition is already calculated by New/Add/Remove. LocateKey just returns the member that is responsible
-// for the key.
+// for the key. New/Add/Remove publish an immutable snapshot of the partition table, so LocateKey and GetPartitionOwner
+// don't take any lock.
 //
 //	key := []byte("my-key")
 //	member := c.LocateKey(key)
@@ -61,9 +71,11 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"net"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 
 	"github.com/cespare/xxhash"
 )
@@ -72,10 +84,33 @@ const (
 	DefaultPartitionCount    int     = 271
 	DefaultReplicationFactor int     = 20
 	DefaultLoad              float64 = 1.25
+	DefaultReplicaCount      int     = 3
 )
 
-// ErrInsufficientMemberCount represents an error which means there are not enough members to complete the task.
-var ErrInsufficientMemberCount = errors.New("insufficient member count")
+var (
+	// ErrInsufficientMemberCount represents an error which means there are not enough members to complete the task.
+	ErrInsufficientMemberCount = errors.New("insufficient member count")
+
+	// ErrInvalidConfig is returned when a Config value is out of range.
+	ErrInvalidConfig = errors.New("invalid config")
+
+	// ErrNotEnoughRoom is returned when the partitions can't be distributed among the members with the
+	// configured load factor. Decrease the partition count, add members or increase the load factor.
+	ErrNotEnoughRoom = errors.New("not enough room to distribute partitions")
+
+	// ErrMemberExists is returned by Add when a member with the same name is already in the ring.
+	ErrMemberExists = errors.New("member already exists")
+
+	// ErrMemberNotFound is returned by Remove when there is no member with the given name.
+	ErrMemberNotFound = errors.New("member not found")
+
+	// ErrTooManyReplicas is returned by GetClosestN when more members are asked for than
+	// Config.ReplicaCount.
+	ErrTooManyReplicas = errors.New("replica count exceeds the configured maximum")
+
+	// ErrInvalidReplicaCount is returned by GetClosestN when less than one member is asked for.
+	ErrInvalidReplicaCount = errors.New("replica count must be at least 1")
+)
 
 // Hasher is responsible for generating unsigned, 64-bit hash of provided byte slice.
 // Hasher should minimize collisions (generating same hash for different byte slice)
@@ -83,15 +118,55 @@ var ErrInsufficientMemberCount = errors.New("insufficient member count")
 // you can use FarmHash family).
 type Hasher func(data []byte) uint64
 
-// Member interface represents a member in consistent hash ring.
+// Member interface represents a member in consistent hash ring. Only the Name is hashed
+// to place the member on the ring, the rest tells the clients how to reach it.
 type Member struct {
 	Name string
+
+	Host     string `json:",omitempty"`
+	Port     int    `json:",omitempty"`
+	Protocol string `json:",omitempty"`
+	// Labels are shared between the copies handed out by Consistent and must not be modified.
+	Labels map[string]string `json:",omitempty"`
 }
 
 func (m Member) String() string {
 	return m.Name
 }
 
+// Address returns the host:port of the member, or the bare host when the port is not set.
+func (m Member) Address() string {
+	if m.Port == 0 {
+		return m.Host
+	}
+	return net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+}
+
+// Equal reports whether both members have the same name and metadata.
+func (m Member) Equal(o Member) bool {
+	if m.Name != o.Name || m.Host != o.Host || m.Port != o.Port ||
+		m.Protocol != o.Protocol || len(m.Labels) != len(o.Labels) {
+		return false
+	}
+	for k, v := range m.Labels {
+		if ov, ok := o.Labels[k]; !ok || ov != v {
+			return false
+		}
+	}
+	return true
+}
+
+func cloneLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+	res := make(map[string]string, len(labels))
+	for k, v := range labels {
+		res[k] = v
+	}
+	return res
+}
+
 type MemberList []Member
 
 func (a MemberList) Len() int           { return len(a) }
@@ -114,24 +189,14 @@ type Config struct {
 
 	// Load is used to calculate average load. See the code, the paper and Google's blog post to learn about it.
 	Load float64
-}
-
-// Consistent holds the information about the members of the consistent hash circle.
-type Consistent struct {
-	mu sync.RWMutex
 
-	config         Config
-	hasher         Hasher
-	sortedSet      []uint64
-	partitionCount uint64
-	loads          map[string]float64
-	members        map[string]*Member
-	partitions     map[int]*Member
-	ring           map[uint64]*Member
+	// ReplicaCount is the maximum N that GetClosestN can be called with. The replica list of
+	// every partition is calculated up to this count on each membership change.
+	ReplicaCount int
 }
 
-// New creates and returns a new Consistent object.
-func New(members []Member, config Config) *Consistent {
+// withDefaults fills the zero values of config with the package defaults.
+func (config Config) withDefaults() Config {
 	if config.Hasher == nil {
 		config.Hasher = xxhash.Sum64
 	}
@@ -144,22 +209,102 @@ func New(members []Member, config Config) *Consistent {
 	if config.Load == 0 {
 		config.Load = DefaultLoad
 	}
+	if config.ReplicaCount == 0 {
+		config.ReplicaCount = DefaultReplicaCount
+	}
+	return config
+}
+
+// Validate checks whether the config, after the defaults are applied, is able to distribute
+// all the partitions among memberCount members. An empty ring is always valid.
+func (config Config) Validate(memberCount int) error {
+	config = config.withDefaults()
+	if config.PartitionCount < 0 {
+		return fmt.Errorf("%w: partition count must be positive, got %d", ErrInvalidConfig, config.PartitionCount)
+	}
+	if config.ReplicationFactor < 0 {
+		return fmt.Errorf("%w: replication factor must be positive, got %d", ErrInvalidConfig, config.ReplicationFactor)
+	}
+	if config.Load < 0 || math.IsNaN(config.Load) || math.IsInf(config.Load, 0) {
+		return fmt.Errorf("%w: load must be a positive number, got %v", ErrInvalidConfig, config.Load)
+	}
+	if config.ReplicaCount < 0 {
+		return fmt.Errorf("%w: replica count must be positive, got %d", ErrInvalidConfig, config.ReplicaCount)
+	}
+	if memberCount < 0 {
+		return fmt.Errorf("%w: member count must not be negative, got %d", ErrInvalidConfig, memberCount)
+	}
+	if memberCount == 0 {
+		return nil
+	}
+	avgLoad := averageLoad(uint64(config.PartitionCount), memberCount, config.Load)
+	if capacity := avgLoad * float64(memberCount); capacity < float64(config.PartitionCount) {
+		return fmt.Errorf("%w: %d members can hold %.0f of %d partitions with load %v",
+			ErrNotEnoughRoom, memberCount, capacity, config.PartitionCount, config.Load)
+	}
+	return nil
+}
+
+// snapshot is an immutable view of the ring published after every membership change.
+// It must not be modified once it is stored.
+type snapshot struct {
+	// partitions holds the owner of each partition, indexed by partition ID.
+	partitions []Member
+	// replicas holds the owner and the closest distinct members of each partition,
+	// indexed by partition ID, up to Config.ReplicaCount members.
+	replicas [][]Member
+}
+
+// Consistent holds the information about the members of the consistent hash circle.
+type Consistent struct {
+	// mu serializes the writers and guards everything but snapshot.
+	mu       sync.RWMutex
+	snapshot atomic.Pointer[snapshot]
+
+	config         Config
+	hasher         Hasher
+	sortedSet      []uint64
+	partitionCount uint64
+	loads          map[string]float64
+	members        map[string]*Member
+	ring           map[uint64]*Member
+	// collisions holds the names of all the members that hashed a virtual node
+	// to the same point of the ring. The smallest name owns the point.
+	collisions map[uint64][]string
+}
+
+// New creates and returns a new Consistent object. It returns an error if the config
+// is not able to distribute the partitions among the given members.
+func New(members []Member, config Config) (*Consistent, error) {
+	config = config.withDefaults()
+	if err := config.Validate(len(members)); err != nil {
+		return nil, err
+	}
 
 	c := &Consistent{
 		config:         config,
 		members:        make(map[string]*Member),
 		partitionCount: uint64(config.PartitionCount),
 		ring:           make(map[uint64]*Member),
+		collisions:     make(map[uint64][]string),
+		loads:          make(map[string]float64),
 	}
 
 	c.hasher = config.Hasher
 	for _, member := range members {
+		if _, ok := c.members[member.String()]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrMemberExists, member.String())
+		}
 		c.add(member)
 	}
-	if members != nil {
-		c.distributePartitions()
+	if len(members) > 0 {
+		if err := c.distributePartitions(); err != nil {
+			return nil, err
+		}
+	} else {
+		c.snapshot.Store(&snapshot{})
 	}
-	return c
+	return c, nil
 }
 
 // GetMembers returns a thread-safe copy of members. If there are no members, it returns an empty slice of Member.
@@ -188,40 +333,40 @@ func (c *Consistent) averageLoad() float64 {
 		return 0
 	}
 
-	avgLoad := float64(c.partitionCount/uint64(len(c.members))) * c.config.Load
+	return averageLoad(c.partitionCount, len(c.members), c.config.Load)
+}
+
+func averageLoad(partitionCount uint64, memberCount int, load float64) float64 {
+	avgLoad := float64(partitionCount/uint64(memberCount)) * load
 	return math.Ceil(avgLoad)
 }
 
-func (c *Consistent) distributeWithLoad(partID, idx int, partitions map[int]*Member, loads map[string]float64) {
+func (c *Consistent) distributeWithLoad(partID, idx int, partitions []Member, loads map[string]float64) error {
 	avgLoad := c.averageLoad()
-	var count int
-	for {
-		count++
-		if count >= len(c.sortedSet) {
-			// User needs to decrease partition count, increase member count or increase load factor.
-			panic("not enough room to distribute partitions")
-		}
+	for count := 0; count < len(c.sortedSet); count++ {
 		i := c.sortedSet[idx]
-		// if _,ok  := c.ring[i]; !ok {
-		// 	return
-		// }
-		member := *c.ring[i]
+		member := c.ring[i]
 		load := loads[member.String()]
 		if load+1 <= avgLoad {
-			partitions[partID] = &member
+			partitions[partID] = *member
 			loads[member.String()]++
-			return
+			return nil
 		}
 		idx++
 		if idx >= len(c.sortedSet) {
 			idx = 0
 		}
 	}
+	// User needs to decrease partition count, increase member count or increase load factor.
+	return fmt.Errorf("%w: partition %d", ErrNotEnoughRoom, partID)
 }
 
-func (c *Consistent) distributePartitions() {
+// distributePartitions recalculates the partition table and publishes a new snapshot.
+// Nothing is published unless every partition found an owner.
+func (c *Consistent) distributePartitions() error {
 	loads := make(map[string]float64)
-	partitions := make(map[int]*Member)
+	partitions := make([]Member, c.partitionCount)
+	replicas := make([][]Member, c.partitionCount)
 
 	bs := make([]byte, 8)
 	for partID := uint64(0); partID < c.partitionCount; partID++ {
@@ -233,78 +378,221 @@ func (c *Consistent) distributePartitions() {
 		if idx >= len(c.sortedSet) {
 			idx = 0
 		}
-		c.distributeWithLoad(int(partID), idx, partitions, loads)
+		if err := c.distributeWithLoad(int(partID), idx, partitions, loads); err != nil {
+			return err
+		}
+		replicas[partID] = c.walkReplicas(partitions[partID], idx)
 	}
-	c.partitions = partitions
+	c.snapshot.Store(&snapshot{partitions: partitions, replicas: replicas})
 	c.loads = loads
+	return nil
+}
+
+// walkReplicas returns the owner followed by the distinct members met by walking the ring
+// clockwise from idx, the position the partition was placed at.
+func (c *Consistent) walkReplicas(owner Member, idx int) []Member {
+	count := c.config.ReplicaCount
+	if count > len(c.members) {
+		count = len(c.members)
+	}
+	res := make([]Member, 1, count)
+	res[0] = owner
+	for i := 0; i < len(c.sortedSet) && len(res) < count; i++ {
+		member := c.ring[c.sortedSet[idx]]
+		if !containsMember(res, member.Name) {
+			res = append(res, *member)
+		}
+		idx++
+		if idx >= len(c.sortedSet) {
+			idx = 0
+		}
+	}
+	return res
+}
+
+func containsMember(members []Member, name string) bool {
+	for _, m := range members {
+		if m.Name == name {
+			return true
+		}
+	}
+	return false
 }
 
+// add places the virtual nodes of member on the ring. When a virtual node collides with
+// one of another member, the member with the smallest name owns the point so the ring
+// doesn't depend on the order in which members were added.
 func (c *Consistent) add(member Member) {
-	dup := 0
+	member.Labels = cloneLabels(member.Labels)
+	m := &member
 	for i := 0; i < c.config.ReplicationFactor; i++ {
 		h := c.getMemberHash(member.Name, i)
-		if _, ok := c.ring[h]; ok {
-			dup++
+		owner, ok := c.ring[h]
+		if !ok {
+			c.ring[h] = m
+			c.sortedSet = append(c.sortedSet, h)
+			continue
+		}
+		if owner.Name == member.Name {
+			// Two virtual nodes of the same member, nothing to arbitrate.
+			continue
+		}
+		if len(c.collisions[h]) == 0 {
+			c.collisions[h] = []string{owner.Name}
+		}
+		c.collisions[h] = append(c.collisions[h], member.Name)
+		if member.Name < owner.Name {
+			c.ring[h] = m
 		}
-		c.ring[h] = &member
-		c.sortedSet = append(c.sortedSet, h)
-	}
-	if dup > 0 {
-		fmt.Println("DUPLICATE HASH FOUND: ", dup)
-		fmt.Printf("Sorted:%d, Ring: %d\n", len(c.sortedSet), len(c.ring))
 	}
 	// sort hashes ascendingly
 	sort.Slice(c.sortedSet, func(i int, j int) bool {
 		return c.sortedSet[i] < c.sortedSet[j]
 	})
 	// Storing member at this map is useful to find backup members of a partition.
-	c.members[member.String()] = &member
+	c.members[member.String()] = m
+}
+
+// remove takes the virtual nodes of the named member off the ring. Points shared
+// with other members are handed over to the smallest remaining name.
+func (c *Consistent) remove(name string) {
+	for i := 0; i < c.config.ReplicationFactor; i++ {
+		h := c.getMemberHash(name, i)
+		names, collided := c.collisions[h]
+		if !collided {
+			if owner, ok := c.ring[h]; ok && owner.Name == name {
+				delete(c.ring, h)
+				c.delSlice(h)
+			}
+			continue
+		}
+		rest := make([]string, 0, len(names))
+		for _, n := range names {
+			if n != name {
+				rest = append(rest, n)
+			}
+		}
+		if len(rest) == len(names) {
+			// Already handed over by an earlier virtual node of the same member.
+			continue
+		}
+		if len(rest) > 1 {
+			c.collisions[h] = rest
+		} else {
+			delete(c.collisions, h)
+		}
+		if c.ring[h].Name == name {
+			next := rest[0]
+			for _, n := range rest[1:] {
+				if n < next {
+					next = n
+				}
+			}
+			c.ring[h] = c.members[next]
+		}
+	}
+	delete(c.members, name)
 }
 
-// Add adds a new member to the consistent hash circle.
-func (c *Consistent) Add(member Member) {
+// Add adds a new member to the consistent hash circle. The ring is left untouched
+// if the partitions can't be distributed with the new member.
+func (c *Consistent) Add(member Member) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	if _, ok := c.members[member.String()]; ok {
-		// We already have this member. Quit immediately.
-		return
+		return fmt.Errorf("%w: %s", ErrMemberExists, member.String())
+	}
+	if err := c.config.Validate(len(c.members) + 1); err != nil {
+		return err
 	}
 	c.add(member)
-	c.distributePartitions()
+	if err := c.distributePartitions(); err != nil {
+		c.remove(member.String())
+		return err
+	}
+	return nil
+}
+
+// Update replaces the metadata of a member already in the ring. The placement only
+// depends on the name, so no partition moves.
+func (c *Consistent) Update(member Member) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, ok := c.members[member.String()]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, member.String())
+	}
+	if current.Equal(member) {
+		return nil
+	}
+	member.Labels = cloneLabels(member.Labels)
+	// The ring shares this pointer with the members map.
+	*current = member
+
+	old := c.snapshot.Load()
+	next := &snapshot{
+		partitions: make([]Member, len(old.partitions)),
+		replicas:   make([][]Member, len(old.replicas)),
+	}
+	for partID, m := range old.partitions {
+		if m.Name == member.Name {
+			m = member
+		}
+		next.partitions[partID] = m
+	}
+	for partID, replicas := range old.replicas {
+		next.replicas[partID] = replicas
+		for i, m := range replicas {
+			if m.Name != member.Name {
+				continue
+			}
+			updated := make([]Member, len(replicas))
+			copy(updated, replicas)
+			updated[i] = member
+			next.replicas[partID] = updated
+			break
+		}
+	}
+	c.snapshot.Store(next)
+	return nil
 }
 
 func (c *Consistent) delSlice(val uint64) {
 	for i := 0; i < len(c.sortedSet); i++ {
 		if c.sortedSet[i] == val {
 			c.sortedSet = append(c.sortedSet[:i], c.sortedSet[i+1:]...)
-			//break - potentially there could be duplicate hash b'cos of collision
+			break
 		}
 	}
 }
 
-// Remove removes a member from the consistent hash circle.
-func (c *Consistent) Remove(name string) {
+// Remove removes a member from the consistent hash circle. The ring is left untouched
+// if the partitions can't be distributed among the remaining members.
+func (c *Consistent) Remove(name string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	if _, ok := c.members[name]; !ok {
-		// There is no member with that name. Quit immediately.
-		return
+	member, ok := c.members[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrMemberNotFound, name)
 	}
-
-	for i := 0; i < c.config.ReplicationFactor; i++ {
-		h := c.getMemberHash(name, i)
-		delete(c.ring, h)
-		c.delSlice(h)
+	if err := c.config.Validate(len(c.members) - 1); err != nil {
+		return err
 	}
-	delete(c.members, name)
+	c.remove(name)
 	if len(c.members) == 0 {
 		// consistent hash ring is empty now. Reset the partition table.
-		c.partitions = make(map[int]*Member)
-		return
+		c.snapshot.Store(&snapshot{})
+		c.loads = make(map[string]float64)
+		return nil
 	}
-	c.distributePartitions()
+	if err := c.distributePartitions(); err != nil {
+		c.add(*member)
+		return err
+	}
+	return nil
 }
 
 // LoadDistribution exposes load distribution of members.
@@ -328,20 +616,14 @@ func (c *Consistent) FindPartitionID(key []byte) int {
 
 // GetPartitionOwner returns the owner of the given partition.
 func (c *Consistent) GetPartitionOwner(partID int) Member {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	return c.getPartitionOwner(partID)
+	return c.snapshot.Load().owner(partID)
 }
 
-// getPartitionOwner returns the owner of the given partition. It's not thread-safe.
-func (c *Consistent) getPartitionOwner(partID int) Member {
-	member, ok := c.partitions[partID]
-	if !ok {
+func (s *snapshot) owner(partID int) Member {
+	if partID < 0 || partID >= len(s.partitions) {
 		return Member{}
 	}
-	// Create a thread-safe copy of member and return it.
-	return *member
+	return s.partitions[partID]
 }
 
 // LocateKey finds a home for given key
@@ -351,63 +633,34 @@ func (c *Consistent) LocateKey(key []byte) Member {
 }
 
 func (c *Consistent) getClosestN(partID, count int) ([]Member, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	var res []Member
-	if count > len(c.members) {
-		return res, ErrInsufficientMemberCount
-	}
-
-	var ownerKey uint64
-	owner := c.getPartitionOwner(partID)
-	// Hash and sort all the names.
-	var keys []uint64
-	kmems := make(map[uint64]*Member)
-	for name, member := range c.members {
-		key := c.hasher([]byte(name))
-		if name == owner.String() {
-			ownerKey = key
-		}
-		keys = append(keys, key)
-		kmems[key] = member
+	if count < 1 {
+		return nil, fmt.Errorf("%w: %d", ErrInvalidReplicaCount, count)
 	}
-	sort.Slice(keys, func(i, j int) bool {
-		return keys[i] < keys[j]
-	})
-
-	// Find the key owner
-	idx := 0
-	for idx < len(keys) {
-		if keys[idx] == ownerKey {
-			key := keys[idx]
-			res = append(res, *kmems[key])
-			break
-		}
-		idx++
+	if count > c.config.ReplicaCount {
+		return nil, fmt.Errorf("%w: %d > %d", ErrTooManyReplicas, count, c.config.ReplicaCount)
 	}
-
-	// Find the closest(replica owners) members.
-	for len(res) < count {
-		idx++
-		if idx >= len(keys) {
-			idx = 0
-		}
-		key := keys[idx]
-		res = append(res, *kmems[key])
+	s := c.snapshot.Load()
+	if partID < 0 || partID >= len(s.replicas) {
+		return nil, ErrInsufficientMemberCount
+	}
+	replicas := s.replicas[partID]
+	if count > len(replicas) {
+		return nil, ErrInsufficientMemberCount
 	}
+	res := make([]Member, count)
+	copy(res, replicas)
 	return res, nil
 }
 
-// GetClosestN returns the closest N member to a key in the hash ring.
-// This may be useful to find members for replication.
+// GetClosestN returns the closest N member to a key in the hash ring, starting with the owner.
+// This may be useful to find members for replication. N can't exceed Config.ReplicaCount.
 func (c *Consistent) GetClosestN(key []byte, count int) ([]Member, error) {
 	partID := c.FindPartitionID(key)
 	return c.getClosestN(partID, count)
 }
 
-// GetClosestNForPartition returns the closest N member for given partition.
-// This may be useful to find members for replication.
+// GetClosestNForPartition returns the closest N member for given partition, starting with the owner.
+// This may be useful to find members for replication. N can't exceed Config.ReplicaCount.
 func (c *Consistent) GetClosestNForPartition(partID, count int) ([]Member, error) {
 	return c.getClosestN(partID, count)
 }
@@ -418,10 +671,16 @@ func (c *Consistent) getMemberHash(name string, i int) uint64 {
 	return c.hasher([]byte(key))
 }
 
+// GetPartitionList returns a copy of the partition table. Later membership changes
+// don't affect the returned map.
 func (c *Consistent) GetPartitionList() map[int]*Member {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return c.partitions
+	s := c.snapshot.Load()
+	res := make(map[int]*Member, len(s.partitions))
+	for partID := range s.partitions {
+		member := s.partitions[partID]
+		res[partID] = &member
+	}
+	return res
 }
 
 func (c *Consistent) MemberExists(name string) bool {
@@ -2,11 +2,14 @@ package hash
 
 import (
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"hash/fnv"
 	"math"
 	"sort"
+	"sync"
 	"testing"
 
 	"github.com/cespare/xxhash"
@@ -23,6 +26,15 @@ func init() {
 	}
 }
 
+func newConsistent(t *testing.T, members []Member, cfg Config) *Consistent {
+	t.Helper()
+	c, err := New(members, cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	return c
+}
+
 func TestConsistentXXhash(t *testing.T) {
 
 	cfg := Config{
@@ -31,7 +43,7 @@ func TestConsistentXXhash(t *testing.T) {
 		Load:              1.2,
 		Hasher:            xxhash.Sum64,
 	}
-	c := New(members, cfg)
+	c := newConsistent(t, members, cfg)
 	loadKeys(c)
 }
 
@@ -47,7 +59,7 @@ func TestConsistentCrc(t *testing.T) {
 		Load:              1.2,
 		Hasher:            checksum,
 	}
-	c := New(members, cfg)
+	c := newConsistent(t, members, cfg)
 	loadKeys(c)
 }
 
@@ -64,7 +76,7 @@ func TestConsistentfnv(t *testing.T) {
 		Load:              1.2,
 		Hasher:            fnvHash,
 	}
-	c := New(members, cfg)
+	c := newConsistent(t, members, cfg)
 	loadKeys(c)
 }
 
@@ -76,7 +88,7 @@ func TestConsistentfnv1(t *testing.T) {
 		Load:              1.2,
 		Hasher:            fnv1.HashBytes64,
 	}
-	c := New(members, cfg)
+	c := newConsistent(t, members, cfg)
 	loadKeys(c)
 }
 
@@ -132,11 +144,11 @@ func TestSort(t *testing.T) {
 		Load:              1.2,
 		Hasher:          fnvHash,
 	}
-	c := New(members, cfg)
+	c := newConsistent(t, members, cfg)
 	p := c.GetPartitionList()
 	
 	sort.Sort(sort.Reverse(members))
-	c = New(members, cfg)
+	c = newConsistent(t, members, cfg)
 	reversep := c.GetPartitionList()
 	fmt.Println("Reverse List: ", members)
 
@@ -147,3 +159,337 @@ func TestSort(t *testing.T) {
 	}
 
 }
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		members int
+		err     error
+	}{
+		{"defaults", Config{}, 8, nil},
+		{"empty ring", Config{PartitionCount: 7}, 0, nil},
+		{"negative partition count", Config{PartitionCount: -1}, 8, ErrInvalidConfig},
+		{"negative replication factor", Config{ReplicationFactor: -1}, 8, ErrInvalidConfig},
+		{"negative load", Config{Load: -1}, 8, ErrInvalidConfig},
+		{"more members than partitions", Config{PartitionCount: 7}, 8, ErrNotEnoughRoom},
+		{"load too small", Config{PartitionCount: 10, Load: 0.5}, 3, ErrNotEnoughRoom},
+		{"exact fit", Config{PartitionCount: 8, Load: 1}, 8, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate(tt.members)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("Validate(%d) = %v, want %v", tt.members, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestNewNotEnoughRoom(t *testing.T) {
+	_, err := New(members, Config{PartitionCount: 7})
+	if !errors.Is(err, ErrNotEnoughRoom) {
+		t.Fatalf("New = %v, want %v", err, ErrNotEnoughRoom)
+	}
+}
+
+func TestAddRemoveErrors(t *testing.T) {
+	list := append(MemberList{}, members...)
+	sort.Sort(list)
+	cfg := Config{PartitionCount: 8, ReplicationFactor: 10, Load: 2}
+	c := newConsistent(t, list[:4], cfg)
+	before := c.GetPartitionList()
+
+	if err := c.Add(list[0]); !errors.Is(err, ErrMemberExists) {
+		t.Fatalf("Add existing = %v, want %v", err, ErrMemberExists)
+	}
+	if err := c.Remove("unknown"); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("Remove unknown = %v, want %v", err, ErrMemberNotFound)
+	}
+	// 9 members can't share 8 partitions.
+	for _, m := range list[4:] {
+		if err := c.Add(m); err != nil {
+			t.Fatalf("Add %s: %v", m, err)
+		}
+	}
+	if err := c.Add(Member{Name: "node8"}); !errors.Is(err, ErrNotEnoughRoom) {
+		t.Fatalf("Add = %v, want %v", err, ErrNotEnoughRoom)
+	}
+	if c.MemberExists("node8") {
+		t.Fatal("member is in the ring after a failed Add")
+	}
+	for _, m := range list[4:] {
+		if err := c.Remove(m.Name); err != nil {
+			t.Fatalf("Remove %s: %v", m, err)
+		}
+	}
+	after := c.GetPartitionList()
+	for partID, m := range before {
+		if after[partID].Name != m.Name {
+			t.Fatalf("partition %d moved from %s to %s", partID, m, after[partID])
+		}
+	}
+}
+
+func TestCollisionDeterministic(t *testing.T) {
+	// Squeeze 8*20 virtual nodes into 97 points so that members collide.
+	cfg := Config{
+		PartitionCount:    64,
+		ReplicationFactor: 20,
+		Load:              1.25,
+		Hasher:            func(data []byte) uint64 { return xxhash.Sum64(data) % 97 },
+	}
+	list := append(MemberList{}, members...)
+	sort.Sort(list)
+	want := newConsistent(t, list, cfg)
+	if len(want.collisions) == 0 {
+		t.Fatal("expected colliding virtual nodes")
+	}
+
+	reversed := append(MemberList{}, list...)
+	sort.Sort(sort.Reverse(reversed))
+	assertSamePartitions(t, "reversed", want, newConsistent(t, reversed, cfg))
+
+	added := newConsistent(t, reversed[1:], cfg)
+	if err := added.Add(reversed[0]); err != nil {
+		t.Fatal(err)
+	}
+	assertSamePartitions(t, "added last", want, added)
+
+	removed := newConsistent(t, list, cfg)
+	if err := removed.Remove("node3"); err != nil {
+		t.Fatal(err)
+	}
+	withoutNode3 := append(MemberList{}, list[:3]...)
+	withoutNode3 = append(withoutNode3, list[4:]...)
+	assertSamePartitions(t, "removed", newConsistent(t, withoutNode3, cfg), removed)
+}
+
+func assertSamePartitions(t *testing.T, name string, want, got *Consistent) {
+	t.Helper()
+	for partID := 0; partID < int(want.partitionCount); partID++ {
+		w, g := want.GetPartitionOwner(partID), got.GetPartitionOwner(partID)
+		if w.Name != g.Name {
+			t.Fatalf("%s: partition %d owned by %s, want %s", name, partID, g, w)
+		}
+	}
+}
+
+// TestLookupsDuringChurn is meant to be run with -race. Readers never see an
+// empty or half-built partition table while members come and go.
+func TestLookupsDuringChurn(t *testing.T) {
+	cfg := Config{PartitionCount: 271, ReplicationFactor: 20, Load: 1.25}
+	c := newConsistent(t, members, cfg)
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for r := 0; r < 4; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("reader-%d", r))
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				if m := c.LocateKey(key); m.Name == "" {
+					t.Error("LocateKey returned no member")
+					return
+				}
+				if m := c.GetPartitionOwner(r); m.Name == "" {
+					t.Error("GetPartitionOwner returned no member")
+					return
+				}
+				if l := c.GetPartitionList(); len(l) != cfg.PartitionCount {
+					t.Errorf("GetPartitionList returned %d partitions", len(l))
+					return
+				}
+			}
+		}(r)
+	}
+
+	extra := Member{Name: "node-extra"}
+	for i := 0; i < 50; i++ {
+		if err := c.Add(extra); err != nil {
+			t.Fatal(err)
+		}
+		// The copies must not follow the ring, nor the ring the copies.
+		membersBefore, ownersBefore := ringState(c)
+		list, memberList := c.GetPartitionList(), c.GetMembers()
+		for _, m := range list {
+			m.Name = "mutated"
+		}
+		for i := range memberList {
+			memberList[i].Name = "mutated"
+		}
+		if membersAfter, ownersAfter := ringState(c); membersAfter != membersBefore || ownersAfter != ownersBefore {
+			t.Fatalf("changing the copies changed the ring: members %s, owners %s", membersAfter, ownersAfter)
+		}
+		if err := c.Remove(extra.Name); err != nil {
+			t.Fatal(err)
+		}
+		for _, m := range list {
+			if m.Name != "mutated" {
+				t.Fatalf("the partition list followed the ring: %s", m.Name)
+			}
+		}
+	}
+	close(done)
+	wg.Wait()
+}
+
+// ringState returns the sorted member names and the owners of the partitions.
+func ringState(c *Consistent) (string, string) {
+	var names []string
+	for _, m := range c.GetMembers() {
+		names = append(names, m.Name)
+	}
+	sort.Strings(names)
+	owners := make([]string, c.partitionCount)
+	for partID := range owners {
+		owners[partID] = c.GetPartitionOwner(partID).Name
+	}
+	return fmt.Sprint(names), fmt.Sprint(owners)
+}
+
+func BenchmarkLocateKeyParallel(b *testing.B) {
+	c, err := New(members, Config{PartitionCount: 271, ReplicationFactor: 20, Load: 1.25})
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkLocateKey(b, c)
+}
+
+func BenchmarkLocateKeyParallelChurn(b *testing.B) {
+	c, err := New(members, Config{PartitionCount: 271, ReplicationFactor: 20, Load: 1.25})
+	if err != nil {
+		b.Fatal(err)
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		extra := Member{Name: "node-extra"}
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			c.Add(extra)
+			c.Remove(extra.Name)
+		}
+	}()
+	benchmarkLocateKey(b, c)
+}
+
+func benchmarkLocateKey(b *testing.B, c *Consistent) {
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		key := make([]byte, 8)
+		var i uint64
+		for pb.Next() {
+			i++
+			binary.LittleEndian.PutUint64(key, i)
+			c.LocateKey(key)
+		}
+	})
+}
+
+func TestGetClosestN(t *testing.T) {
+	cfg := Config{PartitionCount: 271, ReplicationFactor: 20, Load: 1.25, ReplicaCount: 3}
+	c := newConsistent(t, members, cfg)
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		replicas, err := c.GetClosestN(key, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(replicas) != 3 {
+			t.Fatalf("got %d replicas, want 3", len(replicas))
+		}
+		if owner := c.LocateKey(key); replicas[0].Name != owner.Name {
+			t.Fatalf("first replica %s is not the owner %s", replicas[0], owner)
+		}
+		if replicas[0].Name == replicas[1].Name || replicas[1].Name == replicas[2].Name ||
+			replicas[0].Name == replicas[2].Name {
+			t.Fatalf("replicas are not distinct: %v", replicas)
+		}
+		// A smaller N is a prefix of the bigger one.
+		two, _ := c.GetClosestN(key, 2)
+		if two[0].Name != replicas[0].Name || two[1].Name != replicas[1].Name {
+			t.Fatalf("GetClosestN(2) = %v is not a prefix of %v", two, replicas)
+		}
+	}
+
+	if _, err := c.GetClosestN([]byte("key"), 4); !errors.Is(err, ErrTooManyReplicas) {
+		t.Fatalf("GetClosestN(4) = %v, want %v", err, ErrTooManyReplicas)
+	}
+	for _, count := range []int{0, -1} {
+		if _, err := c.GetClosestN([]byte("key"), count); !errors.Is(err, ErrInvalidReplicaCount) {
+			t.Fatalf("GetClosestN(%d) = %v, want %v", count, err, ErrInvalidReplicaCount)
+		}
+	}
+	small := newConsistent(t, members[:2], cfg)
+	if _, err := small.GetClosestN([]byte("key"), 3); !errors.Is(err, ErrInsufficientMemberCount) {
+		t.Fatalf("GetClosestN(3) with 2 members = %v, want %v", err, ErrInsufficientMemberCount)
+	}
+
+	// The returned slice is a copy.
+	replicas, _ := c.GetClosestNForPartition(0, 3)
+	replicas[0].Name = "mutated"
+	if again, _ := c.GetClosestNForPartition(0, 3); again[0].Name == "mutated" {
+		t.Fatal("GetClosestNForPartition returned the internal table")
+	}
+}
+
+func BenchmarkGetClosestN(b *testing.B) {
+	c, err := New(members, Config{PartitionCount: 271, ReplicationFactor: 20, Load: 1.25, ReplicaCount: 3})
+	if err != nil {
+		b.Fatal(err)
+	}
+	key := []byte("my-key")
+	for i := 0; i < b.N; i++ {
+		c.GetClosestN(key, 3)
+	}
+}
+
+func TestUpdateMember(t *testing.T) {
+	cfg := Config{PartitionCount: 271, ReplicationFactor: 20, Load: 1.25}
+	plain := newConsistent(t, members, cfg)
+
+	withAddr := make([]Member, len(members))
+	for i, m := range members {
+		withAddr[i] = Member{Name: m.Name, Host: fmt.Sprintf("10.0.0.%d", i), Port: 8080, Protocol: "TCP"}
+	}
+	c := newConsistent(t, withAddr, cfg)
+	// Metadata doesn't take part in the placement.
+	assertSamePartitions(t, "metadata", plain, c)
+
+	target := withAddr[0]
+	target.Host = "10.0.1.1"
+	target.Labels = map[string]string{"zone": "b"}
+	if err := c.Update(target); err != nil {
+		t.Fatal(err)
+	}
+	assertSamePartitions(t, "updated", plain, c)
+
+	for partID := 0; partID < cfg.PartitionCount; partID++ {
+		owner := c.GetPartitionOwner(partID)
+		if owner.Name == target.Name && !owner.Equal(target) {
+			t.Fatalf("partition %d owner = %+v, want %+v", partID, owner, target)
+		}
+		replicas, _ := c.GetClosestNForPartition(partID, 3)
+		for _, r := range replicas {
+			if r.Name == target.Name && r.Address() != "10.0.1.1:8080" {
+				t.Fatalf("partition %d replica address = %s", partID, r.Address())
+			}
+		}
+	}
+
+	if err := c.Update(Member{Name: "unknown"}); !errors.Is(err, ErrMemberNotFound) {
+		t.Fatalf("Update unknown = %v, want %v", err, ErrMemberNotFound)
+	}
+}
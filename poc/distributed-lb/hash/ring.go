@@ -0,0 +1,14 @@
+package hash
+
+// Ring is the placement the storage, lock and rate limit members follow.
+// *Consistent and *client.Client implement it.
+type Ring interface {
+	FindPartitionID(key []byte) int
+	GetClosestN(key []byte, count int) ([]Member, error)
+	GetClosestNForPartition(partID, count int) ([]Member, error)
+}
+
+// Locator finds the member serving a key. *client.Client implements it.
+type Locator interface {
+	LocateKey(key []byte) (Member, error)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/crc64"
+	"hash/fnv"
+	"math/bits"
+
+	"github.com/cespare/xxhash"
+)
+
+var crcTable = crc64.MakeTable(crc64.ECMA)
+
+// hashers are the 64-bit hash functions the sweep can use, by flag name.
+var hashers = map[string]func([]byte) uint64{
+	"fnv": func(data []byte) uint64 {
+		h := fnv.New64()
+		h.Write(data)
+		return h.Sum64()
+	},
+	"xxhash": xxhash.Sum64,
+	"crc64": func(data []byte) uint64 {
+		return crc64.Checksum(data, crcTable)
+	},
+	"murmur": murmur3,
+}
+
+// hasherFunc adapts a hash function to the Hasher interface of buraksezer/consistent.
+type hasherFunc func([]byte) uint64
+
+func (f hasherFunc) Sum64(data []byte) uint64 {
+	return f(data)
+}
+
+// murmur3 returns the first half of the 128-bit MurmurHash3 (x64) of data with a zero seed.
+func murmur3(data []byte) uint64 {
+	const (
+		c1 = 0x87c37b91114253d5
+		c2 = 0x4cf5ad432745937f
+	)
+	var h1, h2 uint64
+	n := len(data)
+	for len(data) >= 16 {
+		k1 := binary.LittleEndian.Uint64(data)
+		k2 := binary.LittleEndian.Uint64(data[8:])
+		data = data[16:]
+
+		h1 ^= bits.RotateLeft64(k1*c1, 31) * c2
+		h1 = bits.RotateLeft64(h1, 27) + h2
+		h1 = h1*5 + 0x52dce729
+
+		h2 ^= bits.RotateLeft64(k2*c2, 33) * c1
+		h2 = bits.RotateLeft64(h2, 31) + h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	var k1, k2 uint64
+	for i := len(data) - 1; i >= 8; i-- {
+		k2 ^= uint64(data[i]) << (8 * (i - 8))
+	}
+	if len(data) > 8 {
+		h2 ^= bits.RotateLeft64(k2*c2, 33) * c1
+	}
+	for i := min(len(data), 8) - 1; i >= 0; i-- {
+		k1 ^= uint64(data[i]) << (8 * i)
+	}
+	if len(data) > 0 {
+		h1 ^= bits.RotateLeft64(k1*c1, 31) * c2
+	}
+
+	h1 ^= uint64(n)
+	h2 ^= uint64(n)
+	h1 += h2
+	h2 += h1
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+	h1 += h2
+	return h1
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestHashersKnownAnswers(t *testing.T) {
+	tests := []struct {
+		hasher string
+		input  string
+		want   uint64
+	}{
+		// FNV-1, 64 bits.
+		{"fnv", "", 0xcbf29ce484222325},
+		{"fnv", "a", 0xaf63bd4c8601b7be},
+		{"xxhash", "", 0xef46db3751d8e999},
+		{"xxhash", "a", 0xd24ec4f1a98c6e5b},
+		// CRC-64/XZ, the check value is the one of "123456789".
+		{"crc64", "", 0},
+		{"crc64", "123456789", 0x995dc9bbdf1939fa},
+		// The first half of MurmurHash3_x64_128, seed 0, as Guava's murmur3_128 prints
+		// it little-endian: "hello" is 029bbd41b3a7d8cb..., the fox 6c1b07bc7bbc4be3...
+		{"murmur", "", 0},
+		{"murmur", "hello", 0xcbd8a7b341bd9b02},
+		{"murmur", "The quick brown fox jumps over the lazy dog", 0xe34bbc7bbc071b6c},
+		// No tail, tails of 1 and 15 bytes after a block, from an independent implementation.
+		{"murmur", "0123456789abcdef", 0x4be06d94cf4ad1a7},
+		{"murmur", "0123456789abcdefX", 0xcdebd2acb570d6f7},
+		{"murmur", "0123456789abcdefghijklmnopqrstu", 0xb828780c1a6e0542},
+	}
+	for _, tt := range tests {
+		if got := hashers[tt.hasher]([]byte(tt.input)); got != tt.want {
+			t.Errorf("%s(%q) = %#016x, want %#016x", tt.hasher, tt.input, got, tt.want)
+		}
+	}
+}
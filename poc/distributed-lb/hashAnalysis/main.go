@@ -0,0 +1,316 @@
+// hashAnalysis sweeps hashers, partition counts, replication factors and load factors
+// over distributed-lb/hash and buraksezer/consistent. For every combination it places
+// random keys on the members and reports how even the load is and how many keys move
+// when a member leaves or joins, as CSV or JSON.
+//
+//	go run ./hashAnalysis -hashers fnv,xxhash -partitions 271,16384 -format json
+package main
+
+import (
+	"distributed-lb/hash"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/buraksezer/consistent"
+)
+
+// params is one combination of the sweep.
+type params struct {
+	Impl        string  `json:"impl"`
+	Hasher      string  `json:"hasher"`
+	Partitions  int     `json:"partitions"`
+	Replication int     `json:"replication"`
+	Load        float64 `json:"load"`
+	Members     int     `json:"members"`
+	// Remove is the member that leaves, one of node0 to node<Members-1>.
+	Remove string `json:"remove"`
+	Keys   int    `json:"keys"`
+}
+
+// result is the report of one combination. The moved fractions are to be compared
+// with the ideal 1/members for a remove and 1/(members+1) for an add.
+type result struct {
+	params
+	// StdDev is the standard deviation of the key counts of the members.
+	StdDev float64 `json:"stdDev"`
+	// MaxAvg is the key count of the most loaded member over the average.
+	MaxAvg float64 `json:"maxAvg"`
+	// RemoveMoved and AddMoved are the fractions of the keys that changed member when
+	// one member left and when one joined.
+	RemoveMoved float64 `json:"removeMoved"`
+	AddMoved    float64 `json:"addMoved"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// ring is the part of a consistent hashing implementation the analysis needs.
+type ring interface {
+	locate(key []byte) string
+	add(name string) error
+	remove(name string) error
+}
+
+// newRingFunc builds a ring of the implementation over the members.
+type newRingFunc func(members []string, p params) (ring, error)
+
+var impls = map[string]newRingFunc{
+	"lb":         newLBRing,
+	"buraksezer": newBuraksezerRing,
+}
+
+type lbRing struct{ c *hash.Consistent }
+
+func newLBRing(members []string, p params) (ring, error) {
+	list := make([]hash.Member, len(members))
+	for i, name := range members {
+		list[i] = hash.Member{Name: name}
+	}
+	c, err := hash.New(list, hash.Config{
+		Hasher:            hashers[p.Hasher],
+		PartitionCount:    p.Partitions,
+		ReplicationFactor: p.Replication,
+		Load:              p.Load,
+	})
+	return &lbRing{c: c}, err
+}
+
+func (r *lbRing) locate(key []byte) string { return r.c.LocateKey(key).Name }
+func (r *lbRing) add(name string) error    { return r.c.Add(hash.Member{Name: name}) }
+func (r *lbRing) remove(name string) error { return r.c.Remove(name) }
+
+type member string
+
+func (m member) String() string { return string(m) }
+
+// buraksezerRing turns the panics of buraksezer/consistent into errors.
+type buraksezerRing struct{ c *consistent.Consistent }
+
+func newBuraksezerRing(members []string, p params) (r ring, err error) {
+	defer recoverError(&err)
+	list := make([]consistent.Member, len(members))
+	for i, name := range members {
+		list[i] = member(name)
+	}
+	c := consistent.New(list, consistent.Config{
+		Hasher:            hasherFunc(hashers[p.Hasher]),
+		PartitionCount:    p.Partitions,
+		ReplicationFactor: p.Replication,
+		Load:              p.Load,
+	})
+	return &buraksezerRing{c: c}, nil
+}
+
+func (r *buraksezerRing) locate(key []byte) string { return r.c.LocateKey(key).String() }
+
+func (r *buraksezerRing) add(name string) (err error) {
+	defer recoverError(&err)
+	r.c.Add(member(name))
+	return nil
+}
+
+func (r *buraksezerRing) remove(name string) (err error) {
+	defer recoverError(&err)
+	r.c.Remove(name)
+	return nil
+}
+
+func recoverError(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%v", r)
+	}
+}
+
+func memberNames(count int) []string {
+	names := make([]string, count)
+	for i := range names {
+		names[i] = fmt.Sprintf("node%d", i)
+	}
+	return names
+}
+
+// place returns the member of every key.
+func place(r ring, keys [][]byte) []string {
+	owners := make([]string, len(keys))
+	for i, key := range keys {
+		owners[i] = r.locate(key)
+	}
+	return owners
+}
+
+func movedFraction(before, after []string) float64 {
+	moved := 0
+	for i := range before {
+		if before[i] != after[i] {
+			moved++
+		}
+	}
+	return float64(moved) / float64(len(before))
+}
+
+func analyze(p params, newRing newRingFunc, keys [][]byte) result {
+	res := result{params: p}
+	members := memberNames(p.Members)
+	fail := func(err error) result {
+		res.Error = err.Error()
+		return res
+	}
+
+	r, err := newRing(members, p)
+	if err != nil {
+		return fail(err)
+	}
+	owners := place(r, keys)
+	counts := make(map[string]int, len(members))
+	for _, owner := range owners {
+		counts[owner]++
+	}
+	avg := float64(len(keys)) / float64(len(members))
+	var variance float64
+	highest := 0
+	for _, name := range members {
+		d := float64(counts[name]) - avg
+		variance += d * d
+		highest = max(highest, counts[name])
+	}
+	res.StdDev = math.Sqrt(variance / float64(len(members)))
+	res.MaxAvg = float64(highest) / avg
+
+	if !slices.Contains(members, p.Remove) {
+		return fail(fmt.Errorf("unknown member %q to remove", p.Remove))
+	}
+	if err := r.remove(p.Remove); err != nil {
+		return fail(err)
+	}
+	res.RemoveMoved = movedFraction(owners, place(r, keys))
+
+	if r, err = newRing(members, p); err != nil {
+		return fail(err)
+	}
+	if err := r.add(fmt.Sprintf("node%d", p.Members)); err != nil {
+		return fail(err)
+	}
+	res.AddMoved = movedFraction(owners, place(r, keys))
+	return res
+}
+
+func splitList[T any](flagName, list string, parse func(string) (T, error)) []T {
+	var res []T
+	for _, s := range strings.Split(list, ",") {
+		v, err := parse(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid -%s value %q: %v\n", flagName, s, err)
+			os.Exit(2)
+		}
+		res = append(res, v)
+	}
+	return res
+}
+
+func oneOf[V any](known map[string]V) func(string) (string, error) {
+	return func(s string) (string, error) {
+		if _, ok := known[s]; !ok {
+			names := make([]string, 0, len(known))
+			for name := range known {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return "", fmt.Errorf("expected one of %s", strings.Join(names, ", "))
+		}
+		return s, nil
+	}
+}
+
+func parseFloat(s string) (float64, error) { return strconv.ParseFloat(s, 64) }
+
+func main() {
+	implList := flag.String("impls", "lb,buraksezer", "implementations: lb (distributed-lb/hash) and buraksezer")
+	hasherList := flag.String("hashers", "fnv,xxhash,crc64,murmur", "hash functions")
+	partitionList := flag.String("partitions", "271,16384", "partition counts")
+	replicationList := flag.String("replication", "20,100", "replication factors")
+	loadList := flag.String("load", "1.25", "load factors")
+	members := flag.Int("members", 8, "number of members")
+	remove := flag.String("remove", "", "member that leaves, node<members/2> if empty")
+	keyCount := flag.Int("keys", 100000, "number of random keys")
+	seed := flag.Int64("seed", 1, "seed of the random keys")
+	format := flag.String("format", "csv", "output format, csv or json")
+	flag.Parse()
+
+	if *format != "csv" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "-format must be csv or json")
+		os.Exit(2)
+	}
+	if *members < 2 || *keyCount < 1 {
+		fmt.Fprintln(os.Stderr, "-members must be at least 2 and -keys positive")
+		os.Exit(2)
+	}
+	if *remove == "" {
+		*remove = fmt.Sprintf("node%d", *members/2)
+	}
+	if !slices.Contains(memberNames(*members), *remove) {
+		fmt.Fprintf(os.Stderr, "-remove must be one of node0 to node%d\n", *members-1)
+		os.Exit(2)
+	}
+	implNames := splitList("impls", *implList, oneOf(impls))
+	hasherNames := splitList("hashers", *hasherList, oneOf(hashers))
+	partitions := splitList("partitions", *partitionList, strconv.Atoi)
+	replications := splitList("replication", *replicationList, strconv.Atoi)
+	loads := splitList("load", *loadList, parseFloat)
+
+	rnd := rand.New(rand.NewSource(*seed))
+	keys := make([][]byte, *keyCount)
+	for i := range keys {
+		keys[i] = make([]byte, 8)
+		rnd.Read(keys[i])
+	}
+
+	var results []result
+	for _, impl := range implNames {
+		for _, hasher := range hasherNames {
+			for _, partitionCount := range partitions {
+				for _, replication := range replications {
+					for _, load := range loads {
+						p := params{
+							Impl:        impl,
+							Hasher:      hasher,
+							Partitions:  partitionCount,
+							Replication: replication,
+							Load:        load,
+							Members:     *members,
+							Remove:      *remove,
+							Keys:        *keyCount,
+						}
+						results = append(results, analyze(p, impls[impl], keys))
+					}
+				}
+			}
+		}
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(results)
+		return
+	}
+	w := csv.NewWriter(os.Stdout)
+	w.Write([]string{"impl", "hasher", "partitions", "replication", "load", "members", "remove", "keys",
+		"stddev", "max_avg", "remove_moved", "add_moved", "error"})
+	for _, r := range results {
+		w.Write([]string{
+			r.Impl, r.Hasher, strconv.Itoa(r.Partitions), strconv.Itoa(r.Replication),
+			strconv.FormatFloat(r.Load, 'f', -1, 64), strconv.Itoa(r.Members), r.Remove, strconv.Itoa(r.Keys),
+			strconv.FormatFloat(r.StdDev, 'f', 2, 64), strconv.FormatFloat(r.MaxAvg, 'f', 4, 64),
+			strconv.FormatFloat(r.RemoveMoved, 'f', 4, 64), strconv.FormatFloat(r.AddMoved, 'f', 4, 64),
+			r.Error,
+		})
+	}
+	w.Flush()
+}
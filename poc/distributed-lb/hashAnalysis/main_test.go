@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// modRing places key k on member k mod the member count, so every figure of the
+// analysis can be worked out by hand.
+type modRing struct{ members []string }
+
+func (r *modRing) locate(key []byte) string { return r.members[int(key[0])%len(r.members)] }
+func (r *modRing) add(name string) error    { r.members = append(r.members, name); return nil }
+func (r *modRing) remove(name string) error {
+	for i, m := range r.members {
+		if m == name {
+			r.members = append(r.members[:i:i], r.members[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+func newModRing(members []string, p params) (ring, error) {
+	return &modRing{members: append([]string(nil), members...)}, nil
+}
+
+func TestAnalyze(t *testing.T) {
+	keys := make([][]byte, 13)
+	for i := range keys {
+		keys[i] = []byte{byte(i)}
+	}
+	res := analyze(params{Impl: "mod", Members: 4, Remove: "node2", Keys: len(keys)}, newModRing, keys)
+
+	// node0 gets 4 keys, the others 3: the average is 3.25.
+	near := func(got, want float64) bool { return math.Abs(got-want) < 1e-9 }
+	if !near(res.StdDev, math.Sqrt(0.1875)) || !near(res.MaxAvg, 4/3.25) {
+		t.Fatalf("stddev %v and max/avg %v, want %v and %v", res.StdDev, res.MaxAvg, math.Sqrt(0.1875), 4/3.25)
+	}
+	// Modulo placement moves most keys: 9 of 13 when node2 leaves and when node4 joins.
+	if !near(res.RemoveMoved, 9.0/13) || !near(res.AddMoved, 9.0/13) {
+		t.Fatalf("moved %v on remove and %v on add, want 9/13", res.RemoveMoved, res.AddMoved)
+	}
+	// Removing node0 shifts the others down, only keys 9 to 11 keep their member.
+	res = analyze(params{Impl: "mod", Members: 4, Remove: "node0", Keys: len(keys)}, newModRing, keys)
+	if !near(res.RemoveMoved, 10.0/13) {
+		t.Fatalf("moved %v removing node0, want 10/13", res.RemoveMoved)
+	}
+	if res = analyze(params{Impl: "mod", Members: 4, Remove: "node4", Keys: len(keys)}, newModRing, keys); res.Error == "" {
+		t.Fatal("removing an unknown member succeeded")
+	}
+}
+
+func TestAnalyzeConsistentRings(t *testing.T) {
+	keys := make([][]byte, 5000)
+	for i := range keys {
+		keys[i] = []byte{byte(i), byte(i >> 8), 'k'}
+	}
+	for impl, newRing := range impls {
+		p := params{Impl: impl, Hasher: "xxhash", Partitions: 271, Replication: 20, Load: 1.25, Members: 4, Remove: "node2", Keys: len(keys)}
+		res := analyze(p, newRing, keys)
+		if res.Error != "" {
+			t.Fatalf("%s: %s", impl, res.Error)
+		}
+		// Consistent hashing moves about the keys of one member: 1/4 and 1/5 ideally.
+		if res.RemoveMoved < 0.1 || res.RemoveMoved > 0.4 || res.AddMoved < 0.1 || res.AddMoved > 0.35 {
+			t.Fatalf("%s moved %v on remove and %v on add", impl, res.RemoveMoved, res.AddMoved)
+		}
+		if res.MaxAvg > 1.5 {
+			t.Fatalf("%s: max/avg %v well above the load factor", impl, res.MaxAvg)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Package testcluster runs ring members in-process for the tests of the storage,
+// lock and rate limit packages.
+package testcluster
+
+import (
+	"distributed-lb/hash"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// Cluster runs the members, each behind its own httptest server, on a ring shared
+// by all of them.
+type Cluster[T http.Handler] struct {
+	Ring *hash.Consistent
+	// Members, Servers and HTTP are keyed by the member names, node0, node1...
+	Members map[string]hash.Member
+	Servers map[string]T
+	HTTP    map[string]*httptest.Server
+}
+
+// New starts size members, the first active of them are on the ring. newMember
+// creates the member of a name, following the ring of the cluster.
+func New[T http.Handler](t *testing.T, size, active int, cfg hash.Config, newMember func(name string, ring *LazyRing) (T, error)) *Cluster[T] {
+	t.Helper()
+	c := &Cluster[T]{
+		Members: make(map[string]hash.Member),
+		Servers: make(map[string]T),
+		HTTP:    make(map[string]*httptest.Server),
+	}
+	var members []hash.Member
+	for i := 0; i < size; i++ {
+		name := fmt.Sprintf("node%d", i)
+		var server T
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			server.ServeHTTP(w, r)
+		}))
+		t.Cleanup(srv.Close)
+		host, port, _ := net.SplitHostPort(srv.Listener.Addr().String())
+		p, _ := strconv.Atoi(port)
+		m := hash.Member{Name: name, Host: host, Port: p}
+		c.Members[name] = m
+		if i < active {
+			members = append(members, m)
+		}
+		s, err := newMember(name, c.LazyRing())
+		if err != nil {
+			t.Fatal(err)
+		}
+		server = s
+		c.Servers[name] = s
+		c.HTTP[name] = srv
+	}
+	ring, err := hash.New(members, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Ring = ring
+	return c
+}
+
+// Kill stops the member and takes it off the ring.
+func (c *Cluster[T]) Kill(t *testing.T, name string) {
+	t.Helper()
+	c.HTTP[name].Close()
+	if err := c.Ring.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// LazyRing returns the ring of the cluster as a hash.Ring and a hash.Locator.
+func (c *Cluster[T]) LazyRing() *LazyRing {
+	return &LazyRing{ring: func() *hash.Consistent { return c.Ring }}
+}
+
+// LazyRing lets the members be created before the ring that needs their addresses.
+type LazyRing struct{ ring func() *hash.Consistent }
+
+func (r *LazyRing) FindPartitionID(key []byte) int { return r.ring().FindPartitionID(key) }
+func (r *LazyRing) GetClosestN(key []byte, count int) ([]hash.Member, error) {
+	return r.ring().GetClosestN(key, count)
+}
+func (r *LazyRing) GetClosestNForPartition(partID, count int) ([]hash.Member, error) {
+	return r.ring().GetClosestNForPartition(partID, count)
+}
+func (r *LazyRing) LocateKey(key []byte) (hash.Member, error) {
+	return r.ring().LocateKey(key), nil
+}
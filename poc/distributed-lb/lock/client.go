@@ -0,0 +1,154 @@
+package lock
+
+import (
+	"context"
+	"distributed-lb/hash"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Client acquires locks from the members that own them and keeps the leases alive.
+type Client struct {
+	locator    hash.Locator
+	httpClient *http.Client
+	id         string
+	seq        atomic.Uint64
+	// RetryInterval is the wait between two attempts of Lock.
+	RetryInterval time.Duration
+}
+
+// NewClient creates a lock client. id identifies the client in the holders of the
+// locks, it should be unique among the clients.
+func NewClient(locator hash.Locator, id string) *Client {
+	return &Client{
+		locator:       locator,
+		httpClient:    &http.Client{Timeout: 5 * time.Second},
+		id:            id,
+		RetryInterval: 100 * time.Millisecond,
+	}
+}
+
+// Lease is a held lock. Check Token on the protected resource and stop using the
+// resource once Lost is closed.
+type Lease struct {
+	Name  string
+	Token uint64
+
+	cancel context.CancelFunc
+	lost   chan struct{}
+	done   chan struct{}
+}
+
+// Lost is closed when the lease couldn't be renewed: it expired or the owner of the
+// lock revoked it.
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Lock blocks until the lock is acquired or ctx is done. The lease is renewed in the
+// background until Unlock.
+func (c *Client) Lock(ctx context.Context, name string) (*Lease, error) {
+	holder := fmt.Sprintf("%s-%d", c.id, c.seq.Add(1))
+	for {
+		start := time.Now()
+		g, err := c.call(ctx, http.MethodPost, name, url.Values{"holder": {holder}})
+		if err == nil {
+			renewCtx, cancel := context.WithCancel(context.Background())
+			l := &Lease{Name: name, Token: g.Token, cancel: cancel, lost: make(chan struct{}), done: make(chan struct{})}
+			go c.keepAlive(renewCtx, l, start.Add(g.TTL), g.TTL)
+			return l, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(c.RetryInterval):
+		}
+	}
+}
+
+// keepAlive renews the lease every third of the TTL. Failed renewals are retried
+// until the lease expires.
+func (c *Client) keepAlive(ctx context.Context, l *Lease, expires time.Time, ttl time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		start := time.Now()
+		g, err := c.call(ctx, http.MethodPut, l.Name, url.Values{"token": {strconv.FormatUint(l.Token, 10)}})
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			expires = start.Add(g.TTL)
+			continue
+		}
+		if errors.Is(err, ErrLeaseLost) || errors.Is(err, ErrNotOwner) || !time.Now().Before(expires) {
+			close(l.lost)
+			return
+		}
+	}
+}
+
+// Unlock stops the renewals and releases the lease. It returns ErrLeaseLost if the
+// lease was lost before, the lock may have been held by someone else meanwhile.
+func (c *Client) Unlock(ctx context.Context, l *Lease) error {
+	l.cancel()
+	<-l.done
+	select {
+	case <-l.lost:
+		return ErrLeaseLost
+	default:
+	}
+	_, err := c.call(ctx, http.MethodDelete, l.Name, url.Values{"token": {strconv.FormatUint(l.Token, 10)}})
+	if errors.Is(err, ErrNotOwner) {
+		// The owner changed and revoked the lease.
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// call sends the request to the member serving the lock.
+func (c *Client) call(ctx context.Context, method, name string, query url.Values) (grant, error) {
+	m, err := c.locator.LocateKey([]byte(name))
+	if err != nil {
+		return grant{}, err
+	}
+	u := "http://" + m.Address() + lockPath + url.PathEscape(name) + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, method, u, nil)
+	if err != nil {
+		return grant{}, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return grant{}, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var g grant
+		err := json.NewDecoder(resp.Body).Decode(&g)
+		return g, err
+	case http.StatusNoContent:
+		return grant{}, nil
+	}
+	for e, code := range statusCodes {
+		if resp.StatusCode == code {
+			return grant{}, e
+		}
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return grant{}, fmt.Errorf("%s %s: %s %s", method, m, resp.Status, strings.TrimSpace(string(body)))
+}
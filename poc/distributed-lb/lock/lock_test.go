@@ -0,0 +1,154 @@
+package lock
+
+import (
+	"context"
+	"distributed-lb/hash"
+	"distributed-lb/internal/testcluster"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+const testTTL = 150 * time.Millisecond
+
+type cluster = testcluster.Cluster[*Server]
+
+// newCluster starts size lock servers, the first `active` of them are on the ring.
+func newCluster(t *testing.T, size, active int) *cluster {
+	t.Helper()
+	ringConfig := hash.Config{PartitionCount: 71, ReplicationFactor: 20, Load: 1.25}
+	return testcluster.New(t, size, active, ringConfig, func(name string, ring *testcluster.LazyRing) (*Server, error) {
+		return NewServer(Config{Name: name, TTL: testTTL}, ring)
+	})
+}
+
+func newClient(c *cluster, id string) *Client {
+	cl := NewClient(c.LazyRing(), id)
+	cl.RetryInterval = 10 * time.Millisecond
+	return cl
+}
+
+func TestLockMutualExclusion(t *testing.T) {
+	c := newCluster(t, 2, 2)
+	a, b := newClient(c, "a"), newClient(c, "b")
+	ctx := context.Background()
+
+	first, err := a.Lock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lease is renewed well past its TTL while a holds it.
+	short, cancel := context.WithTimeout(ctx, 3*testTTL)
+	defer cancel()
+	if _, err := b.Lock(short, "leader"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Lock while held = %v", err)
+	}
+	owner := c.Servers[c.Ring.LocateKey([]byte("leader")).Name]
+	if _, err := owner.Acquire("leader", "b"); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Acquire while held = %v", err)
+	}
+	select {
+	case <-first.Lost():
+		t.Fatal("the renewed lease was lost")
+	default:
+	}
+
+	if err := a.Unlock(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	second, err := b.Lock(ctx, "leader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Token <= first.Token {
+		t.Fatalf("fencing token went from %d to %d", first.Token, second.Token)
+	}
+	if err := b.Unlock(ctx, second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLeaseExpires(t *testing.T) {
+	c := newCluster(t, 1, 1)
+	s := c.Servers["node0"]
+	// Skip the grace period of the first grant.
+	s.ownedSince[c.Ring.FindPartitionID([]byte("job"))] = time.Now().Add(-testTTL)
+
+	token, err := s.Acquire("job", "crashed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Acquire("job", "other"); !errors.Is(err, ErrHeld) {
+		t.Fatalf("Acquire while held = %v", err)
+	}
+	time.Sleep(testTTL)
+	if err := s.Renew("job", token); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("Renew after expiry = %v", err)
+	}
+	next, err := s.Acquire("job", "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next <= token {
+		t.Fatalf("fencing token went from %d to %d", token, next)
+	}
+}
+
+func TestOwnershipChangeRevokesLeases(t *testing.T) {
+	c := newCluster(t, 3, 2)
+	// Find a lock that moves to node2 when it joins.
+	var name, oldOwner string
+	after, err := hash.New([]hash.Member{c.Members["node0"], c.Members["node1"], c.Members["node2"]}, hash.Config{PartitionCount: 71, ReplicationFactor: 20, Load: 1.25})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; name == ""; i++ {
+		candidate := fmt.Sprintf("lock-%d", i)
+		if after.LocateKey([]byte(candidate)).Name == "node2" {
+			name, oldOwner = candidate, c.Ring.LocateKey([]byte(candidate)).Name
+		}
+	}
+
+	a, b := newClient(c, "a"), newClient(c, "b")
+	ctx := context.Background()
+	first, err := a.Lock(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Ring.Add(c.Members["node2"]); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range c.Servers {
+		s.Rebalance()
+	}
+	old := c.Servers[oldOwner]
+	old.mu.Lock()
+	_, kept := old.leases[name]
+	old.mu.Unlock()
+	if kept {
+		t.Fatalf("%s kept the lease of %s", oldOwner, name)
+	}
+	select {
+	case <-first.Lost():
+	case <-time.After(2 * testTTL):
+		t.Fatal("the holder wasn't told about the revoked lease")
+	}
+
+	// node2 waits for the leases of the old owner to expire before granting.
+	start := time.Now()
+	second, err := b.Lock(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if waited := time.Since(start); waited < testTTL/2 {
+		t.Fatalf("node2 granted the lock after %v, before its grace period", waited)
+	}
+	if second.Token <= first.Token {
+		t.Fatalf("fencing token went from %d to %d", first.Token, second.Token)
+	}
+	if err := a.Unlock(ctx, first); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("Unlock of a revoked lease = %v", err)
+	}
+}
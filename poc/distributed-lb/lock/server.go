@@ -0,0 +1,267 @@
+// Package lock is a lease based lock service on top of the ring. Each lock name is
+// served by the member LocateKey returns for it, i.e. the owner of its partition.
+//
+// Leases expire unless they are renewed within the TTL and come with a fencing token
+// that grows with every grant, so a resource can reject the writes of a holder that
+// lost its lease without noticing. When a partition moves, the old owner revokes its
+// leases and the new owner waits one TTL before granting any, so the leases granted
+// by the old owner have expired by then.
+package lock
+
+import (
+	"distributed-lb/hash"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockPath serves /lock/<name>: POST ?holder= acquires, PUT ?token= renews and
+// DELETE ?token= releases.
+const lockPath = "/lock/"
+
+// DefaultTTL is the lease duration when Config.TTL is zero.
+const DefaultTTL = 10 * time.Second
+
+var (
+	// ErrHeld is returned when another holder has the lock.
+	ErrHeld = errors.New("lock is held")
+	// ErrLeaseLost is returned when the lease expired, was revoked or released.
+	ErrLeaseLost = errors.New("lease lost")
+	// ErrNotOwner is returned by a member that doesn't serve the lock.
+	ErrNotOwner = errors.New("member is not the owner of the lock")
+	// ErrGracePeriod is returned while a member waits for the leases of the previous
+	// owner to expire.
+	ErrGracePeriod = errors.New("lock owner is in its grace period")
+)
+
+// Config controls the leases of a Server.
+type Config struct {
+	// Name is the member name of this server in the ring.
+	Name string
+	// TTL is how long a lease lasts without renewal, DefaultTTL if zero.
+	TTL time.Duration
+}
+
+// grant is the answer to an acquire or a renewal.
+type grant struct {
+	Token uint64        `json:"token"`
+	TTL   time.Duration `json:"ttl"`
+}
+
+type lease struct {
+	holder  string
+	token   uint64
+	expires time.Time
+}
+
+// Server grants the leases of the locks this member owns.
+type Server struct {
+	config Config
+	ring   hash.Ring
+
+	mu     sync.Mutex
+	leases map[string]lease
+	// ownedSince records when the server started to serve each partition.
+	ownedSince map[int]time.Time
+	lastToken  uint64
+}
+
+func NewServer(config Config, ring hash.Ring) (*Server, error) {
+	if config.Name == "" {
+		return nil, errors.New("server name is required")
+	}
+	if config.TTL == 0 {
+		config.TTL = DefaultTTL
+	}
+	if config.TTL < 0 {
+		return nil, errors.New("lease TTL must be positive")
+	}
+	return &Server{
+		config:     config,
+		ring:       ring,
+		leases:     make(map[string]lease),
+		ownedSince: make(map[int]time.Time),
+	}, nil
+}
+
+// owns returns the partition of the lock and whether this server owns it.
+func (s *Server) owns(name string) (int, bool) {
+	partID := s.ring.FindPartitionID([]byte(name))
+	owners, err := s.ring.GetClosestNForPartition(partID, 1)
+	return partID, err == nil && owners[0].Name == s.config.Name
+}
+
+// nextToken returns a token above every token handed out by this server. Tokens
+// follow the clock so that the tokens of a new owner are above the ones of the
+// previous owner, as long as the clock skew stays below the TTL.
+func (s *Server) nextToken(now time.Time) uint64 {
+	t := uint64(now.UnixNano())
+	if t <= s.lastToken {
+		t = s.lastToken + 1
+	}
+	s.lastToken = t
+	return t
+}
+
+// Acquire grants the lock to the holder unless someone else holds it. A holder
+// asking again gets its current lease back.
+func (s *Server) Acquire(name, holder string) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	partID, ok := s.owns(name)
+	if !ok {
+		return 0, ErrNotOwner
+	}
+	since, ok := s.ownedSince[partID]
+	if !ok {
+		// Nothing tells whether another member granted leases on this partition
+		// before, a restart included, so wait for them to expire.
+		since = now
+		s.ownedSince[partID] = since
+	}
+	if now.Before(since.Add(s.config.TTL)) {
+		return 0, ErrGracePeriod
+	}
+	if l, ok := s.leases[name]; ok && now.Before(l.expires) {
+		if l.holder != holder {
+			return 0, ErrHeld
+		}
+		return l.token, nil
+	}
+	l := lease{holder: holder, token: s.nextToken(now), expires: now.Add(s.config.TTL)}
+	s.leases[name] = l
+	return l.token, nil
+}
+
+// current returns the live lease of the lock if it has the token.
+func (s *Server) current(name string, token uint64, now time.Time) (lease, error) {
+	if _, ok := s.owns(name); !ok {
+		return lease{}, ErrNotOwner
+	}
+	l, ok := s.leases[name]
+	if !ok || l.token != token || !now.Before(l.expires) {
+		return lease{}, ErrLeaseLost
+	}
+	return l, nil
+}
+
+// Renew extends the lease with the token for another TTL.
+func (s *Server) Renew(name string, token uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	l, err := s.current(name, token, now)
+	if err != nil {
+		return err
+	}
+	l.expires = now.Add(s.config.TTL)
+	s.leases[name] = l
+	return nil
+}
+
+// Release drops the lease with the token.
+func (s *Server) Release(name string, token uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.current(name, token, time.Now()); err != nil {
+		return err
+	}
+	delete(s.leases, name)
+	return nil
+}
+
+// Rebalance revokes the leases of the partitions this server no longer owns and
+// drops the expired ones. Call it after every membership change; it returns the
+// number of leases revoked.
+func (s *Server) Rebalance() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for partID := range s.ownedSince {
+		owners, err := s.ring.GetClosestNForPartition(partID, 1)
+		if err != nil || owners[0].Name != s.config.Name {
+			delete(s.ownedSince, partID)
+		}
+	}
+	revoked := 0
+	for name, l := range s.leases {
+		if _, ok := s.ownedSince[s.ring.FindPartitionID([]byte(name))]; !ok {
+			delete(s.leases, name)
+			revoked++
+		} else if !now.Before(l.expires) {
+			delete(s.leases, name)
+		}
+	}
+	return revoked
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, lockPath)
+	if !strings.HasPrefix(r.URL.Path, lockPath) || name == "" {
+		http.NotFound(w, r)
+		return
+	}
+	query := r.URL.Query()
+	if r.Method == http.MethodPost {
+		holder := query.Get("holder")
+		if holder == "" {
+			http.Error(w, "holder is required", http.StatusBadRequest)
+			return
+		}
+		token, err := s.Acquire(name, holder)
+		if err != nil {
+			writeError(w, err, s.config.TTL)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grant{Token: token, TTL: s.config.TTL})
+		return
+	}
+
+	token, err := strconv.ParseUint(query.Get("token"), 10, 64)
+	if err != nil {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if err := s.Renew(name, token); err != nil {
+			writeError(w, err, s.config.TTL)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(grant{Token: token, TTL: s.config.TTL})
+	case http.MethodDelete:
+		if err := s.Release(name, token); err != nil {
+			writeError(w, err, s.config.TTL)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// statusCodes maps the errors of the server to the status codes the client maps back.
+var statusCodes = map[error]int{
+	ErrHeld:        http.StatusConflict,
+	ErrLeaseLost:   http.StatusGone,
+	ErrNotOwner:    http.StatusMisdirectedRequest,
+	ErrGracePeriod: http.StatusServiceUnavailable,
+}
+
+func writeError(w http.ResponseWriter, err error, ttl time.Duration) {
+	code, ok := statusCodes[err]
+	if !ok {
+		code = http.StatusInternalServerError
+	}
+	if err == ErrGracePeriod {
+		w.Header().Set("Retry-After", strconv.Itoa(int(ttl.Seconds())+1))
+	}
+	http.Error(w, err.Error(), code)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"distributed-lb/client"
+	"distributed-lb/lock"
+	"distributed-lb/message"
+	"flag"
+	"fmt"
+	"net/http"
+)
+
+func main() {
+	name := flag.String("name", "", "member name of this node in the ring")
+	addr := flag.String("addr", "127.0.0.1:9201", "listen address, must match the member address known to the coordinator")
+	coordinator := flag.String("coordinator", "http://127.0.0.1:8081", "coordinator url")
+	ring := flag.String("ring", "", "ring name, the coordinator's default ring if empty")
+	ttl := flag.Duration("ttl", lock.DefaultTTL, "lease duration without renewal")
+	flag.Parse()
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	url := *coordinator
+	if *ring != "" {
+		url += "/rings/" + *ring
+	}
+	c := client.New(url)
+
+	server, err := lock.NewServer(lock.Config{Name: *name, TTL: *ttl}, c)
+	if err != nil {
+		fmt.Println("Error creating the lock server:", err)
+		return
+	}
+	// Revoke the leases of the locks that moved to another member.
+	c.OnChange(func(msg message.Message) {
+		if revoked := server.Rebalance(); revoked > 0 {
+			fmt.Println("Revoked", revoked, "leases after a membership change")
+		}
+	})
+	go c.Run(cancel)
+
+	go func() {
+		fmt.Println("Lock node " + *name + " is running on http://" + *addr)
+		err := http.ListenAndServe(*addr, server)
+		if err != nil {
+			fmt.Println("Error starting the http server:" + err.Error())
+			cancel(err)
+		}
+	}()
+	<-ctx.Done()
+	fmt.Println(context.Cause(ctx))
+}
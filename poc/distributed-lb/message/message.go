@@ -2,6 +2,7 @@ package message
 
 import (
 	"distributed-lb/hash"
+	"errors"
 	"log"
 )
 
@@ -11,47 +12,80 @@ const (
 	INIT        = 1
 	ADD         = 2
 	REMOVE      = 3
+	// UPDATE carries members whose metadata changed, their placement stays the same.
+	UPDATE = 4
 )
 
 type Message struct {
-	Command           int
+	Command int
+	// Ring is the name of the ring the message belongs to.
+	Ring              string `json:",omitempty"`
 	Error             string
 	Members           hash.MemberList
 	PartitionCount    int
 	ReplicationFactor int
 	Load              float64
+	ReplicaCount      int
 	Time              string
 }
 
-func (msg Message) Update(c *hash.Consistent) *hash.Consistent {
+// Update applies the message to c and returns the resulting ring. Adding a member
+// that is already known refreshes its metadata and removing one that is gone is not
+// an error, so a message can be applied more than once.
+func (msg Message) Update(c *hash.Consistent) (*hash.Consistent, error) {
 	switch msg.Command {
 	case INIT:
 		cfg := hash.Config{
 			PartitionCount:    msg.PartitionCount,
 			ReplicationFactor: msg.ReplicationFactor,
 			Load:              msg.Load,
+			ReplicaCount:      msg.ReplicaCount,
 		}
-		c = hash.New(msg.Members, cfg)
+		n, err := hash.New(msg.Members, cfg)
+		if err != nil {
+			return c, err
+		}
+		c = n
 		log.Printf("Initializing node:  %+v\n", msg)
 	case ADD:
+		if c == nil {
+			return c, errors.New("received ADD before INIT")
+		}
 		for _, m := range msg.Members {
-			//fmt.Println("Adding new member:", m.String())
-			c.Add(m)
+			err := c.Add(m)
+			if errors.Is(err, hash.ErrMemberExists) {
+				err = c.Update(m)
+			}
+			if err != nil {
+				return c, err
+			}
 		}
 		log.Printf("Adding node: %+v\n", msg.Members)
 	case REMOVE:
-		// oldMembers := c.GetMembers()
-		// fmt.Println(oldMembers)
+		if c == nil {
+			return c, errors.New("received REMOVE before INIT")
+		}
 		for _, m := range msg.Members {
-			//fmt.Println("Removing member:", m.String())
-			c.Remove(m.String())
+			if err := c.Remove(m.String()); err != nil && !errors.Is(err, hash.ErrMemberNotFound) {
+				return c, err
+			}
 		}
 		log.Printf("Deleting node: %+v\n", msg.Members)
+	case UPDATE:
+		if c == nil {
+			return c, errors.New("received UPDATE before INIT")
+		}
+		for _, m := range msg.Members {
+			if err := c.Update(m); err != nil {
+				return c, err
+			}
+		}
+		log.Printf("Updating node: %+v\n", msg.Members)
 	case ERROR:
 		log.Println("Error: ", msg.Error)
-		return nil
+		return nil, nil
 	case HEALTHCHECK:
 		log.Println("Received HealthCheck command")
 	}
-	return c
+	return c, nil
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"distributed-lb/client"
+	"distributed-lb/message"
+	"distributed-lb/ratelimit"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+func main() {
+	name := flag.String("name", "", "member name of this node in the ring")
+	addr := flag.String("addr", "127.0.0.1:9301", "listen address, must match the member address known to the coordinator")
+	coordinator := flag.String("coordinator", "http://127.0.0.1:8081", "coordinator url")
+	ring := flag.String("ring", "", "ring name, the coordinator's default ring if empty")
+	algorithm := flag.String("algorithm", ratelimit.TokenBucket, "default algorithm, token-bucket or sliding-window")
+	limit := flag.Int("limit", 100, "default number of requests per window")
+	window := flag.Duration("window", time.Minute, "default window")
+	quotasFile := flag.String("quotas", "", "json file with the quotas, overrides the defaults above")
+	flag.Parse()
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	quotas := ratelimit.Quotas{Default: ratelimit.Quota{Algorithm: *algorithm, Limit: *limit, Window: *window}}
+	if *quotasFile != "" {
+		data, err := os.ReadFile(*quotasFile)
+		if err != nil {
+			fmt.Println("Error reading the quotas:", err)
+			return
+		}
+		if err := json.Unmarshal(data, &quotas); err != nil {
+			fmt.Println("Error parsing the quotas:", err)
+			return
+		}
+	}
+
+	url := *coordinator
+	if *ring != "" {
+		url += "/rings/" + *ring
+	}
+	c := client.New(url)
+
+	server, err := ratelimit.NewServer(ratelimit.Config{Name: *name, Quotas: quotas}, c)
+	if err != nil {
+		fmt.Println("Error creating the rate limit server:", err)
+		return
+	}
+	c.OnChange(func(msg message.Message) {
+		server.Rebalance()
+	})
+	go c.Run(cancel)
+	go func() {
+		// Drop the limiters of the keys that went quiet.
+		for range time.Tick(time.Minute) {
+			server.Rebalance()
+		}
+	}()
+
+	go func() {
+		fmt.Println("Rate limit node " + *name + " is running on http://" + *addr)
+		err := http.ListenAndServe(*addr, server)
+		if err != nil {
+			fmt.Println("Error starting the http server:" + err.Error())
+			cancel(err)
+		}
+	}()
+	<-ctx.Done()
+	fmt.Println(context.Cause(ctx))
+}
@@ -0,0 +1,113 @@
+package ratelimit
+
+import (
+	"context"
+	"distributed-lb/hash"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ClientConfig controls the fallback of a Client.
+type ClientConfig struct {
+	// Quotas must match the quotas of the servers.
+	Quotas Quotas
+	// LocalShare is the part of each quota the client allows on its own while the owner
+	// of the key can't be reached, 1 if zero. With k clients, 1/k keeps the cluster
+	// wide rate close to the quota.
+	LocalShare float64
+	// Timeout bounds the calls to the owners.
+	Timeout time.Duration
+}
+
+// Client asks the owners of the keys for decisions.
+type Client struct {
+	config     ClientConfig
+	locator    hash.Locator
+	httpClient *http.Client
+
+	mu    sync.Mutex
+	local map[string]limiter
+}
+
+func NewClient(config ClientConfig, locator hash.Locator) (*Client, error) {
+	if config.LocalShare == 0 {
+		config.LocalShare = 1
+	}
+	if config.LocalShare < 0 || config.LocalShare > 1 {
+		return nil, fmt.Errorf("local share must be between 0 and 1, got %v", config.LocalShare)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	if err := config.Quotas.validate(); err != nil {
+		return nil, err
+	}
+	return &Client{
+		config:     config,
+		locator:    locator,
+		httpClient: &http.Client{Timeout: config.Timeout},
+		local:      make(map[string]limiter),
+	}, nil
+}
+
+// Allow asks the owner of the key for n units. When the owner can't be reached, or
+// isn't the owner anymore, the decision comes from a local limiter with LocalShare of
+// the quota and has Local set.
+func (c *Client) Allow(ctx context.Context, key string, n int) (Decision, error) {
+	if n < 1 {
+		return Decision{}, fmt.Errorf("n must be positive, got %d", n)
+	}
+	d, err := c.ask(ctx, key, n)
+	if err == nil {
+		c.mu.Lock()
+		delete(c.local, key)
+		c.mu.Unlock()
+		return d, nil
+	}
+	if ctx.Err() != nil {
+		return Decision{}, err
+	}
+	return c.allowLocally(key, n), nil
+}
+
+func (c *Client) ask(ctx context.Context, key string, n int) (Decision, error) {
+	m, err := c.locator.LocateKey([]byte(key))
+	if err != nil {
+		return Decision{}, err
+	}
+	u := "http://" + m.Address() + limitPath + url.PathEscape(key) + "?n=" + strconv.Itoa(n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, nil)
+	if err != nil {
+		return Decision{}, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return Decision{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusTooManyRequests {
+		return Decision{}, fmt.Errorf("%s: %s", m, resp.Status)
+	}
+	var d Decision
+	err = json.NewDecoder(resp.Body).Decode(&d)
+	return d, err
+}
+
+func (c *Client) allowLocally(key string, n int) Decision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	l, ok := c.local[key]
+	if !ok {
+		l = newLimiter(c.config.Quotas.For(key).scale(c.config.LocalShare), now)
+		c.local[key] = l
+	}
+	d := l.allow(now, n)
+	d.Local = true
+	return d
+}
@@ -0,0 +1,207 @@
+// Package ratelimit enforces per-key rate limits across the cluster. Each limiter key
+// is served by the member LocateKey returns for it, the owner keeps the only state of
+// the key so the quota holds whatever member the requests land on.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	// TokenBucket refills Limit tokens per Window and allows bursts up to Burst.
+	TokenBucket = "token-bucket"
+	// SlidingWindow allows Limit requests in any Window, weighing the previous fixed
+	// window by how much of it still overlaps the sliding one.
+	SlidingWindow = "sliding-window"
+)
+
+// Quota is the limit of a key.
+type Quota struct {
+	Algorithm string        `json:"algorithm"`
+	Limit     int           `json:"limit"`
+	Window    time.Duration `json:"window"`
+	// Burst is the bucket size of TokenBucket, Limit if zero.
+	Burst int `json:"burst,omitempty"`
+}
+
+func (q Quota) validate() error {
+	if q.Algorithm != TokenBucket && q.Algorithm != SlidingWindow {
+		return fmt.Errorf("unknown algorithm %q", q.Algorithm)
+	}
+	if q.Limit < 1 || q.Window <= 0 || q.Burst < 0 {
+		return fmt.Errorf("quota needs a positive limit and window, got %d per %v", q.Limit, q.Window)
+	}
+	return nil
+}
+
+// scale returns the quota with its limit and burst multiplied by share, at least 1.
+func (q Quota) scale(share float64) Quota {
+	scaled := func(v int) int {
+		return max(1, int(math.Ceil(float64(v)*share)))
+	}
+	q.Limit = scaled(q.Limit)
+	if q.Burst > 0 {
+		q.Burst = scaled(q.Burst)
+	}
+	return q
+}
+
+// Quotas gives the quota of every key, Default unless the key has its own.
+type Quotas struct {
+	Default Quota            `json:"default"`
+	Keys    map[string]Quota `json:"keys,omitempty"`
+}
+
+// For returns the quota of the key.
+func (q Quotas) For(key string) Quota {
+	if quota, ok := q.Keys[key]; ok {
+		return quota
+	}
+	return q.Default
+}
+
+func (q Quotas) validate() error {
+	if err := q.Default.validate(); err != nil {
+		return fmt.Errorf("default quota: %w", err)
+	}
+	for key, quota := range q.Keys {
+		if err := quota.validate(); err != nil {
+			return fmt.Errorf("quota of %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// Decision is the answer to a request for n units of a key.
+type Decision struct {
+	Allowed   bool `json:"allowed"`
+	Remaining int  `json:"remaining"`
+	// RetryAfter is how long to wait before the same request can be allowed.
+	RetryAfter time.Duration `json:"retryAfter,omitempty"`
+	// Local is set by the client when the owner couldn't be reached and the decision
+	// comes from its local approximate limit.
+	Local bool `json:"local,omitempty"`
+}
+
+// limiter is the state of a key. It isn't safe for concurrent use.
+type limiter interface {
+	allow(now time.Time, n int) Decision
+	// idle reports whether the limiter is back to its initial state.
+	idle(now time.Time) bool
+}
+
+func newLimiter(q Quota, now time.Time) limiter {
+	if q.Algorithm == SlidingWindow {
+		return &slidingWindow{limit: q.Limit, window: q.Window, start: now.Truncate(q.Window)}
+	}
+	capacity := q.Burst
+	if capacity == 0 {
+		capacity = q.Limit
+	}
+	return &tokenBucket{
+		rate:     float64(q.Limit) / q.Window.Seconds(),
+		capacity: float64(capacity),
+		tokens:   float64(capacity),
+		last:     now,
+	}
+}
+
+type tokenBucket struct {
+	rate     float64 // tokens per second
+	capacity float64
+	tokens   float64
+	last     time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.capacity, b.tokens+elapsed*b.rate)
+		b.last = now
+	}
+}
+
+func (b *tokenBucket) allow(now time.Time, n int) Decision {
+	b.refill(now)
+	need := float64(n)
+	if b.tokens >= need {
+		b.tokens -= need
+		return Decision{Allowed: true, Remaining: int(b.tokens)}
+	}
+	missing := need - b.tokens
+	if need > b.capacity {
+		// The bucket never holds that many tokens, wait for a full one at least.
+		missing = b.capacity - b.tokens
+	}
+	return Decision{Remaining: int(b.tokens), RetryAfter: seconds(missing / b.rate)}
+}
+
+func (b *tokenBucket) idle(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.capacity
+}
+
+type slidingWindow struct {
+	limit    int
+	window   time.Duration
+	start    time.Time // start of the current fixed window
+	current  int
+	previous int
+}
+
+func (w *slidingWindow) advance(now time.Time) {
+	start := now.Truncate(w.window)
+	if start.Equal(w.start) {
+		return
+	}
+	if start.Sub(w.start) == w.window {
+		w.previous = w.current
+	} else {
+		w.previous = 0
+	}
+	w.current = 0
+	w.start = start
+}
+
+// estimate returns the requests counted in the sliding window ending at now.
+func (w *slidingWindow) estimate(now time.Time) float64 {
+	overlap := 1 - float64(now.Sub(w.start))/float64(w.window)
+	return float64(w.previous)*overlap + float64(w.current)
+}
+
+func (w *slidingWindow) allow(now time.Time, n int) Decision {
+	w.advance(now)
+	limit := float64(w.limit)
+	// The tolerance absorbs the rounding of the weight, so the RetryAfter hint is exact.
+	if used := w.estimate(now); used+float64(n) <= limit+1e-9 {
+		w.current += n
+		return Decision{Allowed: true, Remaining: max(0, int(limit-used-float64(n)))}
+	}
+	var wait time.Duration
+	if w.current+n <= w.limit {
+		// Wait for enough of the previous window to slide out.
+		free := limit - float64(w.current+n)
+		at := time.Duration((1 - free/float64(w.previous)) * float64(w.window))
+		wait = at - now.Sub(w.start)
+	} else {
+		// The current window becomes the previous one and has to slide out.
+		free := math.Max(0, limit-float64(n))
+		wait = w.start.Add(w.window).Sub(now)
+		if w.current > 0 {
+			wait += time.Duration(math.Max(0, 1-free/float64(w.current)) * float64(w.window))
+		}
+	}
+	// Round up to the millisecond so the request fits at the hinted time.
+	wait = (wait + time.Millisecond - 1).Truncate(time.Millisecond)
+	return Decision{Remaining: max(0, int(limit-w.estimate(now))), RetryAfter: max(wait, time.Millisecond)}
+}
+
+func (w *slidingWindow) idle(now time.Time) bool {
+	w.advance(now)
+	return w.current == 0 && w.previous == 0
+}
+
+func seconds(s float64) time.Duration {
+	return max(time.Duration(math.Ceil(s*float64(time.Second))), time.Millisecond)
+}
@@ -0,0 +1,130 @@
+package ratelimit
+
+import (
+	"context"
+	"distributed-lb/hash"
+	"distributed-lb/internal/testcluster"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(1000, 0)
+	l := newLimiter(Quota{Algorithm: TokenBucket, Limit: 10, Window: time.Second, Burst: 5}, now)
+	for i := 0; i < 5; i++ {
+		if d := l.allow(now, 1); !d.Allowed || d.Remaining != 4-i {
+			t.Fatalf("request %d: %+v", i, d)
+		}
+	}
+	d := l.allow(now, 1)
+	if d.Allowed || d.RetryAfter != 100*time.Millisecond {
+		t.Fatalf("empty bucket: %+v, want a retry after 100ms", d)
+	}
+	if d := l.allow(now.Add(d.RetryAfter), 1); !d.Allowed {
+		t.Fatalf("after the retry hint: %+v", d)
+	}
+	if !l.idle(now.Add(time.Second)) {
+		t.Fatal("the bucket must be full again after a second")
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	start := time.Unix(1000, 0)
+	l := newLimiter(Quota{Algorithm: SlidingWindow, Limit: 10, Window: time.Second}, start)
+	for i := 0; i < 10; i++ {
+		if d := l.allow(start.Add(time.Duration(i)*time.Millisecond), 1); !d.Allowed {
+			t.Fatalf("request %d: %+v", i, d)
+		}
+	}
+	d := l.allow(start.Add(500*time.Millisecond), 1)
+	if d.Allowed {
+		t.Fatalf("full window: %+v", d)
+	}
+	// 10 requests in the previous window: a request fits once 10% of it slid out.
+	retry := start.Add(500 * time.Millisecond).Add(d.RetryAfter)
+	if want := start.Add(1100 * time.Millisecond); retry.Sub(want).Abs() > time.Millisecond {
+		t.Fatalf("retry at %v, want %v", retry.Sub(start), want.Sub(start))
+	}
+	if d := l.allow(retry.Add(-10*time.Millisecond), 1); d.Allowed {
+		t.Fatalf("before the retry hint: %+v", d)
+	}
+	if d := l.allow(retry, 1); !d.Allowed {
+		t.Fatalf("at the retry hint: %+v", d)
+	}
+	if !l.idle(start.Add(3 * time.Second)) {
+		t.Fatal("the window must be empty after two windows")
+	}
+}
+
+type cluster = testcluster.Cluster[*Server]
+
+func newCluster(t *testing.T, size int, quotas Quotas) *cluster {
+	t.Helper()
+	ringConfig := hash.Config{PartitionCount: 71, ReplicationFactor: 20, Load: 1.25}
+	return testcluster.New(t, size, size, ringConfig, func(name string, ring *testcluster.LazyRing) (*Server, error) {
+		return NewServer(Config{Name: name, Quotas: quotas}, ring)
+	})
+}
+
+func TestClusterLimitAndFallback(t *testing.T) {
+	quotas := Quotas{
+		Default: Quota{Algorithm: SlidingWindow, Limit: 5, Window: time.Minute},
+		Keys:    map[string]Quota{"premium": {Algorithm: TokenBucket, Limit: 8, Window: time.Minute}},
+	}
+	c := newCluster(t, 3, quotas)
+	// Two clients share the quota of every key, each gets half when on its own.
+	cfg := ClientConfig{Quotas: quotas, LocalShare: 0.5}
+	a, err := NewClient(cfg, c.LazyRing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := NewClient(cfg, c.LazyRing())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for key, limit := range map[string]int{"free": 5, "premium": 8} {
+		allowed := 0
+		for i := 0; i < 2*limit; i++ {
+			client := a
+			if i%2 == 1 {
+				client = b
+			}
+			d, err := client.Allow(ctx, key, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Local {
+				t.Fatalf("%s: unexpected local decision", key)
+			}
+			if d.Allowed {
+				allowed++
+			} else if d.RetryAfter <= 0 {
+				t.Fatalf("%s: denied without a retry hint: %+v", key, d)
+			}
+		}
+		if allowed != limit {
+			t.Fatalf("%s: %d requests allowed across the clients, want %d", key, allowed, limit)
+		}
+	}
+
+	owner := c.Ring.LocateKey([]byte("orphan")).Name
+	c.HTTP[owner].Close()
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		d, err := a.Allow(ctx, "orphan", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !d.Local {
+			t.Fatalf("the owner is down but the decision isn't local: %+v", d)
+		}
+		if d.Allowed {
+			allowed++
+		}
+	}
+	if allowed != 3 {
+		t.Fatalf("%d requests allowed locally, want half of the quota rounded up: 3", allowed)
+	}
+}
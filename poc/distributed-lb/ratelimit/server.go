@@ -0,0 +1,111 @@
+package ratelimit
+
+import (
+	"distributed-lb/hash"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// limitPath serves POST /limit/<key>?n=<units>. The answer is a Decision, with
+// 200 when allowed and 429 plus Retry-After when denied.
+const limitPath = "/limit/"
+
+// ErrNotOwner is returned by a member that doesn't serve the key.
+var ErrNotOwner = errors.New("member is not the owner of the key")
+
+// Config controls the limits of a Server.
+type Config struct {
+	// Name is the member name of this server in the ring.
+	Name   string
+	Quotas Quotas
+}
+
+// Server keeps the limiters of the keys this member owns.
+type Server struct {
+	config Config
+	ring   hash.Ring
+
+	mu       sync.Mutex
+	limiters map[string]limiter
+}
+
+func NewServer(config Config, ring hash.Ring) (*Server, error) {
+	if config.Name == "" {
+		return nil, errors.New("server name is required")
+	}
+	if err := config.Quotas.validate(); err != nil {
+		return nil, err
+	}
+	return &Server{config: config, ring: ring, limiters: make(map[string]limiter)}, nil
+}
+
+func (s *Server) owns(key string) bool {
+	owners, err := s.ring.GetClosestNForPartition(s.ring.FindPartitionID([]byte(key)), 1)
+	return err == nil && owners[0].Name == s.config.Name
+}
+
+// Allow takes n units from the quota of the key.
+func (s *Server) Allow(key string, n int) (Decision, error) {
+	if !s.owns(key) {
+		return Decision{}, ErrNotOwner
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	l, ok := s.limiters[key]
+	if !ok {
+		l = newLimiter(s.config.Quotas.For(key), now)
+		s.limiters[key] = l
+	}
+	return l.allow(now, n), nil
+}
+
+// Rebalance drops the limiters of the keys this server no longer owns, their new
+// owner starts them afresh, and the limiters that are back to their initial state.
+// Call it after every membership change and from time to time.
+func (s *Server) Rebalance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for key, l := range s.limiters {
+		if !s.owns(key) || l.idle(now) {
+			delete(s.limiters, key)
+		}
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, limitPath)
+	if !strings.HasPrefix(r.URL.Path, limitPath) || key == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	n := 1
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 1 {
+			http.Error(w, "n must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	d, err := s.Allow(key, n)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusMisdirectedRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if !d.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(d.RetryAfter.Seconds())+1))
+		w.WriteHeader(http.StatusTooManyRequests)
+	}
+	json.NewEncoder(w).Encode(d)
+}
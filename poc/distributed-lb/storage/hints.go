@@ -0,0 +1,393 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"distributed-lb/hash"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrHintsFull is returned when the hint store reached its size cap.
+var ErrHintsFull = errors.New("hint store is full")
+
+// DefaultHintTTL is how long a hint waits for its owner by default.
+const DefaultHintTTL = 3 * time.Hour
+
+// minHintCompaction is the number of records below which the hint log isn't compacted.
+const minHintCompaction = 1024
+
+// Hint is a write accepted on behalf of a replica that couldn't be reached. It is
+// replayed to the intended owner once the owner is healthy again.
+type Hint struct {
+	Owner   string    `json:"owner"`
+	PartID  int       `json:"partID"`
+	Key     string    `json:"key"`
+	Item    Item      `json:"item"`
+	Created time.Time `json:"created"`
+}
+
+// HintStats counts what happened to the hints of a node.
+type HintStats struct {
+	Stored   int64
+	Replayed int64
+	Expired  int64
+	Rejected int64
+	Pending  int
+}
+
+// HintStore keeps the hints in memory and, when it has a path, in a log the changes
+// are appended to so the hints survive a restart. Only the latest hint of an owner
+// and key is kept. The log is rewritten with the live hints once most of its records
+// are replaced or removed ones.
+type HintStore struct {
+	mu    sync.Mutex
+	path  string
+	max   int
+	ttl   time.Duration
+	hints map[string]map[string]Hint
+	count int
+	stats HintStats
+	// records is the number of records in the log.
+	records int
+}
+
+// hintRecord is a line of the hint log, either an added hint or a removed one.
+type hintRecord struct {
+	Add    *Hint    `json:"add,omitempty"`
+	Delete *hintKey `json:"delete,omitempty"`
+}
+
+type hintKey struct {
+	Owner string `json:"owner"`
+	Key   string `json:"key"`
+}
+
+// OpenHintStore loads the hints saved at path, if any, and compacts the log. An empty
+// path keeps the hints in memory only. max caps the number of hints and ttl drops the
+// hints that could not be delivered in time.
+func OpenHintStore(path string, max int, ttl time.Duration) (*HintStore, error) {
+	h := &HintStore{
+		path:  path,
+		max:   max,
+		ttl:   ttl,
+		hints: make(map[string]map[string]Hint),
+	}
+	if path == "" {
+		return h, nil
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if err := h.load(f); err != nil {
+		return nil, fmt.Errorf("hint file %s: %w", path, err)
+	}
+	if err := h.compact(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// load replays the records of the log. A record cut short by a crash ends the log.
+func (h *HintStore) load(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	for {
+		var rec hintRecord
+		err := dec.Decode(&rec)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch {
+		case rec.Add != nil:
+			h.put(*rec.Add)
+		case rec.Delete != nil:
+			if _, ok := h.hints[rec.Delete.Owner][rec.Delete.Key]; ok {
+				h.delete(rec.Delete.Owner, rec.Delete.Key)
+			}
+		}
+	}
+}
+
+func (h *HintStore) put(hint Hint) {
+	byKey, ok := h.hints[hint.Owner]
+	if !ok {
+		byKey = make(map[string]Hint)
+		h.hints[hint.Owner] = byKey
+	}
+	if _, exists := byKey[hint.Key]; !exists {
+		h.count++
+	}
+	byKey[hint.Key] = hint
+}
+
+// Add stores the hint. A newer hint for the same owner and key replaces the older one.
+// The expired hints don't count toward the cap, and a hint that can't be saved isn't kept.
+func (h *HintStore) Add(hint Hint) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire()
+	if current, ok := h.hints[hint.Owner][hint.Key]; ok {
+		if !hint.Item.newer(current.Item) {
+			return nil
+		}
+	} else if h.count >= h.max {
+		h.stats.Rejected++
+		return fmt.Errorf("%w: %d hints", ErrHintsFull, h.count)
+	}
+	if hint.Created.IsZero() {
+		hint.Created = time.Now()
+	}
+	if err := h.append(hintRecord{Add: &hint}); err != nil {
+		return err
+	}
+	h.put(hint)
+	h.stats.Stored++
+	return h.compactIfLarge()
+}
+
+// Pending returns the hints of the owner that haven't expired, dropping the expired ones.
+func (h *HintStore) Pending(owner string) []Hint {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expire()
+	res := make([]Hint, 0, len(h.hints[owner]))
+	for _, hint := range h.hints[owner] {
+		res = append(res, hint)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Key < res[j].Key })
+	return res
+}
+
+// Owners returns the owners that have hints waiting for them.
+func (h *HintStore) Owners() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := make([]string, 0, len(h.hints))
+	for owner := range h.hints {
+		res = append(res, owner)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// delivered removes the hint unless a newer one replaced it in the meantime.
+func (h *HintStore) delivered(hint Hint) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	current, ok := h.hints[hint.Owner][hint.Key]
+	if !ok || current.Item.newer(hint.Item) {
+		return nil
+	}
+	if err := h.append(hintRecord{Delete: &hintKey{Owner: hint.Owner, Key: hint.Key}}); err != nil {
+		return err
+	}
+	h.delete(hint.Owner, hint.Key)
+	h.stats.Replayed++
+	return h.compactIfLarge()
+}
+
+func (h *HintStore) delete(owner, key string) {
+	delete(h.hints[owner], key)
+	if len(h.hints[owner]) == 0 {
+		delete(h.hints, owner)
+	}
+	h.count--
+}
+
+func (h *HintStore) expire() {
+	if h.ttl <= 0 {
+		return
+	}
+	deadline := time.Now().Add(-h.ttl)
+	var expired []hintRecord
+	for owner, byKey := range h.hints {
+		for key, hint := range byKey {
+			if hint.Created.Before(deadline) {
+				h.delete(owner, key)
+				expired = append(expired, hintRecord{Delete: &hintKey{Owner: owner, Key: key}})
+			}
+		}
+	}
+	if len(expired) > 0 {
+		h.stats.Expired += int64(len(expired))
+		err := h.append(expired...)
+		if err == nil {
+			err = h.compactIfLarge()
+		}
+		if err != nil {
+			log.Println("Saving hints: ", err)
+		}
+	}
+}
+
+// Stats returns the counters of the store.
+func (h *HintStore) Stats() HintStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats := h.stats
+	stats.Pending = h.count
+	return stats
+}
+
+// append writes the records at the end of the hint log in one write.
+func (h *HintStore) append(records ...hintRecord) error {
+	if h.path == "" {
+		return nil
+	}
+	data, err := encodeHintRecords(records)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	h.records += len(records)
+	return nil
+}
+
+// compactIfLarge compacts the hint log once it holds more than twice as many records
+// as hints. It runs after the records were applied to the hints in memory.
+func (h *HintStore) compactIfLarge() error {
+	if h.path == "" || h.records <= minHintCompaction || h.records <= 2*h.count {
+		return nil
+	}
+	return h.compact()
+}
+
+// compact rewrites the hint log with the live hints through a temporary file so a
+// crash leaves either the old or the new content.
+func (h *HintStore) compact() error {
+	records := make([]hintRecord, 0, h.count)
+	for _, byKey := range h.hints {
+		for _, hint := range byKey {
+			hint := hint
+			records = append(records, hintRecord{Add: &hint})
+		}
+	}
+	data, err := encodeHintRecords(records)
+	if err != nil {
+		return err
+	}
+	tmp := h.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, h.path); err != nil {
+		return err
+	}
+	h.records = len(records)
+	return nil
+}
+
+func encodeHintRecords(records []hintRecord) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// HintStats returns the hint counters of the node, zero if hinted handoff is disabled.
+func (n *Node) HintStats() HintStats {
+	if n.hints == nil {
+		return HintStats{}
+	}
+	return n.hints.Stats()
+}
+
+// hint keeps the write for a replica that didn't accept it.
+func (n *Node) hint(m hash.Member, partID int, key string, item Item) error {
+	if n.hints == nil || m.Name == n.config.Name {
+		return errors.New("hinted handoff is disabled")
+	}
+	return n.hints.Add(Hint{Owner: m.Name, PartID: partID, Key: key, Item: item})
+}
+
+// ReplayHints delivers the hints kept for the member, typically once the coordinator
+// reports it back. Delivered hints are removed, the others stay for the next try.
+func (n *Node) ReplayHints(ctx context.Context, m hash.Member) error {
+	if n.hints == nil || m.Name == n.config.Name {
+		return nil
+	}
+	var errs []error
+	for _, hint := range n.hints.Pending(m.Name) {
+		if err := n.putReplica(ctx, m, hint.PartID, hint.Key, hint.Item); err != nil {
+			errs = append(errs, err)
+			// The member is likely still down, keep the rest for later.
+			break
+		}
+		if err := n.hints.delivered(hint); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// StartHintReplay retries the pending hints every interval until ctx is done. The owners
+// are looked up in the replica lists of the hinted partitions.
+func (n *Node) StartHintReplay(ctx context.Context, interval time.Duration) {
+	if n.hints == nil {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, owner := range n.hints.Owners() {
+				m, ok := n.findMember(owner)
+				if !ok {
+					continue
+				}
+				if err := n.ReplayHints(ctx, m); err != nil {
+					log.Printf("Replaying hints to %s: %v\n", owner, err)
+				}
+			}
+		}
+	}
+}
+
+// findMember returns the member with its address from the replica lists of the
+// partitions it has hints for.
+func (n *Node) findMember(name string) (hash.Member, bool) {
+	for _, hint := range n.hints.Pending(name) {
+		replicas, err := n.ring.GetClosestNForPartition(hint.PartID, n.config.Replicas)
+		if err != nil {
+			continue
+		}
+		for _, m := range replicas {
+			if m.Name == name {
+				return m, true
+			}
+		}
+	}
+	return hash.Member{}, false
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"distributed-lb/hash"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// faultyTransport fails the requests to the hosts marked down, the servers keep running.
+type faultyTransport struct {
+	mu   sync.Mutex
+	down map[string]bool
+}
+
+func (f *faultyTransport) setDown(host string, down bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.down[host] = down
+}
+
+func (f *faultyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f.mu.Lock()
+	down := f.down[req.URL.Host]
+	f.mu.Unlock()
+	if down {
+		return nil, fmt.Errorf("%s is down", req.URL.Host)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestHintedHandoff(t *testing.T) {
+	transport := &faultyTransport{down: make(map[string]bool)}
+	c := newCluster(t, 3, Config{Replicas: 3, WriteQuorum: 3, ReadQuorum: 1, Timeout: time.Second, MaxHints: 100, Transport: transport})
+	var node1 hash.Member
+	for _, m := range c.Ring.GetMembers() {
+		if m.Name == "node1" {
+			node1 = m
+		}
+	}
+	transport.setDown(node1.Address(), true)
+
+	entry := c.HTTP["node0"].URL
+	for i := 0; i < 30; i++ {
+		req, _ := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/kv/key-%d", entry, i), nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent || resp.Header.Get(hintedHeader) != "1" {
+			t.Fatalf("PUT key-%d: %d, %s=%q", i, resp.StatusCode, hintedHeader, resp.Header.Get(hintedHeader))
+		}
+	}
+	if parts := c.Servers["node1"].Store().Partitions(); len(parts) != 0 {
+		t.Fatalf("node1 is down but holds %d partitions", len(parts))
+	}
+	pending := c.Servers["node0"].HintStats().Pending + c.Servers["node2"].HintStats().Pending
+	if pending != 30 {
+		t.Fatalf("%d pending hints, want 30", pending)
+	}
+
+	transport.setDown(node1.Address(), false)
+	for _, name := range []string{"node0", "node2"} {
+		if err := c.Servers[name].ReplayHints(context.Background(), node1); err != nil {
+			t.Fatal(err)
+		}
+		if stats := c.Servers[name].HintStats(); stats.Pending != 0 {
+			t.Fatalf("%s still has %d pending hints", name, stats.Pending)
+		}
+	}
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if _, ok := c.Servers["node1"].Store().Get(c.Ring.FindPartitionID([]byte(key)), key); !ok {
+			t.Fatalf("%s was not handed off to node1", key)
+		}
+	}
+}
+
+func TestHintStoreLimits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hints.json")
+	h, err := OpenHintStore(path, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if err := h.Add(Hint{Owner: "node1", Key: key, Item: Item{Version: 1}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Add(Hint{Owner: "node1", Key: "c", Item: Item{Version: 1}}); !errors.Is(err, ErrHintsFull) {
+		t.Fatalf("Add over the cap: %v, want ErrHintsFull", err)
+	}
+	// A newer hint for a known key replaces the old one even when the store is full.
+	if err := h.Add(Hint{Owner: "node1", Key: "a", Item: Item{Version: 2}}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenHintStore(path, 2, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hints := reopened.Pending("node1")
+	if len(hints) != 2 || hints[0].Key != "a" || hints[0].Item.Version != 2 {
+		t.Fatalf("reopened hints = %+v", hints)
+	}
+
+	expiring, err := OpenHintStore("", 10, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := expiring.Add(Hint{Owner: "node1", Key: "a", Item: Item{Version: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if hints := expiring.Pending("node1"); len(hints) != 0 {
+		t.Fatalf("expired hints are still pending: %+v", hints)
+	}
+	if stats := expiring.Stats(); stats.Expired != 1 || stats.Pending != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestHintStoreAdd(t *testing.T) {
+	// Expired hints make room for new ones.
+	h, err := OpenHintStore("", 1, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Add(Hint{Owner: "node1", Key: "a", Item: Item{Version: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := h.Add(Hint{Owner: "node1", Key: "b", Item: Item{Version: 1}}); err != nil {
+		t.Fatalf("Add with only expired hints stored: %v", err)
+	}
+	if stats := h.Stats(); stats.Expired != 1 || stats.Pending != 1 || stats.Rejected != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	// A hint that can't be saved isn't kept.
+	unsaved, err := OpenHintStore(filepath.Join(t.TempDir(), "missing", "hints.json"), 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := unsaved.Add(Hint{Owner: "node1", Key: "a", Item: Item{Version: 1}}); err == nil {
+		t.Fatal("Add succeeded without a hint file")
+	}
+	if stats := unsaved.Stats(); stats.Stored != 0 || stats.Pending != 0 {
+		t.Fatalf("stats = %+v", stats)
+	}
+}
+
+func TestHintLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hints.json")
+	h, err := OpenHintStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Replacing and delivering hints appends records until the log is compacted.
+	for i := 1; i <= 2*minHintCompaction; i++ {
+		hint := Hint{Owner: "node1", Key: "a", Item: Item{Version: uint64(i)}}
+		if err := h.Add(hint); err != nil {
+			t.Fatal(err)
+		}
+		if i%2 == 0 {
+			if err := h.delivered(hint); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := h.Add(Hint{Owner: "node2", Key: "b", Item: Item{Version: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(data), "\n"); lines > minHintCompaction+1 {
+		t.Fatalf("the hint log has %d records, it was not compacted", lines)
+	}
+
+	// A record cut short by a crash is ignored.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"add":{"owner":"node3","ke`)
+	f.Close()
+	reopened, err := OpenHintStore(path, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owners := reopened.Owners(); fmt.Sprint(owners) != "[node2]" {
+		t.Fatalf("reopened owners = %v, want [node2]", owners)
+	}
+	if err := reopened.Add(Hint{Owner: "node3", Key: "c", Item: Item{Version: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if reopened, err = OpenHintStore(path, 10, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if owners := reopened.Owners(); fmt.Sprint(owners) != "[node2 node3]" {
+		t.Fatalf("reopened owners = %v, want [node2 node3]", owners)
+	}
+
+}
+
+func TestHintsAloneDontMakeAQuorum(t *testing.T) {
+	transport := &faultyTransport{down: make(map[string]bool)}
+	c := newCluster(t, 3, Config{Replicas: 1, WriteQuorum: 1, ReadQuorum: 1, Timeout: time.Second, MaxHints: 100, Transport: transport})
+	key, owner := keyOwnedByOther(t, c, "node0")
+	transport.setDown(owner.Address(), true)
+
+	// node0 can only keep a hint for the single replica of the key.
+	code, body := do(t, http.MethodPut, c.HTTP["node0"].URL+"/kv/"+key, "value")
+	if code != http.StatusServiceUnavailable {
+		t.Fatalf("PUT with only a hint: %d %s", code, body)
+	}
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/cespare/xxhash"
+)
+
+// DefaultTreeDepth gives 2^6 = 64 leaves per partition.
+const DefaultTreeDepth = 6
+
+// MerkleTree summarizes the key/version pairs of a partition. The keys are spread over
+// 2^Depth leaves by hash; each parent hashes its two children so that two replicas can
+// find the leaves they disagree on by comparing the trees top down.
+type MerkleTree struct {
+	Depth int `json:"depth"`
+	// Levels[0] holds the root, Levels[Depth] the leaves.
+	Levels [][]uint64 `json:"levels"`
+}
+
+// leafOf returns the leaf of the key in a tree of the given depth.
+func leafOf(key string, depth int) int {
+	if depth == 0 {
+		return 0
+	}
+	return int(xxhash.Sum64String(key) >> (64 - depth))
+}
+
+// BuildTree builds the tree of the items. Only keys, versions and tombstones are
+// hashed; replicas with the same versions have the same tree.
+func BuildTree(items map[string]Item, depth int) *MerkleTree {
+	leafCount := 1 << depth
+	buckets := make([][]string, leafCount)
+	for key := range items {
+		leaf := leafOf(key, depth)
+		buckets[leaf] = append(buckets[leaf], key)
+	}
+
+	tree := &MerkleTree{Depth: depth, Levels: make([][]uint64, depth+1)}
+	leaves := make([]uint64, leafCount)
+	buf := make([]byte, 9)
+	for i, keys := range buckets {
+		if len(keys) == 0 {
+			continue
+		}
+		sort.Strings(keys)
+		d := xxhash.New()
+		for _, key := range keys {
+			item := items[key]
+			d.Write([]byte(key))
+			binary.LittleEndian.PutUint64(buf, item.Version)
+			buf[8] = 0
+			if item.Deleted {
+				buf[8] = 1
+			}
+			d.Write(buf)
+		}
+		leaves[i] = d.Sum64()
+	}
+	tree.Levels[depth] = leaves
+
+	pair := make([]byte, 16)
+	for level := depth - 1; level >= 0; level-- {
+		children := tree.Levels[level+1]
+		nodes := make([]uint64, len(children)/2)
+		for i := range nodes {
+			if children[2*i] == 0 && children[2*i+1] == 0 {
+				continue
+			}
+			binary.LittleEndian.PutUint64(pair, children[2*i])
+			binary.LittleEndian.PutUint64(pair[8:], children[2*i+1])
+			nodes[i] = xxhash.Sum64(pair)
+		}
+		tree.Levels[level] = nodes
+	}
+	return tree
+}
+
+// validate checks the shape of a tree received from a peer.
+func (t *MerkleTree) validate(depth int) error {
+	if t.Depth != depth || len(t.Levels) != depth+1 {
+		return fmt.Errorf("tree depth %d, want %d", t.Depth, depth)
+	}
+	for level, nodes := range t.Levels {
+		if len(nodes) != 1<<level {
+			return fmt.Errorf("tree level %d has %d nodes, want %d", level, len(nodes), 1<<level)
+		}
+	}
+	return nil
+}
+
+// Root returns the digest of the whole partition, 0 for an empty partition.
+func (t *MerkleTree) Root() uint64 {
+	return t.Levels[0][0]
+}
+
+// Diff returns the leaves that differ between the trees, walking down only the
+// subtrees whose digests differ. Both trees must have the same depth.
+func (t *MerkleTree) Diff(o *MerkleTree) []int {
+	if t.Depth != o.Depth {
+		return nil
+	}
+	var leaves []int
+	var walk func(level, i int)
+	walk = func(level, i int) {
+		if t.Levels[level][i] == o.Levels[level][i] {
+			return
+		}
+		if level == t.Depth {
+			leaves = append(leaves, i)
+			return
+		}
+		walk(level+1, 2*i)
+		walk(level+1, 2*i+1)
+	}
+	walk(0, 0)
+	return leaves
+}
+
+// leafItems returns the items of the partition that fall in the leaf.
+func leafItems(items map[string]Item, depth, leaf int) map[string]Item {
+	res := make(map[string]Item)
+	for key, item := range items {
+		if leafOf(key, depth) == leaf {
+			res[key] = item
+		}
+	}
+	return res
+}
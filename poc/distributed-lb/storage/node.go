@@ -0,0 +1,466 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"distributed-lb/hash"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// kvPath serves the clients, the node forwards the request to the owner of the key.
+	kvPath = "/kv/"
+	// replicaPath serves the owner, the node only reads or writes its local store.
+	replicaPath = "/replica/"
+	// forwardedHeader marks a request forwarded by another node so it isn't forwarded again.
+	forwardedHeader = "X-Forwarded-By"
+	// hintedHeader tells the client how many replicas only got a hint of the write.
+	hintedHeader = "X-Hinted-Replicas"
+)
+
+var (
+	// ErrQuorum is returned when not enough replicas answered a read or a write.
+	ErrQuorum = errors.New("quorum not reached")
+	// ErrNotFound is returned when no replica has the key.
+	ErrNotFound = errors.New("key not found")
+)
+
+// Config controls the replication of a Node.
+type Config struct {
+	// Name is the member name of this node in the ring.
+	Name string
+	// Replicas is the number of members, the owner included, that hold a key.
+	Replicas int
+	// WriteQuorum is the number of replicas that must accept a write.
+	WriteQuorum int
+	// ReadQuorum is the number of replicas that must answer a read. Choose
+	// ReadQuorum + WriteQuorum > Replicas so that a read sees the latest write.
+	ReadQuorum int
+	// Timeout bounds the calls to the other replicas.
+	Timeout time.Duration
+	// Transport is used to reach the other nodes, http.DefaultTransport if nil.
+	Transport http.RoundTripper
+	// TreeDepth is the depth of the Merkle trees compared by the repair, DefaultTreeDepth
+	// if zero. All the nodes must use the same depth.
+	TreeDepth int
+	// MaxHints enables hinted handoff: a write for a replica that can't be reached is
+	// kept as a hint, up to MaxHints of them, and counts towards the write quorum.
+	// Zero disables it.
+	MaxHints int
+	// HintTTL drops the hints that couldn't be delivered in time, DefaultHintTTL if zero.
+	HintTTL time.Duration
+	// HintFile keeps the hints across restarts, they are kept in memory only if empty.
+	HintFile string
+}
+
+func (cfg Config) validate() error {
+	if cfg.Name == "" {
+		return errors.New("node name is required")
+	}
+	if cfg.Replicas < 1 {
+		return fmt.Errorf("replicas must be positive, got %d", cfg.Replicas)
+	}
+	if cfg.WriteQuorum < 1 || cfg.WriteQuorum > cfg.Replicas {
+		return fmt.Errorf("write quorum must be between 1 and %d, got %d", cfg.Replicas, cfg.WriteQuorum)
+	}
+	if cfg.ReadQuorum < 1 || cfg.ReadQuorum > cfg.Replicas {
+		return fmt.Errorf("read quorum must be between 1 and %d, got %d", cfg.Replicas, cfg.ReadQuorum)
+	}
+	if cfg.MaxHints < 0 {
+		return fmt.Errorf("max hints must not be negative, got %d", cfg.MaxHints)
+	}
+	if cfg.TreeDepth < 0 || cfg.TreeDepth > 16 {
+		return fmt.Errorf("tree depth must be between 0 and 16, got %d", cfg.TreeDepth)
+	}
+	return nil
+}
+
+// Node serves GET, PUT and DELETE on /kv/<key> for the keys placed by the ring.
+type Node struct {
+	config     Config
+	ring       hash.Ring
+	store      *Store
+	hints      *HintStore
+	httpClient *http.Client
+
+	mu          sync.Mutex
+	lastVersion uint64
+	repairStats RepairStats
+}
+
+// NewNode creates a storage node. The node reads the ring on every request, so
+// membership changes applied to the ring are picked up right away.
+func NewNode(config Config, ring hash.Ring) (*Node, error) {
+	if config.Timeout == 0 {
+		config.Timeout = 2 * time.Second
+	}
+	if config.TreeDepth == 0 {
+		config.TreeDepth = DefaultTreeDepth
+	}
+	if config.HintTTL == 0 {
+		config.HintTTL = DefaultHintTTL
+	}
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	transport := config.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	n := &Node{
+		config:     config,
+		ring:       ring,
+		store:      NewStore(),
+		httpClient: &http.Client{Transport: transport, Timeout: config.Timeout},
+	}
+	if config.MaxHints > 0 {
+		hints, err := OpenHintStore(config.HintFile, config.MaxHints, config.HintTTL)
+		if err != nil {
+			return nil, err
+		}
+		n.hints = hints
+	}
+	return n, nil
+}
+
+// Store exposes the local items of the node.
+func (n *Node) Store() *Store {
+	return n.store
+}
+
+func (n *Node) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasPrefix(r.URL.Path, kvPath):
+		n.serveKV(w, r, strings.TrimPrefix(r.URL.Path, kvPath))
+	case strings.HasPrefix(r.URL.Path, replicaPath):
+		n.serveReplica(w, r, strings.TrimPrefix(r.URL.Path, replicaPath))
+	case strings.HasPrefix(r.URL.Path, merklePath):
+		n.serveMerkle(w, r, strings.TrimPrefix(r.URL.Path, merklePath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (n *Node) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	if key == "" {
+		http.Error(w, "key is required", http.StatusBadRequest)
+		return
+	}
+	replicas, err := n.ring.GetClosestN([]byte(key), n.config.Replicas)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	var value []byte
+	if r.Method == http.MethodPut {
+		if value, err = io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if owner := replicas[0]; owner.Name != n.config.Name && r.Header.Get(forwardedHeader) == "" {
+		err := n.forward(w, r, owner, value)
+		if err == nil {
+			return
+		}
+		if n.hints == nil && r.Method != http.MethodGet {
+			http.Error(w, fmt.Sprintf("forwarding to %s: %v", owner, err), http.StatusBadGateway)
+			return
+		}
+		// The owner is down, coordinate here: reads go to the other replicas and
+		// writes keep a hint for it.
+		log.Printf("Owner %s of %s is unreachable, coordinating here: %v\n", owner, key, err)
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), n.config.Timeout)
+	defer cancel()
+	switch r.Method {
+	case http.MethodGet:
+		item, err := n.read(ctx, key, replicas)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(item.Value)
+	case http.MethodPut:
+		n.serveWrite(w, key, Item{Value: value, Version: n.nextVersion()}, replicas)
+	case http.MethodDelete:
+		n.serveWrite(w, key, Item{Deleted: true, Version: n.nextVersion()}, replicas)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (n *Node) serveWrite(w http.ResponseWriter, key string, item Item, replicas []hash.Member) {
+	hinted, err := n.write(key, item, replicas)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if hinted > 0 {
+		w.Header().Set(hintedHeader, strconv.Itoa(hinted))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, ErrQuorum):
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// forward proxies a misrouted request to the owner of the key. It returns an error,
+// without writing the response, when the owner can't be reached.
+func (n *Node) forward(w http.ResponseWriter, r *http.Request, owner hash.Member, body []byte) error {
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, memberURL(owner, r.URL.EscapedPath()), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(forwardedHeader, n.config.Name)
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+	return nil
+}
+
+// nextVersion returns a version above every version handed out by this node.
+func (n *Node) nextVersion() uint64 {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	v := uint64(time.Now().UnixNano())
+	if v <= n.lastVersion {
+		v = n.lastVersion + 1
+	}
+	n.lastVersion = v
+	return v
+}
+
+type writeResult struct {
+	err    error
+	hinted bool
+}
+
+// write sends the item to all the replicas and returns once WriteQuorum of them
+// accepted it. The remaining replicas are still written in the background. With
+// hinted handoff, a replica that failed counts as an ack once its hint is stored, but
+// at least one replica must take the write itself; write returns how many acks were
+// hints.
+func (n *Node) write(key string, item Item, replicas []hash.Member) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.Timeout)
+	partID := n.ring.FindPartitionID([]byte(key))
+	results := make(chan writeResult, len(replicas))
+	var wg sync.WaitGroup
+	for _, m := range replicas {
+		wg.Add(1)
+		go func(m hash.Member) {
+			defer wg.Done()
+			err := n.putReplica(ctx, m, partID, key, item)
+			if err != nil && n.hint(m, partID, key, item) == nil {
+				results <- writeResult{hinted: true}
+				return
+			}
+			results <- writeResult{err: err}
+		}(m)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+	}()
+
+	acks, failures, hinted := 0, 0, 0
+	var errs []error
+	for range replicas {
+		res := <-results
+		if res.err == nil {
+			acks++
+		} else {
+			failures++
+			errs = append(errs, res.err)
+		}
+		if res.hinted {
+			hinted++
+		}
+		if acks >= n.config.WriteQuorum && acks > hinted {
+			return hinted, nil
+		}
+		if failures > len(replicas)-n.config.WriteQuorum {
+			break
+		}
+	}
+	if acks > 0 && acks == hinted {
+		errs = append(errs, errors.New("no replica accepted the write, only hints were stored"))
+	}
+	return hinted, fmt.Errorf("%w: %d of %d replicas accepted the write: %w", ErrQuorum, acks, n.config.WriteQuorum, errors.Join(errs...))
+}
+
+type readResult struct {
+	member hash.Member
+	item   Item
+	found  bool
+	err    error
+}
+
+// read asks all the replicas for the key and returns the latest item among the
+// first ReadQuorum answers. Replicas that answered with an older item are repaired.
+func (n *Node) read(ctx context.Context, key string, replicas []hash.Member) (Item, error) {
+	partID := n.ring.FindPartitionID([]byte(key))
+	results := make(chan readResult, len(replicas))
+	for _, m := range replicas {
+		go func(m hash.Member) {
+			item, found, err := n.getReplica(ctx, m, partID, key)
+			results <- readResult{member: m, item: item, found: found, err: err}
+		}(m)
+	}
+
+	var answers []readResult
+	var errs []error
+	for range replicas {
+		res := <-results
+		if res.err != nil {
+			errs = append(errs, res.err)
+			if len(errs) > len(replicas)-n.config.ReadQuorum {
+				break
+			}
+			continue
+		}
+		answers = append(answers, res)
+		if len(answers) >= n.config.ReadQuorum {
+			break
+		}
+	}
+	if len(answers) < n.config.ReadQuorum {
+		return Item{}, fmt.Errorf("%w: %d of %d replicas answered the read: %w", ErrQuorum, len(answers), n.config.ReadQuorum, errors.Join(errs...))
+	}
+
+	var latest Item
+	var found bool
+	for _, a := range answers {
+		if a.found && (!found || a.item.newer(latest)) {
+			latest, found = a.item, true
+		}
+	}
+	if !found {
+		return Item{}, ErrNotFound
+	}
+	for _, a := range answers {
+		if !a.found || latest.newer(a.item) {
+			go n.repair(a.member, partID, key, latest)
+		}
+	}
+	if latest.Deleted {
+		return Item{}, ErrNotFound
+	}
+	return latest, nil
+}
+
+func (n *Node) repair(m hash.Member, partID int, key string, item Item) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.Timeout)
+	defer cancel()
+	if err := n.putReplica(ctx, m, partID, key, item); err != nil {
+		log.Printf("Read repair of %s on %s failed: %v\n", key, m, err)
+	}
+}
+
+func (n *Node) putReplica(ctx context.Context, m hash.Member, partID int, key string, item Item) error {
+	if m.Name == n.config.Name {
+		n.store.Put(partID, key, item)
+		return nil
+	}
+	body, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, memberURL(m, replicaPath+url.PathEscape(key)), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("replica %s: %s", m, resp.Status)
+	}
+	return nil
+}
+
+func (n *Node) getReplica(ctx context.Context, m hash.Member, partID int, key string) (Item, bool, error) {
+	if m.Name == n.config.Name {
+		item, found := n.store.Get(partID, key)
+		return item, found, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, memberURL(m, replicaPath+url.PathEscape(key)), nil)
+	if err != nil {
+		return Item{}, false, err
+	}
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return Item{}, false, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var item Item
+		if err := json.NewDecoder(resp.Body).Decode(&item); err != nil {
+			return Item{}, false, err
+		}
+		return item, true, nil
+	case http.StatusNotFound:
+		return Item{}, false, nil
+	default:
+		return Item{}, false, fmt.Errorf("replica %s: %s", m, resp.Status)
+	}
+}
+
+// serveReplica reads or writes the local store only, it is called by the owner of the key.
+func (n *Node) serveReplica(w http.ResponseWriter, r *http.Request, key string) {
+	partID := n.ring.FindPartitionID([]byte(key))
+	switch r.Method {
+	case http.MethodGet:
+		item, found := n.store.Get(partID, key)
+		if !found {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(item)
+	case http.MethodPut:
+		var item Item
+		if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n.store.Put(partID, key, item)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func memberURL(m hash.Member, path string) string {
+	return "http://" + m.Address() + path
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"distributed-lb/hash"
+	"distributed-lb/internal/testcluster"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type cluster = testcluster.Cluster[*Node]
+
+// newCluster runs size nodes on a ring shared by all of them.
+func newCluster(t *testing.T, size int, cfg Config) *cluster {
+	t.Helper()
+	ringConfig := hash.Config{PartitionCount: 71, ReplicationFactor: 20, ReplicaCount: cfg.Replicas}
+	return testcluster.New(t, size, size, ringConfig, func(name string, ring *testcluster.LazyRing) (*Node, error) {
+		cfg := cfg
+		cfg.Name = name
+		return NewNode(cfg, ring)
+	})
+}
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestDataSurvivesMemberRemoval(t *testing.T) {
+	c := newCluster(t, 4, Config{Replicas: 3, WriteQuorum: 2, ReadQuorum: 2, Timeout: time.Second})
+	entry := c.HTTP["node0"].URL
+
+	for i := 0; i < 50; i++ {
+		if code, body := do(t, http.MethodPut, fmt.Sprintf("%s/kv/key-%d", entry, i), fmt.Sprintf("value-%d", i)); code != http.StatusNoContent {
+			t.Fatalf("PUT key-%d: %d %s", i, code, body)
+		}
+	}
+	if code, _ := do(t, http.MethodDelete, entry+"/kv/key-0", ""); code != http.StatusNoContent {
+		t.Fatalf("DELETE key-0: %d", code)
+	}
+
+	c.Kill(t, "node2")
+
+	entry = c.HTTP["node1"].URL
+	if code, _ := do(t, http.MethodGet, entry+"/kv/key-0", ""); code != http.StatusNotFound {
+		t.Fatalf("GET deleted key-0: %d", code)
+	}
+	for i := 1; i < 50; i++ {
+		code, body := do(t, http.MethodGet, fmt.Sprintf("%s/kv/key-%d", entry, i), "")
+		if code != http.StatusOK || body != fmt.Sprintf("value-%d", i) {
+			t.Fatalf("GET key-%d: %d %s", i, code, body)
+		}
+	}
+}
+
+func TestWriteQuorum(t *testing.T) {
+	c := newCluster(t, 3, Config{Replicas: 3, WriteQuorum: 3, ReadQuorum: 1, Timeout: time.Second})
+	// node2 is down but still on the ring, every key needs it for W=3.
+	c.HTTP["node2"].Close()
+	var entry string
+	for name, srv := range c.HTTP {
+		if name != "node2" {
+			entry = srv.URL
+		}
+	}
+	if code, _ := do(t, http.MethodPut, entry+"/kv/key", "value"); code != http.StatusServiceUnavailable && code != http.StatusBadGateway {
+		t.Fatalf("PUT without quorum: %d", code)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if _, err := NewNode(Config{Name: "n", Replicas: 3, WriteQuorum: 4, ReadQuorum: 1}, nil); err == nil {
+		t.Fatal("expected an error for a write quorum above the replica count")
+	}
+}
+
+func TestReadFallsBackWhenTheOwnerIsDown(t *testing.T) {
+	transport := &faultyTransport{down: make(map[string]bool)}
+	c := newCluster(t, 3, Config{Replicas: 3, WriteQuorum: 3, ReadQuorum: 1, Timeout: time.Second, Transport: transport})
+	key, owner := keyOwnedByOther(t, c, "node0")
+	entry := c.HTTP["node0"].URL + "/kv/" + key
+	if code, body := do(t, http.MethodPut, entry, "value"); code != http.StatusNoContent {
+		t.Fatalf("PUT %s: %d %s", key, code, body)
+	}
+
+	transport.setDown(owner.Address(), true)
+	if code, body := do(t, http.MethodGet, entry, ""); code != http.StatusOK || body != "value" {
+		t.Fatalf("GET with the owner down: %d %s", code, body)
+	}
+}
+
+// keyOwnedByOther returns a key whose owner isn't the named node, and the owner.
+func keyOwnedByOther(t *testing.T, c *cluster, name string) (string, hash.Member) {
+	t.Helper()
+	for i := 0; ; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		replicas, err := c.Ring.GetClosestN([]byte(key), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if replicas[0].Name != name {
+			return key, replicas[0]
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"distributed-lb/hash"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// merklePath serves the trees of the partitions, /merkle/<partID>?root=<digest>, and
+// the items of a leaf, /merkle/<partID>/<leaf>. The tree is only sent when the root
+// of the caller differs.
+const merklePath = "/merkle/"
+
+// RepairStats counts the work and the bandwidth of the anti-entropy repair.
+type RepairStats struct {
+	// Comparisons is the number of partition trees compared with a peer.
+	Comparisons int64
+	// LeavesDiffered is the number of leaves that had to be exchanged.
+	LeavesDiffered int64
+	// KeysPulled and KeysPushed count the items that were out of date here or on the peer.
+	KeysPulled int64
+	KeysPushed int64
+	// TreeBytes, LeafBytes and PushBytes are the bytes of the tree digests, the leaf
+	// items received and the items sent.
+	TreeBytes int64
+	LeafBytes int64
+	PushBytes int64
+}
+
+func (s *RepairStats) add(o RepairStats) {
+	s.Comparisons += o.Comparisons
+	s.LeavesDiffered += o.LeavesDiffered
+	s.KeysPulled += o.KeysPulled
+	s.KeysPushed += o.KeysPushed
+	s.TreeBytes += o.TreeBytes
+	s.LeafBytes += o.LeafBytes
+	s.PushBytes += o.PushBytes
+}
+
+// Bytes returns the total repair bandwidth.
+func (s RepairStats) Bytes() int64 {
+	return s.TreeBytes + s.LeafBytes + s.PushBytes
+}
+
+// RepairStats returns the totals of all the repair rounds of the node.
+func (n *Node) RepairStats() RepairStats {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.repairStats
+}
+
+// StartRepair runs RepairOnce every interval until ctx is done.
+func (n *Node) StartRepair(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := n.RepairOnce(ctx); err != nil {
+				log.Println("Repair: ", err)
+			}
+		}
+	}
+}
+
+// RepairOnce compares the tree of every local partition with the other replicas
+// of the partition and exchanges only the items of the leaves that differ. The
+// newer version wins on both sides.
+func (n *Node) RepairOnce(ctx context.Context) (RepairStats, error) {
+	var stats RepairStats
+	var errs []error
+	for _, partID := range n.store.Partitions() {
+		replicas, err := n.ring.GetClosestNForPartition(partID, n.config.Replicas)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, peer := range replicas {
+			if peer.Name == n.config.Name {
+				continue
+			}
+			s, err := n.repairPartition(ctx, peer, partID)
+			stats.add(s)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("partition %d with %s: %w", partID, peer, err))
+			}
+		}
+	}
+	n.mu.Lock()
+	n.repairStats.add(stats)
+	n.mu.Unlock()
+	return stats, errors.Join(errs...)
+}
+
+func (n *Node) repairPartition(ctx context.Context, peer hash.Member, partID int) (RepairStats, error) {
+	stats := RepairStats{Comparisons: 1}
+	items := n.store.Items(partID)
+	local := BuildTree(items, n.config.TreeDepth)
+	treeURL := fmt.Sprintf("%s%s%d?root=%d", memberURL(peer, ""), merklePath, partID, local.Root())
+	body, err := n.call(ctx, http.MethodGet, treeURL, nil)
+	stats.TreeBytes += int64(len(body))
+	if err != nil || len(body) == 0 {
+		// An empty body means the peer has the same root.
+		return stats, err
+	}
+	var remote MerkleTree
+	if err := json.Unmarshal(body, &remote); err != nil {
+		return stats, err
+	}
+	if err := remote.validate(n.config.TreeDepth); err != nil {
+		return stats, err
+	}
+	for _, leaf := range local.Diff(&remote) {
+		stats.LeavesDiffered++
+		leafURL := memberURL(peer, fmt.Sprintf("%s%d/%d", merklePath, partID, leaf))
+		body, err := n.call(ctx, http.MethodGet, leafURL, nil)
+		stats.LeafBytes += int64(len(body))
+		if err != nil {
+			return stats, err
+		}
+		var theirs map[string]Item
+		if err := json.Unmarshal(body, &theirs); err != nil {
+			return stats, err
+		}
+		for key, item := range theirs {
+			if n.store.Put(partID, key, item) {
+				stats.KeysPulled++
+			}
+		}
+		push := make(map[string]Item)
+		for key, item := range leafItems(items, n.config.TreeDepth, leaf) {
+			if their, ok := theirs[key]; !ok || item.newer(their) {
+				push[key] = item
+			}
+		}
+		if len(push) == 0 {
+			continue
+		}
+		body, err = json.Marshal(push)
+		if err != nil {
+			return stats, err
+		}
+		stats.PushBytes += int64(len(body))
+		if _, err := n.call(ctx, http.MethodPut, leafURL, body); err != nil {
+			return stats, err
+		}
+		stats.KeysPushed += int64(len(push))
+	}
+	return stats, nil
+}
+
+// call sends the request to a peer and returns the response body.
+func (n *Node) call(ctx context.Context, method, url string, body []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	res, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return res, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return res, fmt.Errorf("%s %s: %s", method, url, resp.Status)
+	}
+	return res, nil
+}
+
+// serveMerkle returns the tree of a partition, or reads and writes the items of a leaf.
+func (n *Node) serveMerkle(w http.ResponseWriter, r *http.Request, path string) {
+	parts := strings.Split(path, "/")
+	partID, err := strconv.Atoi(parts[0])
+	if err != nil || len(parts) > 2 {
+		http.Error(w, "expected /merkle/<partID>[/<leaf>]", http.StatusBadRequest)
+		return
+	}
+	depth := n.config.TreeDepth
+	if len(parts) == 1 {
+		tree := BuildTree(n.store.Items(partID), depth)
+		if root := r.URL.Query().Get("root"); root == strconv.FormatUint(tree.Root(), 10) {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tree)
+		return
+	}
+	leaf, err := strconv.Atoi(parts[1])
+	if err != nil || leaf < 0 || leaf >= 1<<depth {
+		http.Error(w, "invalid leaf "+parts[1], http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(leafItems(n.store.Items(partID), depth, leaf))
+	case http.MethodPut:
+		var items map[string]Item
+		if err := json.NewDecoder(r.Body).Decode(&items); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for key, item := range items {
+			n.store.Put(partID, key, item)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
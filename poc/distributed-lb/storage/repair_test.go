@@ -0,0 +1,109 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMerkleTreeDiff(t *testing.T) {
+	items := map[string]Item{}
+	for i := 0; i < 100; i++ {
+		items[fmt.Sprintf("key-%d", i)] = Item{Value: []byte("v"), Version: uint64(i + 1)}
+	}
+	a := BuildTree(items, 4)
+	b := BuildTree(items, 4)
+	if a.Root() != b.Root() || len(a.Diff(b)) != 0 {
+		t.Fatal("same items must give the same tree")
+	}
+
+	items["key-7"] = Item{Value: []byte("other"), Version: 1000}
+	c := BuildTree(items, 4)
+	diff := a.Diff(c)
+	if len(diff) != 1 || diff[0] != leafOf("key-7", 4) {
+		t.Fatalf("Diff = %v, want [%d]", diff, leafOf("key-7", 4))
+	}
+	// The value is not part of the digest, only the version is.
+	items["key-7"] = Item{Value: []byte("again"), Version: 1000}
+	if BuildTree(items, 4).Root() != c.Root() {
+		t.Fatal("the value must not change the digest")
+	}
+	if BuildTree(nil, 4).Root() != 0 {
+		t.Fatal("an empty partition must have a zero root")
+	}
+}
+
+func TestRepairCorruptedReplicas(t *testing.T) {
+	c := newCluster(t, 3, Config{Replicas: 3, WriteQuorum: 3, ReadQuorum: 1, Timeout: time.Second})
+	entry := c.HTTP["node0"].URL
+	for i := 0; i < 200; i++ {
+		if code, body := do(t, http.MethodPut, fmt.Sprintf("%s/kv/key-%d", entry, i), fmt.Sprintf("value-%d", i)); code != http.StatusNoContent {
+			t.Fatalf("PUT key-%d: %d %s", i, code, body)
+		}
+	}
+	var full int
+	for _, partID := range c.Servers["node0"].Store().Partitions() {
+		b, _ := json.Marshal(c.Servers["node0"].Store().Items(partID))
+		full += len(b)
+	}
+
+	// node1 lost a few keys, node2 has stale versions of others.
+	lost := c.Servers["node1"].Store()
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		partID := c.Ring.FindPartitionID([]byte(key))
+		lost.mu.Lock()
+		delete(lost.partitions[partID], key)
+		lost.mu.Unlock()
+	}
+	stale := c.Servers["node2"].Store()
+	for i := 10; i < 13; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		partID := c.Ring.FindPartitionID([]byte(key))
+		stale.mu.Lock()
+		stale.partitions[partID][key] = Item{Value: []byte("stale"), Version: 1}
+		stale.mu.Unlock()
+	}
+
+	var total RepairStats
+	for _, name := range []string{"node0", "node1", "node2"} {
+		stats, err := c.Servers[name].RepairOnce(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		total.add(stats)
+	}
+	if total.KeysPulled+total.KeysPushed < 8 {
+		t.Fatalf("repaired %d keys, want at least 8: %+v", total.KeysPulled+total.KeysPushed, total)
+	}
+	if total.Bytes() >= int64(full) {
+		t.Fatalf("repair used %d bytes, a full copy is %d", total.Bytes(), full)
+	}
+	t.Logf("repair: %+v, %d bytes, full copy %d bytes", total, total.Bytes(), full)
+
+	want := c.Servers["node0"].Store()
+	for _, name := range []string{"node1", "node2"} {
+		got := c.Servers[name].Store()
+		if !reflect.DeepEqual(want.Partitions(), got.Partitions()) {
+			t.Fatalf("%s partitions differ", name)
+		}
+		for _, partID := range want.Partitions() {
+			if !reflect.DeepEqual(want.Items(partID), got.Items(partID)) {
+				t.Fatalf("%s partition %d differs after repair", name, partID)
+			}
+		}
+	}
+
+	// Nothing left to exchange.
+	stats, err := c.Servers["node1"].RepairOnce(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.LeavesDiffered != 0 {
+		t.Fatalf("second round exchanged %d leaves", stats.LeavesDiffered)
+	}
+}
@@ -0,0 +1,82 @@
+// Package storage is a key-value store sharded with the partitions of hash.Consistent.
+// Each Node keeps the partitions it is a replica of and coordinates the reads and
+// writes of the keys it owns with read and write quorums.
+package storage
+
+import (
+	"sort"
+	"sync"
+)
+
+// Item is a versioned value. Deletes are kept as tombstones so that an older
+// value can't come back from a stale replica.
+type Item struct {
+	Value   []byte `json:"value,omitempty"`
+	Version uint64 `json:"version"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// newer reports whether i wins over o. The higher version wins.
+func (i Item) newer(o Item) bool {
+	return i.Version > o.Version
+}
+
+// Store holds the items of a node grouped by partition.
+type Store struct {
+	mu         sync.RWMutex
+	partitions map[int]map[string]Item
+}
+
+func NewStore() *Store {
+	return &Store{partitions: make(map[int]map[string]Item)}
+}
+
+// Get returns the item of the key, tombstones included.
+func (s *Store) Get(partID int, key string) (Item, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	item, ok := s.partitions[partID][key]
+	return item, ok
+}
+
+// Put stores the item unless the store already has the same or a newer version.
+// It reports whether the item was stored.
+func (s *Store) Put(partID int, key string, item Item) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items, ok := s.partitions[partID]
+	if !ok {
+		items = make(map[string]Item)
+		s.partitions[partID] = items
+	}
+	if current, ok := items[key]; ok && !item.newer(current) {
+		return false
+	}
+	items[key] = item
+	return true
+}
+
+// Partitions returns the IDs of the partitions that hold at least one item, sorted.
+func (s *Store) Partitions() []int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]int, 0, len(s.partitions))
+	for partID, items := range s.partitions {
+		if len(items) > 0 {
+			res = append(res, partID)
+		}
+	}
+	sort.Ints(res)
+	return res
+}
+
+// Items returns a copy of the items of the partition.
+func (s *Store) Items(partID int) map[string]Item {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]Item, len(s.partitions[partID]))
+	for key, item := range s.partitions[partID] {
+		res[key] = item
+	}
+	return res
+}
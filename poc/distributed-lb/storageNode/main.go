@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"distributed-lb/client"
+	"distributed-lb/hash"
+	"distributed-lb/message"
+	"distributed-lb/storage"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+func main() {
+	name := flag.String("name", "", "member name of this node in the ring")
+	addr := flag.String("addr", "127.0.0.1:9101", "listen address, must match the member address known to the coordinator")
+	coordinator := flag.String("coordinator", "http://127.0.0.1:8081", "coordinator url")
+	ring := flag.String("ring", "", "ring name, the coordinator's default ring if empty")
+	replicas := flag.Int("replicas", 3, "number of replicas of a key")
+	writeQuorum := flag.Int("w", 2, "write quorum")
+	readQuorum := flag.Int("r", 2, "read quorum")
+	repair := flag.Duration("repair", time.Minute, "interval of the anti-entropy repair, 0 disables it")
+	maxHints := flag.Int("max-hints", 10000, "hints kept for unreachable replicas, 0 disables hinted handoff")
+	hintTTL := flag.Duration("hint-ttl", storage.DefaultHintTTL, "how long a hint waits for its replica")
+	hintsFile := flag.String("hints-file", "", "file keeping the hints across restarts, memory only if empty")
+	flag.Parse()
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	url := *coordinator
+	if *ring != "" {
+		url += "/rings/" + *ring
+	}
+	c := client.New(url)
+	go c.Run(cancel)
+
+	node, err := storage.NewNode(storage.Config{
+		Name:        *name,
+		Replicas:    *replicas,
+		WriteQuorum: *writeQuorum,
+		ReadQuorum:  *readQuorum,
+		Timeout:     2 * time.Second,
+		MaxHints:    *maxHints,
+		HintTTL:     *hintTTL,
+		HintFile:    *hintsFile,
+	}, c)
+	if err != nil {
+		fmt.Println("Error creating the storage node:", err)
+		return
+	}
+
+	// Hand the hints off as soon as the coordinator reports a member back, the
+	// periodic replay catches the members that were only briefly unreachable.
+	c.OnChange(func(msg message.Message) {
+		if msg.Command == message.REMOVE {
+			return
+		}
+		for _, m := range msg.Members {
+			go func(m hash.Member) {
+				if err := node.ReplayHints(ctx, m); err != nil {
+					fmt.Println("Replaying hints to", m.Name+":", err)
+				}
+			}(m)
+		}
+	})
+	go node.StartHintReplay(ctx, 30*time.Second)
+
+	if *repair > 0 {
+		go node.StartRepair(ctx, *repair)
+	}
+
+	go func() {
+		fmt.Println("Storage node " + *name + " is running on http://" + *addr)
+		err := http.ListenAndServe(*addr, node)
+		if err != nil {
+			fmt.Println("Error starting the http server:" + err.Error())
+			cancel(err)
+		}
+	}()
+	<-ctx.Done()
+	fmt.Println(context.Cause(ctx))
+}
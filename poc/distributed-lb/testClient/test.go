@@ -14,10 +14,15 @@ var c *client.Client
 
 func main() {
 	port := flag.String("port", "1", "port number")
+	ring := flag.String("ring", "", "ring name, the coordinator's default ring if empty")
 	flag.Parse()
 	ctx, cancel := context.WithCancelCause(context.Background())	
 
-	c = client.New("http://127.0.0.1:8081")
+	url := "http://127.0.0.1:8081"
+	if *ring != "" {
+		url += "/rings/" + *ring
+	}
+	c = client.New(url)
 	go c.Run(cancel)
 	
 	go func() {
@@ -44,5 +49,5 @@ func getCustomer(w http.ResponseWriter, r *http.Request) {
 	}
 	fmt.Printf("Id: %s, Member: %s\n", id, m)
 	w.WriteHeader(http.StatusOK)
-	io.WriteString(w, "Key is in node: "+m.String()+"\n")
+	io.WriteString(w, "Key is in node: "+m.String()+" ("+m.Address()+")\n")
 }
\ No newline at end of file
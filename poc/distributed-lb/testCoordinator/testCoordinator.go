@@ -2,12 +2,15 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"distributed-lb/coordinator"
 	"distributed-lb/hash"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"lifecycle"
 	"log"
 	"net/http"
 
@@ -16,7 +19,17 @@ import (
 )
 
 func main() {
-	k8sdata()
+	lc := lifecycle.New()
+	// The coordinator runs until the endpoints watch ends. SIGINT or SIGTERM stops it
+	// sooner, a second one exits at once.
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go func() { cancel(k8sdata(lc)) }()
+	if err := lc.Run(ctx); err != nil {
+		fmt.Println("Shutdown:", err)
+	}
+	if err := context.Cause(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		log.Fatal(err)
+	}
 	// members := []hash.Member{}
 	// size := 4
 	// for i := 0; i < size; i++ {
@@ -46,7 +59,9 @@ func main() {
 	// }
 }
 
-func k8sdata() {
+// k8sdata serves the endpoints of the service from a coordinator until the watch
+// ends, which is always an error.
+func k8sdata(lc *lifecycle.Manager) error {
 	certPath := "./client-cert.pem"
 	keyPath := "./client-key.pem"
 
@@ -54,19 +69,17 @@ func k8sdata() {
 
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
+		return fmt.Errorf("creating request: %w", err)
 	}
 
 	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
 	if err != nil {
-		fmt.Println("Error loading client certificate and key:", err)
-		return
+		return fmt.Errorf("loading client certificate and key: %w", err)
 	}
 
 	caCert, err := ioutil.ReadFile(certPath)
 	if err != nil {
-		log.Fatalf("Error opening cert file %s, Error: %s", certPath, err)
+		return fmt.Errorf("opening cert file %s: %w", certPath, err)
 	}
 	caCertPool := x509.NewCertPool()
 	caCertPool.AppendCertsFromPEM(caCert)
@@ -84,78 +97,113 @@ func k8sdata() {
 
 	resp, err := client.Do(req)
 	if err != nil {
-		fmt.Println("Error making request:", err)
-		return
+		return fmt.Errorf("making request: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Unexpected status code:", resp.StatusCode)
-		return
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 	var k Response
-	members := []hash.Member{}
 	err = json.NewDecoder(resp.Body).Decode(&k)
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("decoding endpoints: %w", err)
 	}
 
-	for _, v := range k.Object.Subsets {
-		for _, k := range v.Addresses {
-			members = append(members, hash.Member{Name: k.IP})
-		}
+	members := endpointMembers(k)
+	b, err := coordinator.New(members)
+	if err != nil {
+		return fmt.Errorf("starting the coordinator: %w", err)
+	}
+	// Stops accepting clients, then ends the streams of the connected ones.
+	if err := lc.Register(lifecycle.Hook{Name: "coordinator", Priority: lifecycle.StopAccepting, Stop: b.Shutdown}); err != nil {
+		return fmt.Errorf("registering the coordinator: %w", err)
 	}
-	b := coordinator.New(members)
 	decoder := json.NewDecoder(resp.Body)
 
 	for {
 		var data Response
 		if err := decoder.Decode(&data); err == nil {
 			fmt.Printf("Received event: %+v\n", data)
-			m := []hash.Member{}
-			for _, v := range data.Object.Subsets {
-				for _, k := range v.Addresses {
-					m = append(m, hash.Member{Name: k.IP})
-				}
+			m := endpointMembers(data)
+			added, removed, changed := diffMembers(members, m)
+			if err := b.AddMember(added); err != nil {
+				fmt.Println("Error adding members:", err)
 			}
-			added, removed := diffMembers(members, m)
-			b.AddMember(added)
 			for _, j := range removed {
-				b.RemoveMember(j)
+				if err := b.RemoveMember(j); err != nil {
+					fmt.Println("Error removing member:", err)
+				}
+			}
+			for _, j := range changed {
+				if err := b.UpdateMember(j); err != nil {
+					fmt.Println("Error updating member:", err)
+				}
 			}
 			members = m
 		} else {
-			break
+			return fmt.Errorf("watching endpoints: %w", err)
 		}
 	}
+}
 
+// endpointMembers turns the ready addresses of an Endpoints object into members. Pods
+// are named after their target so that an IP change keeps the member on the same spot.
+func endpointMembers(r Response) []hash.Member {
+	members := []hash.Member{}
+	for _, v := range r.Object.Subsets {
+		var port int
+		var protocol string
+		if len(v.Ports) > 0 {
+			port = v.Ports[0].Port
+			protocol = v.Ports[0].Protocol
+		}
+		for _, k := range v.Addresses {
+			name := k.TargetRef.Name
+			if name == "" {
+				name = k.IP
+			}
+			members = append(members, hash.Member{
+				Name:     name,
+				Host:     k.IP,
+				Port:     port,
+				Protocol: protocol,
+				Labels:   map[string]string{"nodeName": k.NodeName},
+			})
+		}
+	}
+	return members
 }
 
-func diffMembers(original []hash.Member, updated []hash.Member) ([]hash.Member, []hash.Member) {
+func diffMembers(original []hash.Member, updated []hash.Member) ([]hash.Member, []hash.Member, []hash.Member) {
 	added := make([]hash.Member, 0)
 	removed := make([]hash.Member, 0)
+	changed := make([]hash.Member, 0)
 
 	// Create a map to efficiently check for existence
-	originalMap := make(map[string]bool)
+	originalMap := make(map[string]hash.Member)
 	for _, member := range original {
-		originalMap[member.Name] = true
+		originalMap[member.Name] = member
 	}
 
-	// Check for added and removed members
+	// Check for added, changed and removed members
 	for _, member := range updated {
-		if _, exists := originalMap[member.Name]; exists {
+		if old, exists := originalMap[member.Name]; exists {
 			delete(originalMap, member.Name)
+			if !old.Equal(member) {
+				changed = append(changed, member)
+			}
 		} else {
 			added = append(added, member)
 		}
 	}
 
 	// Remaining members in originalMap are removed
-	for name := range originalMap {
-		removed = append(removed, hash.Member{Name: name})
+	for _, member := range originalMap {
+		removed = append(removed, member)
 	}
 
-	return added, removed
+	return added, removed, changed
 }
 
 type Response struct {
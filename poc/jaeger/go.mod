@@ -4,6 +4,7 @@ go 1.23.2
 
 require (
 	github.com/go-chi/chi/v5 v5.1.0
+	github.com/nibrasmuhamed/go-modules v0.0.0-00010101000000-000000000000
 	github.com/sirupsen/logrus v1.9.3
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.55.0
 	go.opentelemetry.io/otel v1.30.0
@@ -18,5 +19,9 @@ require (
 	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	go.opentelemetry.io/otel/metric v1.30.0 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
+	go.uber.org/zap v1.27.0 // indirect
 	golang.org/x/sys v0.25.0 // indirect
 )
+
+replace github.com/nibrasmuhamed/go-modules => ../logger
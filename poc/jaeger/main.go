@@ -2,12 +2,12 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
 	"os"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/nibrasmuhamed/go-modules/logger"
 	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/exporters/jaeger"
@@ -18,7 +18,11 @@ import (
 	"go.opentelemetry.io/otel/trace"
 )
 
-var tracer trace.Tracer
+var (
+	tracer trace.Tracer
+	// lg logs with the trace and request ids of the context and as span events.
+	lg = logger.Init().Named("my-go-app").WithSpanEvents()
+)
 
 func initTracer() (*sdktrace.TracerProvider, error) {
 	// Set up the Jaeger exporter
@@ -64,6 +68,7 @@ func main() {
 
 	// Middleware to automatically create spans for HTTP requests
 	r.Use(otelhttp.NewMiddleware("my-server"))
+	r.Use(logger.RequestID)
 
 	// Define a handler with tracing
 	r.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -72,8 +77,7 @@ func main() {
 		defer span.End()
 
 		// Simulate some processing and add attributes to the span
-		logger := log.New(os.Stdout, "", log.LstdFlags)
-		logger.Println("Received request at /")
+		lg.InfoContext(ctx, "Received request at /")
 
 		span.SetAttributes(semconv.HTTPMethodKey.String("GET"))
 		span.SetAttributes(semconv.HTTPRouteKey.String("/"))
@@ -92,9 +96,9 @@ func main() {
 
 // Simulating a function that would trigger a new span
 func databaseCall(ctx context.Context) {
-	_, span := tracer.Start(ctx, "Database Call")
+	ctx, span := tracer.Start(ctx, "Database Call")
 	defer span.End()
 
 	// Simulate work
-	fmt.Println("Simulating a database call...")
+	lg.InfoContext(ctx, "Simulating a database call...")
 }
@@ -0,0 +1,243 @@
+// Package lifecycle starts the components of a service and stops them in order on
+// SIGINT or SIGTERM: first the listeners stop accepting, then open streams are
+// drained, logs flushed and stores closed. A second signal exits at once.
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Priorities of the usual shutdown steps, lower priorities stop first.
+const (
+	StopAccepting = 0
+	Drain         = 10
+	Flush         = 20
+	CloseStores   = 30
+)
+
+// DefaultTimeout bounds a hook without a timeout.
+const DefaultTimeout = 10 * time.Second
+
+// ErrTimeout is reported for hooks that didn't return within their timeout.
+var ErrTimeout = errors.New("hook timed out")
+
+// ErrInvalidHook is returned by Register for a hook without a name or with the name
+// of a registered one, the hooks are told apart by name.
+var ErrInvalidHook = errors.New("invalid hook")
+
+// Hook is a component of the service.
+type Hook struct {
+	Name string
+	// Priority orders the shutdown, lower priorities stop first and hooks of the same
+	// priority stop concurrently. Hooks start in the reverse order.
+	Priority int
+	// Timeout bounds Start and Stop, DefaultTimeout if 0.
+	Timeout time.Duration
+	Start   func(ctx context.Context) error
+	Stop    func(ctx context.Context) error
+}
+
+// Report lists the hooks that failed to stop.
+type Report struct {
+	// Errors holds the error of every hook that failed, ErrTimeout if it timed out.
+	Errors map[string]error
+}
+
+// TimedOut returns the names of the hooks that timed out, sorted.
+func (r Report) TimedOut() []string {
+	var names []string
+	for name, err := range r.Errors {
+		if errors.Is(err, ErrTimeout) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Err joins the errors of the hooks, nil if every hook stopped.
+func (r Report) Err() error {
+	names := make([]string, 0, len(r.Errors))
+	for name := range r.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, fmt.Errorf("%s: %w", name, r.Errors[name]))
+	}
+	return errors.Join(errs...)
+}
+
+// Manager is safe for concurrent use, hooks can be registered while it runs.
+type Manager struct {
+	signals []os.Signal
+	exit    func(code int)
+
+	mu      sync.Mutex
+	hooks   []Hook
+	started map[string]bool
+	stopped bool
+}
+
+func New() *Manager {
+	return &Manager{
+		signals: []os.Signal{syscall.SIGINT, syscall.SIGTERM},
+		exit:    os.Exit,
+		started: make(map[string]bool),
+	}
+}
+
+// Register adds a hook. A hook registered after Start is stopped but not started,
+// its component is expected to be running already.
+func (m *Manager) Register(h Hook) error {
+	if h.Name == "" {
+		return fmt.Errorf("%w: the name is required", ErrInvalidHook)
+	}
+	if h.Timeout <= 0 {
+		h.Timeout = DefaultTimeout
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, registered := range m.hooks {
+		if registered.Name == h.Name {
+			return fmt.Errorf("%w: %s is already registered", ErrInvalidHook, h.Name)
+		}
+	}
+	m.hooks = append(m.hooks, h)
+	return nil
+}
+
+// groups returns the hooks grouped by priority, in stop order.
+func (m *Manager) groups() [][]Hook {
+	m.mu.Lock()
+	hooks := append([]Hook(nil), m.hooks...)
+	m.mu.Unlock()
+	sort.SliceStable(hooks, func(i, j int) bool { return hooks[i].Priority < hooks[j].Priority })
+	var groups [][]Hook
+	for i, h := range hooks {
+		if i == 0 || h.Priority != hooks[i-1].Priority {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], h)
+	}
+	return groups
+}
+
+// call runs fn with the timeout of the hook. A hook that times out keeps running in
+// the background, the manager moves on without it.
+func call(ctx context.Context, h Hook, fn func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, h.Timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- fn(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("%w after %v", ErrTimeout, h.Timeout)
+		}
+		return ctx.Err()
+	}
+}
+
+// Start starts the hooks, the last to stop first. If one fails the hooks already
+// started are stopped and the error is returned.
+func (m *Manager) Start(ctx context.Context) error {
+	groups := m.groups()
+	for i := len(groups) - 1; i >= 0; i-- {
+		for _, h := range groups[i] {
+			if h.Start != nil {
+				if err := call(ctx, h, h.Start); err != nil {
+					m.Stop(context.Background())
+					return fmt.Errorf("starting %s: %w", h.Name, err)
+				}
+			}
+			m.mu.Lock()
+			m.started[h.Name] = true
+			m.mu.Unlock()
+		}
+	}
+	return nil
+}
+
+// Stop stops the hooks by priority, once. Hooks registered with a Start are only
+// stopped if they were started.
+func (m *Manager) Stop(ctx context.Context) Report {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return Report{}
+	}
+	m.stopped = true
+	m.mu.Unlock()
+
+	report := Report{Errors: make(map[string]error)}
+	var mu sync.Mutex
+	for _, group := range m.groups() {
+		var wg sync.WaitGroup
+		for _, h := range group {
+			m.mu.Lock()
+			skip := h.Stop == nil || h.Start != nil && !m.started[h.Name]
+			m.mu.Unlock()
+			if skip {
+				continue
+			}
+			wg.Add(1)
+			go func(h Hook) {
+				defer wg.Done()
+				if err := call(ctx, h, h.Stop); err != nil {
+					mu.Lock()
+					report.Errors[h.Name] = err
+					mu.Unlock()
+				}
+			}(h)
+		}
+		wg.Wait()
+	}
+	return report
+}
+
+// Run starts the hooks and stops them when SIGINT or SIGTERM arrives or the context
+// is done. A second signal during the shutdown exits the process with status 1.
+func (m *Manager) Run(ctx context.Context) error {
+	sigCh := make(chan os.Signal, 2)
+	signal.Notify(sigCh, m.signals...)
+	defer signal.Stop(sigCh)
+
+	if err := m.Start(ctx); err != nil {
+		return err
+	}
+	select {
+	case sig := <-sigCh:
+		fmt.Printf("Received signal: %v. Shutting down...\n", sig)
+	case <-ctx.Done():
+		fmt.Println("Shutting down:", context.Cause(ctx))
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigCh:
+			fmt.Printf("Received signal: %v again. Exiting now\n", sig)
+			m.exit(1)
+		case <-stopped:
+		}
+	}()
+	report := m.Stop(context.Background())
+	close(stopped)
+	if timedOut := report.TimedOut(); len(timedOut) > 0 {
+		fmt.Println("Hooks timed out:", strings.Join(timedOut, ", "))
+	}
+	return report.Err()
+}
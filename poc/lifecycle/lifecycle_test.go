@@ -0,0 +1,157 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type recorder struct {
+	mu    sync.Mutex
+	calls []string
+}
+
+func (r *recorder) hook(name string, priority int) Hook {
+	return Hook{
+		Name:     name,
+		Priority: priority,
+		Start:    func(context.Context) error { r.record("start " + name); return nil },
+		Stop:     func(context.Context) error { r.record("stop " + name); return nil },
+	}
+}
+
+func (r *recorder) record(call string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, call)
+}
+
+func TestOrder(t *testing.T) {
+	var r recorder
+	m := New()
+	m.Register(r.hook("store", CloseStores))
+	m.Register(r.hook("http", StopAccepting))
+	m.Register(r.hook("sse", Drain))
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Stop(context.Background()).Err(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"start store", "start sse", "start http", "stop http", "stop sse", "stop store"}
+	if !reflect.DeepEqual(r.calls, want) {
+		t.Fatalf("calls = %v, want %v", r.calls, want)
+	}
+}
+
+func TestRegisterRejectsUnnamedAndDuplicateHooks(t *testing.T) {
+	var r recorder
+	m := New()
+	if err := m.Register(r.hook("store", CloseStores)); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Register(r.hook("store", Flush)); !errors.Is(err, ErrInvalidHook) {
+		t.Fatalf("Register of a second store = %v, want ErrInvalidHook", err)
+	}
+	if err := m.Register(Hook{Stop: func(context.Context) error { return nil }}); !errors.Is(err, ErrInvalidHook) {
+		t.Fatalf("Register without a name = %v, want ErrInvalidHook", err)
+	}
+	if err := m.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	m.Stop(context.Background())
+	if want := []string{"start store", "stop store"}; !reflect.DeepEqual(r.calls, want) {
+		t.Fatalf("calls = %v, want %v", r.calls, want)
+	}
+}
+
+func TestStopReportsTimeouts(t *testing.T) {
+	var r recorder
+	m := New()
+	m.Register(Hook{
+		Name:    "stuck",
+		Timeout: 20 * time.Millisecond,
+		Stop: func(ctx context.Context) error {
+			time.Sleep(time.Second)
+			return nil
+		},
+	})
+	m.Register(Hook{Name: "broken", Stop: func(context.Context) error { return errors.New("boom") }})
+	m.Register(r.hook("store", CloseStores))
+	m.Start(context.Background())
+
+	start := time.Now()
+	report := m.Stop(context.Background())
+	if time.Since(start) > 500*time.Millisecond {
+		t.Fatalf("Stop waited %v for the stuck hook", time.Since(start))
+	}
+	if got := report.TimedOut(); !reflect.DeepEqual(got, []string{"stuck"}) {
+		t.Fatalf("TimedOut = %v", got)
+	}
+	if err := report.Errors["broken"]; err == nil || err.Error() != "boom" {
+		t.Fatalf("broken error = %v", err)
+	}
+	// The later hooks still stop.
+	if !reflect.DeepEqual(r.calls, []string{"start store", "stop store"}) {
+		t.Fatalf("calls = %v", r.calls)
+	}
+}
+
+func TestStartFailureStopsStartedHooks(t *testing.T) {
+	var r recorder
+	m := New()
+	m.Register(r.hook("store", CloseStores))
+	m.Register(Hook{Name: "sse", Priority: Drain, Start: func(context.Context) error { return errors.New("boom") }})
+	m.Register(r.hook("http", StopAccepting))
+	if err := m.Start(context.Background()); err == nil {
+		t.Fatal("Start succeeded")
+	}
+	if !reflect.DeepEqual(r.calls, []string{"start store", "stop store"}) {
+		t.Fatalf("calls = %v", r.calls)
+	}
+}
+
+func TestRunStopsOnSignalAndExitsOnSecond(t *testing.T) {
+	var r recorder
+	m := New()
+	exited := make(chan int, 1)
+	m.exit = func(code int) { exited <- code }
+	stopping := make(chan struct{})
+	m.Register(r.hook("http", StopAccepting))
+	m.Register(Hook{
+		Name:     "slow",
+		Priority: Drain,
+		Timeout:  200 * time.Millisecond,
+		Stop: func(ctx context.Context) error {
+			close(stopping)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- m.Run(context.Background()) }()
+	// Wait for Run to listen for the signals.
+	time.Sleep(50 * time.Millisecond)
+	syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	<-stopping
+	syscall.Kill(syscall.Getpid(), syscall.SIGINT)
+	select {
+	case code := <-exited:
+		if code != 1 {
+			t.Fatalf("exit code %d", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the second signal didn't exit")
+	}
+	if err := <-done; !errors.Is(err, ErrTimeout) {
+		t.Fatalf("Run = %v, want the timeout of slow", err)
+	}
+	if !reflect.DeepEqual(r.calls, []string{"start http", "stop http"}) {
+		t.Fatalf("calls = %v", r.calls)
+	}
+}
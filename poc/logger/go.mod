@@ -2,6 +2,18 @@ module github.com/nibrasmuhamed/go-modules
 
 go 1.23.2
 
-require go.uber.org/zap v1.27.0
+require (
+	go.opentelemetry.io/otel v1.30.0
+	go.opentelemetry.io/otel/sdk v1.30.0
+	go.opentelemetry.io/otel/trace v1.30.0
+	go.uber.org/zap v1.27.0
+)
 
-require go.uber.org/multierr v1.10.0 // indirect
+require (
+	github.com/go-logr/logr v1.4.2 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	go.opentelemetry.io/otel/metric v1.30.0 // indirect
+	go.uber.org/multierr v1.10.0 // indirect
+	golang.org/x/sys v0.25.0 // indirect
+)
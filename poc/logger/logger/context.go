@@ -0,0 +1,122 @@
+package logger
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// RequestIDHeader is read and echoed by the RequestID middleware.
+const RequestIDHeader = "X-Request-ID"
+
+type requestIDKey struct{}
+
+// ContextWithRequestID returns a context carrying the request id.
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request id of the context, "" if none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// RequestID is a middleware that puts the X-Request-ID of the request, or a new id,
+// in the request context for the context logging methods and in the response.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if id == "" {
+			b := make([]byte, 8)
+			rand.Read(b)
+			id = hex.EncodeToString(b)
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(ContextWithRequestID(r.Context(), id)))
+	})
+}
+
+// WithSpanEvents returns a child logger whose context methods also record every
+// line they log as an event of the span in the context.
+func (l *Logger) WithSpanEvents() *Logger {
+	child := *l
+	child.spanEvents = true
+	return &child
+}
+
+// InfoContext logs a message at InfoLevel with the trace and request ids of the context
+func (l *Logger) InfoContext(ctx context.Context, msg string, fields ...zap.Field) {
+	l.logContext(ctx, zapcore.InfoLevel, msg, fields)
+}
+
+// WarnContext logs a message at WarnLevel with the trace and request ids of the context
+func (l *Logger) WarnContext(ctx context.Context, msg string, fields ...zap.Field) {
+	l.logContext(ctx, zapcore.WarnLevel, msg, fields)
+}
+
+// DebugContext logs a message at DebugLevel with the trace and request ids of the context
+func (l *Logger) DebugContext(ctx context.Context, msg string, fields ...zap.Field) {
+	l.logContext(ctx, zapcore.DebugLevel, msg, fields)
+}
+
+// ErrorContext logs a message at ErrorLevel with the trace and request ids of the context
+func (l *Logger) ErrorContext(ctx context.Context, msg string, fields ...zap.Field) {
+	l.logContext(ctx, zapcore.ErrorLevel, msg, fields)
+}
+
+func (l *Logger) logContext(ctx context.Context, level zapcore.Level, msg string, fields []zap.Field) {
+	checked := l.zapLogger.Check(level, msg)
+	if checked == nil {
+		return
+	}
+	// The ids go on a copy, fields may be a slice of the caller with spare capacity.
+	fields = fields[:len(fields):len(fields)]
+	span := trace.SpanFromContext(ctx)
+	if sc := span.SpanContext(); sc.IsValid() {
+		fields = append(fields, zap.String("trace_id", sc.TraceID().String()), zap.String("span_id", sc.SpanID().String()))
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		fields = append(fields, zap.String("request_id", id))
+	}
+	checked.Write(fields...)
+
+	if l.spanEvents && span.IsRecording() {
+		span.AddEvent(msg, trace.WithAttributes(eventAttributes(level, fields)...))
+	}
+}
+
+// eventAttributes turns the fields of a log line into span event attributes, the
+// values of the fields that aren't strings, numbers or booleans are formatted.
+func eventAttributes(level zapcore.Level, fields []zap.Field) []attribute.KeyValue {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	attrs := []attribute.KeyValue{attribute.String("log.severity", level.CapitalString())}
+	for key, value := range enc.Fields {
+		if key == "trace_id" || key == "span_id" {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case float64:
+			attrs = append(attrs, attribute.Float64(key, v))
+		default:
+			attrs = append(attrs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,134 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type contextEntry struct {
+	Msg       string `json:"msg"`
+	TraceID   string `json:"trace_id"`
+	SpanID    string `json:"span_id"`
+	RequestID string `json:"request_id"`
+}
+
+func newRecordingTracer() (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	return recorder, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+}
+
+func TestContextLoggingAddsTraceAndRequestIDs(t *testing.T) {
+	l, buffer := newBufferLogger(zapcore.InfoLevel)
+	_, provider := newRecordingTracer()
+
+	ctx, span := provider.Tracer("test").Start(ContextWithRequestID(context.Background(), "req-1"), "op")
+	l.InfoContext(ctx, "in span")
+	span.End()
+	l.InfoContext(context.Background(), "no span")
+
+	dec := json.NewDecoder(buffer)
+	var inSpan, noSpan contextEntry
+	if err := dec.Decode(&inSpan); err != nil {
+		t.Fatal(err)
+	}
+	if err := dec.Decode(&noSpan); err != nil {
+		t.Fatal(err)
+	}
+	sc := span.SpanContext()
+	if inSpan.TraceID != sc.TraceID().String() || inSpan.SpanID != sc.SpanID().String() || inSpan.RequestID != "req-1" {
+		t.Fatalf("in span entry = %+v, want trace %s span %s", inSpan, sc.TraceID(), sc.SpanID())
+	}
+	if noSpan != (contextEntry{Msg: "no span"}) {
+		t.Fatalf("no span entry = %+v", noSpan)
+	}
+}
+
+func TestContextLoggingKeepsTheFieldsOfTheCaller(t *testing.T) {
+	l, buffer := newBufferLogger(zapcore.InfoLevel)
+	_, provider := newRecordingTracer()
+	ctx, span := provider.Tracer("test").Start(ContextWithRequestID(context.Background(), "req-1"), "op")
+	defer span.End()
+
+	fields := make([]zap.Field, 1, 4)
+	fields[0] = zap.String("peer", "node1")
+	l.InfoContext(ctx, "first", fields...)
+	// A field appended to the same backing array must not be overwritten by the ids.
+	l.InfoContext(ctx, "second", append(fields, zap.String("extra", "x"))...)
+	if spare := fields[:4]; spare[1].Key != "extra" || spare[2].Key != "" || spare[3].Key != "" {
+		t.Fatalf("the spare capacity of the fields was written: %v", spare)
+	}
+
+	dec := json.NewDecoder(buffer)
+	for _, want := range []string{"first", "second"} {
+		var e contextEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		if e.Msg != want || e.RequestID != "req-1" {
+			t.Fatalf("entry = %+v, want %s", e, want)
+		}
+	}
+}
+
+func TestContextLoggingRecordsSpanEvents(t *testing.T) {
+	l, buffer := newBufferLogger(zapcore.InfoLevel)
+	recorder, provider := newRecordingTracer()
+
+	ctx, span := provider.Tracer("test").Start(context.Background(), "op")
+	l.InfoContext(ctx, "not recorded")
+	events := l.WithSpanEvents()
+	events.WarnContext(ctx, "slow query", zap.Int("rows", 3), zap.String("table", "users"))
+	events.DebugContext(ctx, "disabled")
+	span.End()
+
+	ended := recorder.Ended()
+	if len(ended) != 1 {
+		t.Fatalf("%d spans ended, want 1", len(ended))
+	}
+	got := ended[0].Events()
+	if len(got) != 1 || got[0].Name != "slow query" {
+		t.Fatalf("events = %+v, want only slow query", got)
+	}
+	attrs := map[string]string{}
+	for _, kv := range got[0].Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	if attrs["log.severity"] != "WARN" || attrs["rows"] != "3" || attrs["table"] != "users" {
+		t.Fatalf("event attributes = %v", attrs)
+	}
+	if _, ok := attrs["trace_id"]; ok {
+		t.Fatal("trace_id copied to the span event")
+	}
+	if buffer.Len() == 0 {
+		t.Fatal("nothing logged")
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	var got string
+	handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = RequestIDFromContext(r.Context())
+	}))
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RequestIDHeader, "from-client")
+	handler.ServeHTTP(rec, req)
+	if got != "from-client" || rec.Header().Get(RequestIDHeader) != "from-client" {
+		t.Fatalf("request id = %q, header = %q", got, rec.Header().Get(RequestIDHeader))
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got == "" || got == "from-client" || rec.Header().Get(RequestIDHeader) != got {
+		t.Fatalf("generated request id = %q, header = %q", got, rec.Header().Get(RequestIDHeader))
+	}
+}
@@ -0,0 +1,369 @@
+package logger
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// backupTimeFormat is the time in the names of rotated files, app.log becomes
+// app-2024-01-02T15-04-05.000.log, or app-2024-01-02T15-04-05.000-1.log for the
+// second rotation of the same millisecond.
+const backupTimeFormat = "2006-01-02T15-04-05.000"
+
+// FileConfig configures a File, the zero values disable rotation and retention.
+type FileConfig struct {
+	Path string
+	// MaxSize and MaxAge rotate the file once it holds MaxSize bytes or was started
+	// MaxAge ago. An existing file started at its modification time.
+	MaxSize int64
+	MaxAge  time.Duration
+	// MaxFiles and MaxDays remove the rotated files beyond the MaxFiles newest or
+	// older than MaxDays days.
+	MaxFiles int
+	MaxDays  int
+	// Compress gzips the rotated files.
+	Compress bool
+}
+
+// parseFileConfig reads LOG_ROTATE, e.g. "size=100MB,age=24h,files=10,days=7,compress=true".
+func parseFileConfig(path, s string) (FileConfig, error) {
+	cfg := FileConfig{Path: path}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "size":
+			cfg.MaxSize, err = parseSize(value)
+		case "age":
+			cfg.MaxAge, err = time.ParseDuration(value)
+		case "files":
+			cfg.MaxFiles, err = strconv.Atoi(value)
+		case "days":
+			cfg.MaxDays, err = strconv.Atoi(value)
+		case "compress":
+			cfg.Compress, err = strconv.ParseBool(value)
+		default:
+			return FileConfig{}, fmt.Errorf("unknown rotate option %q", key)
+		}
+		if err != nil {
+			return FileConfig{}, fmt.Errorf("rotate option %s: %w", key, err)
+		}
+	}
+	return cfg, nil
+}
+
+// parseSize parses a number of bytes with an optional KB, MB or GB suffix.
+func parseSize(s string) (int64, error) {
+	multiplier := int64(1)
+	upper := strings.ToUpper(s)
+	for suffix, m := range map[string]int64{"KB": 1 << 10, "MB": 1 << 20, "GB": 1 << 30} {
+		if strings.HasSuffix(upper, suffix) {
+			upper, multiplier = strings.TrimSuffix(upper, suffix), m
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * multiplier, nil
+}
+
+// File is a log file that rotates itself. It is a zapcore.WriteSyncer, writes are
+// serialized with the rotations, and the rotated files are compressed and removed
+// in the background.
+type File struct {
+	cfg FileConfig
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+
+	// mill compresses and removes the rotated files, one run at a time.
+	mill sync.Mutex
+	wg   sync.WaitGroup
+
+	now    func() time.Time
+	rename func(oldpath, newpath string) error
+}
+
+// OpenFile opens or creates the file of the config, appending to it.
+func OpenFile(cfg FileConfig) (*File, error) {
+	f := &File{cfg: cfg, now: time.Now, rename: os.Rename}
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0o755); err != nil {
+		return nil, err
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// open opens the path as the current file. The previous file, if any, is left to
+// the caller and stays current when open fails.
+func (f *File) open() error {
+	file, err := os.OpenFile(f.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size, f.opened = file, info.Size(), f.now()
+	// The file of a previous run or reopened after a rotation keeps its age, creation
+	// times aren't portable and its last write is the closest.
+	if info.Size() > 0 && info.ModTime().Before(f.opened) {
+		f.opened = info.ModTime()
+	}
+	return nil
+}
+
+// Write writes to the file, rotating it first when it is full or too old. When the
+// rotation fails the entry goes to the current file and the next Write tries again.
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	full := f.cfg.MaxSize > 0 && f.size > 0 && f.size+int64(len(p)) > f.cfg.MaxSize
+	old := f.cfg.MaxAge > 0 && f.now().Sub(f.opened) >= f.cfg.MaxAge
+	if full || old {
+		if err := f.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, "logger: rotating %s: %v\n", f.cfg.Path, err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Sync commits the file to disk.
+func (f *File) Sync() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.file.Sync()
+}
+
+// Rotate renames the file with the current time and opens a new one.
+func (f *File) Rotate() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	return f.rotate()
+}
+
+// rotate renames the file while it is still open and only closes it once the new
+// one is open, so a failed step leaves a file to write to.
+func (f *File) rotate() error {
+	if err := f.rename(f.cfg.Path, f.backupName(f.now())); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	previous := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		f.millRun()
+	}()
+	return previous.Close()
+}
+
+// Reopen opens the path again and then closes the previous file, for a file moved
+// away by an external logrotate. The previous file stays current when it fails.
+func (f *File) Reopen() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	previous := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	return previous.Close()
+}
+
+// ReopenOnSIGHUP calls Reopen on every SIGHUP until the context is done.
+func (f *File) ReopenOnSIGHUP(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				if err := f.Reopen(); err != nil {
+					fmt.Fprintf(os.Stderr, "logger: reopening %s: %v\n", f.cfg.Path, err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close closes the file once the rotated files are compressed and removed.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.wg.Wait()
+	if f.file == nil {
+		return os.ErrClosed
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// backupName returns a name no backup has for a rotation at t, a counter follows the
+// time when rotations share a millisecond.
+func (f *File) backupName(t time.Time) string {
+	dir, base := filepath.Split(f.cfg.Path)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext) + "-" + t.UTC().Format(backupTimeFormat)
+	for seq := 0; ; seq++ {
+		name := stem
+		if seq > 0 {
+			name += "-" + strconv.Itoa(seq)
+		}
+		path := filepath.Join(dir, name+ext)
+		if !exists(path) && !exists(path+".gz") {
+			return path
+		}
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+type backup struct {
+	path string
+	time time.Time
+	seq  int
+}
+
+// backups returns the rotated files, the newest first.
+func (f *File) backups() ([]backup, error) {
+	dir, base := filepath.Split(f.cfg.Path)
+	if dir == "" {
+		dir = "."
+	}
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var res []backup
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".gz")
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext)
+		if len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		t, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)])
+		if err != nil {
+			continue
+		}
+		var seq int
+		if rest := stamp[len(backupTimeFormat):]; rest != "" {
+			if seq, err = strconv.Atoi(strings.TrimPrefix(rest, "-")); err != nil || rest[0] != '-' {
+				continue
+			}
+		}
+		res = append(res, backup{path: filepath.Join(dir, e.Name()), time: t, seq: seq})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].time.Equal(res[j].time) {
+			return res[i].time.After(res[j].time)
+		}
+		return res[i].seq > res[j].seq
+	})
+	return res, nil
+}
+
+// millRun removes the rotated files beyond the retention and compresses the others.
+func (f *File) millRun() {
+	f.mill.Lock()
+	defer f.mill.Unlock()
+	backups, err := f.backups()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logger: listing rotated files: %v\n", err)
+		return
+	}
+	cutoff := f.now().Add(-time.Duration(f.cfg.MaxDays) * 24 * time.Hour)
+	for i, b := range backups {
+		if (f.cfg.MaxFiles > 0 && i >= f.cfg.MaxFiles) || (f.cfg.MaxDays > 0 && b.time.Before(cutoff)) {
+			if err := os.Remove(b.path); err != nil && !os.IsNotExist(err) {
+				fmt.Fprintf(os.Stderr, "logger: removing %s: %v\n", b.path, err)
+			}
+			continue
+		}
+		if f.cfg.Compress && !strings.HasSuffix(b.path, ".gz") {
+			if err := compress(b.path); err != nil {
+				fmt.Fprintf(os.Stderr, "logger: compressing %s: %v\n", b.path, err)
+			}
+		}
+	}
+}
+
+// compress gzips the file to path.gz and removes it.
+func compress(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	tmp := path + ".gz.tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+func openTestFile(t *testing.T, cfg FileConfig) (*File, *fakeClock) {
+	t.Helper()
+	clock := &fakeClock{t: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)}
+	cfg.Path = filepath.Join(t.TempDir(), "app.log")
+	f, err := OpenFile(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.now = clock.now
+	f.opened = clock.now()
+	return f, clock
+}
+
+func writeString(t *testing.T, f *File, s string) {
+	t.Helper()
+	if _, err := f.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var r io.Reader = file
+	if strings.HasSuffix(path, ".gz") {
+		if r, err = gzip.NewReader(file); err != nil {
+			t.Fatal(err)
+		}
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// dirFiles returns the names in the directory of the log, oldest backup first.
+func dirFiles(t *testing.T, f *File) []string {
+	t.Helper()
+	entries, err := os.ReadDir(filepath.Dir(f.cfg.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseFileConfig(t *testing.T) {
+	cfg, err := parseFileConfig("/var/log/app.log", "size=10MB, age=24h,files=5,days=7,compress=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FileConfig{Path: "/var/log/app.log", MaxSize: 10 << 20, MaxAge: 24 * time.Hour, MaxFiles: 5, MaxDays: 7, Compress: true}
+	if cfg != want {
+		t.Fatalf("parseFileConfig = %+v, want %+v", cfg, want)
+	}
+	if cfg, _ := parseFileConfig("app.log", "size=512"); cfg.MaxSize != 512 {
+		t.Fatalf("size = %d, want 512", cfg.MaxSize)
+	}
+	for _, bad := range []string{"size=big", "age=1", "compress=maybe", "keep=3"} {
+		if _, err := parseFileConfig("app.log", bad); err == nil {
+			t.Fatalf("parseFileConfig(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestFileRotatesBySizeAndKeepsFiles(t *testing.T) {
+	f, clock := openTestFile(t, FileConfig{MaxSize: 10, MaxFiles: 2, Compress: true})
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n", "line 4\n"} {
+		writeString(t, f, line)
+		clock.advance(time.Second)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"app-2024-05-01T12-00-02.000.log.gz", "app-2024-05-01T12-00-03.000.log.gz", "app.log"}
+	got := dirFiles(t, f)
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	dir := filepath.Dir(f.cfg.Path)
+	for i, content := range []string{"line 2\n", "line 3\n", "line 4\n"} {
+		if got := readFile(t, filepath.Join(dir, want[i])); got != content {
+			t.Fatalf("%s holds %q, want %q", want[i], got, content)
+		}
+	}
+}
+
+func TestFileRotatesByAgeAndRemovesOldDays(t *testing.T) {
+	f, clock := openTestFile(t, FileConfig{MaxAge: time.Hour, MaxDays: 7})
+	defer f.Close()
+	// A backup of a previous run, older than the retention.
+	old := f.backupName(clock.now().Add(-10 * 24 * time.Hour))
+	if err := os.WriteFile(old, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	writeString(t, f, "first\n")
+	clock.advance(30 * time.Minute)
+	writeString(t, f, "second\n")
+	clock.advance(time.Hour)
+	writeString(t, f, "third\n")
+	f.wg.Wait()
+
+	got := dirFiles(t, f)
+	if len(got) != 2 || got[0] != "app-2024-05-01T13-30-00.000.log" {
+		t.Fatalf("files = %v, want the rotated file and app.log", got)
+	}
+	if content := readFile(t, f.cfg.Path); content != "third\n" {
+		t.Fatalf("app.log holds %q", content)
+	}
+}
+
+func TestFileAgeOfExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(path, []byte("previous run\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	written := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, written, written); err != nil {
+		t.Fatal(err)
+	}
+	f, err := OpenFile(FileConfig{Path: path, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	writeString(t, f, "this run\n")
+
+	if got := readFile(t, path); got != "this run\n" {
+		t.Fatalf("app.log holds %q, the file of the previous run is older than MaxAge", got)
+	}
+}
+
+func TestFileReopen(t *testing.T) {
+	f, _ := openTestFile(t, FileConfig{})
+	defer f.Close()
+	writeString(t, f, "before\n")
+	// What an external logrotate does before sending SIGHUP.
+	moved := f.cfg.Path + ".1"
+	if err := os.Rename(f.cfg.Path, moved); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	writeString(t, f, "after\n")
+
+	if got := readFile(t, moved); got != "before\n" {
+		t.Fatalf("moved file holds %q", got)
+	}
+	if got := readFile(t, f.cfg.Path); got != "after\n" {
+		t.Fatalf("reopened file holds %q", got)
+	}
+}
+
+func TestFileKeepsWritingWhenRotationFails(t *testing.T) {
+	f, clock := openTestFile(t, FileConfig{MaxSize: 10})
+	f.rename = func(oldpath, newpath string) error {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+	}
+	writeString(t, f, "line 1\n")
+	clock.advance(time.Second)
+	writeString(t, f, "line 2\n")
+	if got := readFile(t, f.cfg.Path); got != "line 1\nline 2\n" {
+		t.Fatalf("app.log holds %q after a failed rotation", got)
+	}
+
+	// The next write rotates once the rename works again.
+	f.rename = os.Rename
+	clock.advance(time.Second)
+	writeString(t, f, "line 3\n")
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"app-2024-05-01T12-00-02.000.log", "app.log"}
+	if got := dirFiles(t, f); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Fatalf("files = %v, want %v", got, want)
+	}
+	if got := readFile(t, f.cfg.Path); got != "line 3\n" {
+		t.Fatalf("app.log holds %q", got)
+	}
+}
+
+func TestFileReopenFailureKeepsTheFile(t *testing.T) {
+	f, _ := openTestFile(t, FileConfig{})
+	defer f.Close()
+	writeString(t, f, "before\n")
+	moved := f.cfg.Path + ".1"
+	if err := os.Rename(f.cfg.Path, moved); err != nil {
+		t.Fatal(err)
+	}
+	// A directory where the log was can't be opened.
+	if err := os.Mkdir(f.cfg.Path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err == nil {
+		t.Fatal("Reopen of a directory succeeded")
+	}
+	writeString(t, f, "during\n")
+
+	if err := os.Remove(f.cfg.Path); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	writeString(t, f, "after\n")
+	if got := readFile(t, moved); got != "before\nduring\n" {
+		t.Fatalf("moved file holds %q", got)
+	}
+	if got := readFile(t, f.cfg.Path); got != "after\n" {
+		t.Fatalf("reopened file holds %q", got)
+	}
+}
+
+func TestFileConcurrentWritesDuringRotation(t *testing.T) {
+	// The clock stands still, every rotation happens in the same millisecond.
+	f, _ := openTestFile(t, FileConfig{MaxSize: 64})
+	line := strings.Repeat("x", 15) + "\n"
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				writeString(t, f, line)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var content string
+	for _, name := range dirFiles(t, f) {
+		content += readFile(t, filepath.Join(filepath.Dir(f.cfg.Path), name))
+	}
+	if n := strings.Count(content, line); n != 400 || n*len(line) != len(content) {
+		t.Fatalf("the files hold %d whole lines in %d bytes, want 400", n, len(content))
+	}
+}
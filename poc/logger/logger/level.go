@@ -0,0 +1,288 @@
+package logger
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// levelSpec is the default level and the levels of named loggers, as written in
+// LOG_LEVEL: "info,coordinator=debug,client=warn". The level of a named logger also
+// applies to its children, "coordinator" covers "coordinator.ring".
+type levelSpec struct {
+	level      zapcore.Level
+	components map[string]zapcore.Level
+}
+
+// parseSpec parses a spec, the default level is INFO when the spec has none.
+func parseSpec(s string) (levelSpec, error) {
+	spec := levelSpec{level: zapcore.InfoLevel, components: map[string]zapcore.Level{}}
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		name, value, named := strings.Cut(part, "=")
+		if !named {
+			value = name
+		}
+		level, err := parseLevel(value)
+		if err != nil {
+			return levelSpec{}, err
+		}
+		if !named {
+			spec.level = level
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if name == "" {
+			return levelSpec{}, fmt.Errorf("no logger name in %q", part)
+		}
+		spec.components[name] = level
+	}
+	return spec, nil
+}
+
+// String formats the spec as parseSpec reads it, the named loggers sorted.
+func (s levelSpec) String() string {
+	parts := []string{s.level.CapitalString()}
+	for name, level := range s.components {
+		parts = append(parts, name+"="+level.CapitalString())
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, ",")
+}
+
+// levelControl holds the levels of a logger and its named children and changes
+// them, possibly for a while only.
+type levelControl struct {
+	level      zap.AtomicLevel
+	components atomic.Pointer[map[string]zapcore.Level]
+
+	mu       sync.Mutex
+	revert   *time.Timer
+	revertAt time.Time
+}
+
+func newLevelControl(spec levelSpec) *levelControl {
+	c := &levelControl{level: zap.NewAtomicLevelAt(spec.level)}
+	c.components.Store(&spec.components)
+	return c
+}
+
+// of returns the level of the named logger: its own, the one of its closest named
+// parent or else the default.
+func (c *levelControl) of(name string) zapcore.Level {
+	components := *c.components.Load()
+	for name != "" {
+		if level, ok := components[name]; ok {
+			return level
+		}
+		i := strings.LastIndexByte(name, '.')
+		if i < 0 {
+			break
+		}
+		name = name[:i]
+	}
+	return c.level.Level()
+}
+
+func (c *levelControl) spec() levelSpec {
+	return levelSpec{level: c.level.Level(), components: *c.components.Load()}
+}
+
+// parseLevel accepts the LOG_LEVEL values, DEBUG, INFO, WARN and ERROR, in any case.
+func parseLevel(s string) (zapcore.Level, error) {
+	switch strings.ToUpper(strings.TrimSpace(s)) {
+	case "DEBUG":
+		return zapcore.DebugLevel, nil
+	case "INFO":
+		return zapcore.InfoLevel, nil
+	case "WARN":
+		return zapcore.WarnLevel, nil
+	case "ERROR":
+		return zapcore.ErrorLevel, nil
+	default:
+		return zapcore.InfoLevel, fmt.Errorf("unknown log level %q", s)
+	}
+}
+
+func (c *levelControl) apply(spec levelSpec) {
+	c.components.Store(&spec.components)
+	c.level.SetLevel(spec.level)
+}
+
+// set changes the levels, cancelling a pending revert. With a positive revertAfter
+// the current levels come back after that long.
+func (c *levelControl) set(spec levelSpec, revertAfter time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.setLocked(spec, revertAfter)
+}
+
+func (c *levelControl) setLocked(spec levelSpec, revertAfter time.Duration) {
+	if c.revert != nil {
+		c.revert.Stop()
+		c.revert, c.revertAt = nil, time.Time{}
+	}
+	previous := c.spec()
+	c.apply(spec)
+	if revertAfter <= 0 {
+		return
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(revertAfter, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// A later set replaced this revert.
+		if c.revert != timer {
+			return
+		}
+		c.apply(previous)
+		c.revert, c.revertAt = nil, time.Time{}
+	})
+	c.revert, c.revertAt = timer, time.Now().Add(revertAfter)
+}
+
+// Level returns the level of the logger, given its name.
+func (l *Logger) Level() zapcore.Level {
+	return l.level.of(l.name)
+}
+
+// SetLevel changes the default log level, cancelling a pending revert. The levels of
+// named loggers stay.
+func (l *Logger) SetLevel(level zapcore.Level) {
+	l.SetLevelFor(level, 0)
+}
+
+// SetLevelFor changes the default log level and reverts to the current one after d.
+func (l *Logger) SetLevelFor(level zapcore.Level, d time.Duration) {
+	l.level.mu.Lock()
+	defer l.level.mu.Unlock()
+	spec := l.level.spec()
+	spec.level = level
+	l.level.setLocked(spec, d)
+}
+
+// SetLevels replaces the default level and the levels of the named loggers with a
+// spec like "info,coordinator=debug,client=warn".
+func (l *Logger) SetLevels(spec string) error {
+	parsed, err := parseSpec(spec)
+	if err != nil {
+		return err
+	}
+	l.level.set(parsed, 0)
+	return nil
+}
+
+// Levels returns the spec of the current levels.
+func (l *Logger) Levels() string {
+	return l.level.spec().String()
+}
+
+// levelState is the body of the level handler.
+type levelState struct {
+	// Level is a spec, a single level changes the default and drops the named ones.
+	Level string `json:"level"`
+	// RevertAfter is a duration like "10m" when setting the level.
+	RevertAfter string     `json:"revertAfter,omitempty"`
+	RevertAt    *time.Time `json:"revertAt,omitempty"`
+}
+
+func (c *levelControl) state() levelState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := levelState{Level: c.spec().String()}
+	if c.revert != nil {
+		revertAt := c.revertAt
+		s.RevertAt = &revertAt
+	}
+	return s
+}
+
+// LevelHandler serves the log levels: GET returns them and PUT changes them, e.g.
+//
+//	curl -X PUT -d '{"level": "INFO,coordinator=DEBUG", "revertAfter": "10m"}' localhost:8080/log/level
+func (l *Logger) LevelHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut:
+			var req levelState
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			spec, err := parseSpec(req.Level)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			var revertAfter time.Duration
+			if req.RevertAfter != "" {
+				if revertAfter, err = time.ParseDuration(req.RevertAfter); err != nil {
+					http.Error(w, err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			l.level.set(spec, revertAfter)
+			l.Info("Log level changed", zap.Stringer("level", spec), zap.Duration("revertAfter", revertAfter))
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.level.state())
+	})
+}
+
+// Reload sets the levels from the file named by LOG_CONFIG, a JSON object like
+// {"level": "DEBUG,client=WARN"}, or else from LOG_LEVEL. An invalid spec is an
+// error and the current levels stay.
+func (l *Logger) Reload() error {
+	value := os.Getenv("LOG_LEVEL")
+	if path := os.Getenv("LOG_CONFIG"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var cfg levelState
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		value = cfg.Level
+	}
+	return l.SetLevels(value)
+}
+
+// ReloadOnSIGHUP calls Reload on every SIGHUP until the context is done.
+func (l *Logger) ReloadOnSIGHUP(ctx context.Context) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sigCh)
+		for {
+			select {
+			case <-sigCh:
+				if err := l.Reload(); err != nil {
+					l.Error("Reloading the log level failed", zap.Error(err))
+					continue
+				}
+				l.Info("Log level reloaded", zap.String("level", l.Levels()))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
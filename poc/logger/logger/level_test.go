@@ -0,0 +1,115 @@
+package logger
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func newBufferLogger(level zapcore.Level) (*Logger, *bytes.Buffer) {
+	buffer := &bytes.Buffer{}
+	return newLogger(levelSpec{level: level}, "json", zapcore.AddSync(buffer)), buffer
+}
+
+func waitLevel(t *testing.T, l *Logger, want zapcore.Level) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if l.Level() == want {
+			return
+		}
+	}
+	t.Fatalf("level = %v, want %v", l.Level(), want)
+}
+
+func TestSetLevel(t *testing.T) {
+	l, buffer := newBufferLogger(zapcore.InfoLevel)
+	l.Debug("hidden")
+	l.SetLevel(zapcore.DebugLevel)
+	l.Debug("shown")
+	if out := buffer.String(); strings.Contains(out, "hidden") || !strings.Contains(out, "shown") {
+		t.Fatalf("output = %q", out)
+	}
+}
+
+func TestSetLevelForReverts(t *testing.T) {
+	l, _ := newBufferLogger(zapcore.WarnLevel)
+	l.SetLevelFor(zapcore.DebugLevel, 20*time.Millisecond)
+	if l.Level() != zapcore.DebugLevel {
+		t.Fatalf("level = %v", l.Level())
+	}
+	waitLevel(t, l, zapcore.WarnLevel)
+
+	// A later change cancels the revert.
+	l.SetLevelFor(zapcore.DebugLevel, 20*time.Millisecond)
+	l.SetLevel(zapcore.ErrorLevel)
+	time.Sleep(50 * time.Millisecond)
+	if l.Level() != zapcore.ErrorLevel {
+		t.Fatalf("level = %v, want ERROR", l.Level())
+	}
+}
+
+func TestLevelHandler(t *testing.T) {
+	l, _ := newBufferLogger(zapcore.InfoLevel)
+	srv := httptest.NewServer(l.LevelHandler())
+	defer srv.Close()
+
+	do := func(method, body string) (int, levelState) {
+		req, _ := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var s levelState
+		json.NewDecoder(resp.Body).Decode(&s)
+		return resp.StatusCode, s
+	}
+	if code, s := do(http.MethodGet, ""); code != http.StatusOK || s.Level != "INFO" || s.RevertAt != nil {
+		t.Fatalf("GET = %d %+v", code, s)
+	}
+	if code, s := do(http.MethodPut, `{"level": "debug", "revertAfter": "1h"}`); code != http.StatusOK || s.Level != "DEBUG" || s.RevertAt == nil {
+		t.Fatalf("PUT = %d %+v", code, s)
+	}
+	if code, _ := do(http.MethodPut, `{"level": "loud"}`); code != http.StatusBadRequest {
+		t.Fatalf("PUT of an unknown level = %d", code)
+	}
+	if l.Level() != zapcore.DebugLevel {
+		t.Fatalf("level = %v", l.Level())
+	}
+}
+
+func TestReloadOnSIGHUP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.json")
+	if err := os.WriteFile(path, []byte(`{"level": "ERROR"}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LOG_LEVEL", "DEBUG")
+	l, _ := newBufferLogger(zapcore.InfoLevel)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	l.ReloadOnSIGHUP(ctx)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	waitLevel(t, l, zapcore.DebugLevel)
+
+	// The config file wins over the environment.
+	t.Setenv("LOG_CONFIG", path)
+	syscall.Kill(syscall.Getpid(), syscall.SIGHUP)
+	waitLevel(t, l, zapcore.ErrorLevel)
+
+	t.Setenv("LOG_CONFIG", "")
+	t.Setenv("LOG_LEVEL", "LOUD")
+	if err := l.Reload(); err == nil || l.Level() != zapcore.ErrorLevel {
+		t.Fatalf("Reload = %v, level %v", err, l.Level())
+	}
+}
@@ -1,7 +1,10 @@
 package logger
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"strings"
 	"sync"
 
 	"go.uber.org/zap"
@@ -13,66 +16,142 @@ var (
 	instance *Logger
 )
 
-// Logger is a wrapper for zap.Logger that provides receiver methods for logging
+// Logger is a wrapper for zap.Logger that provides receiver methods for logging.
+// Named returns child loggers of a component, which may have their own level.
 type Logger struct {
 	zapLogger *zap.Logger
+	level     *levelControl
+	name      string
+	// spanEvents records the lines of the context methods as span events, see context.go.
+	spanEvents bool
+	// drops counts the entries sampling dropped, see sampling.go.
+	drops *drops
 }
 
-// Init initializes the logger with a log level based on the LOG_LEVEL environment variable
+// Init initializes the logger with the log levels of the LOG_LEVEL environment variable,
+// e.g. "INFO" or "info,coordinator=debug,client=warn", sampled as LOG_SAMPLING says,
+// e.g. "interval=1s,first=100,thereafter=100,duplicates=5s".
+// LOG_ENCODING is json or console, LOG_OUTPUT is stdout, stderr or a file rotated as
+// LOG_ROTATE says, e.g. "size=100MB,age=24h,files=10,days=7,compress=true"
 func Init() *Logger {
 	once.Do(func() {
-		logLevel := getLogLevelFromEnv()
-
-		config := zap.Config{
-			Level:            zap.NewAtomicLevelAt(logLevel), // Set the log level dynamically
-			Development:      true,
-			Encoding:         "json", // or "console" for pretty output
-			OutputPaths:      []string{"stdout"},
-			ErrorOutputPaths: []string{"stderr"},
-			EncoderConfig: zapcore.EncoderConfig{
-				TimeKey:  "time",
-				LevelKey: "level",
-				NameKey:  "logger",
-				// CallerKey:      "caller",
-				MessageKey: "msg",
-				// StacktraceKey:  "stacktrace",
-				LineEnding:     zapcore.DefaultLineEnding,
-				EncodeLevel:    zapcore.CapitalLevelEncoder, // INFO, ERROR, etc.
-				EncodeTime:     zapcore.ISO8601TimeEncoder,  // Human-readable time format
-				EncodeDuration: zapcore.StringDurationEncoder,
-				EncodeCaller:   zapcore.ShortCallerEncoder,
-			},
-		}
-
-		zapLogger, err := config.Build()
+		out, err := getOutputFromEnv()
 		if err != nil {
-			panic("failed to initialize logger: " + err.Error())
+			fmt.Fprintf(os.Stderr, "logger: %v, logging to stdout\n", err)
+			out = zapcore.Lock(os.Stdout)
 		}
-
-		instance = &Logger{zapLogger: zapLogger}
+		instance = newLogger(getLogLevelFromEnv(), getEncodingFromEnv(), out).Sampled(getSamplingFromEnv())
 	})
 
 	return instance
 }
 
-// getLogLevelFromEnv reads the LOG_LEVEL from environment and returns the appropriate zapcore.Level
-func getLogLevelFromEnv() zapcore.Level {
-	logLevel := os.Getenv("LOG_LEVEL")
+func newLogger(spec levelSpec, encoding string, out zapcore.WriteSyncer) *Logger {
+	// The levels are kept so they can be changed at runtime, see level.go.
+	levels := newLevelControl(spec)
+	encoderConfig := zapcore.EncoderConfig{
+		TimeKey:  "time",
+		LevelKey: "level",
+		NameKey:  "logger",
+		// CallerKey:      "caller",
+		MessageKey: "msg",
+		// StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.CapitalLevelEncoder, // INFO, ERROR, etc.
+		EncodeTime:     zapcore.ISO8601TimeEncoder,  // Human-readable time format
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+	// Every entry reaches the core, componentCore filters them by the level of the logger.
+	encoder := zapcore.NewJSONEncoder(encoderConfig)
+	if encoding == "console" {
+		encoder = zapcore.NewConsoleEncoder(encoderConfig) // pretty output
+	}
+	core := zapcore.NewCore(encoder, out, zapcore.DebugLevel)
+	zapLogger := zap.New(&componentCore{Core: core, levels: levels}, zap.Development(), zap.ErrorOutput(zapcore.Lock(os.Stderr)))
+
+	return &Logger{zapLogger: zapLogger, level: levels}
+}
+
+// getLogLevelFromEnv reads the LOG_LEVEL from environment and returns the levels it sets
+func getLogLevelFromEnv() levelSpec {
+	spec, err := parseSpec(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		spec, _ = parseSpec("") // Default log level if not set
+	}
+	return spec
+}
+
+// getEncodingFromEnv reads the LOG_ENCODING from environment, json unless it is console
+func getEncodingFromEnv() string {
+	if strings.EqualFold(os.Getenv("LOG_ENCODING"), "console") {
+		return "console"
+	}
+	return "json"
+}
 
-	switch logLevel {
-	case "DEBUG":
-		return zapcore.DebugLevel
-	case "INFO":
-		return zapcore.InfoLevel
-	case "WARN":
-		return zapcore.WarnLevel
-	case "ERROR":
-		return zapcore.ErrorLevel
+// getOutputFromEnv opens the LOG_OUTPUT, a file reopens on SIGHUP for an external logrotate
+func getOutputFromEnv() (zapcore.WriteSyncer, error) {
+	switch output := os.Getenv("LOG_OUTPUT"); output {
+	case "", "stdout":
+		return zapcore.Lock(os.Stdout), nil
+	case "stderr":
+		return zapcore.Lock(os.Stderr), nil
 	default:
-		return zapcore.InfoLevel // Default log level if not set
+		cfg, err := parseFileConfig(output, os.Getenv("LOG_ROTATE"))
+		if err != nil {
+			return nil, err
+		}
+		file, err := OpenFile(cfg)
+		if err != nil {
+			return nil, err
+		}
+		file.ReopenOnSIGHUP(context.Background())
+		return file, nil
 	}
 }
 
+// componentCore enables the entries at or above the level of their logger name.
+type componentCore struct {
+	zapcore.Core
+	levels *levelControl
+	name   string
+}
+
+func (c *componentCore) Enabled(level zapcore.Level) bool {
+	return level >= c.levels.of(c.name)
+}
+
+func (c *componentCore) With(fields []zapcore.Field) zapcore.Core {
+	return &componentCore{Core: c.Core.With(fields), levels: c.levels, name: c.name}
+}
+
+func (c *componentCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if !c.Enabled(entry.Level) {
+		return checked
+	}
+	return c.Core.Check(entry, checked)
+}
+
+// Named returns the logger of a component. Names nest with dots, the child of
+// "coordinator" named "ring" is "coordinator.ring" and uses the level of
+// "coordinator" unless it has its own.
+func (l *Logger) Named(name string) *Logger {
+	fullName := name
+	if l.name != "" {
+		fullName = l.name + "." + name
+	}
+	zapLogger := l.zapLogger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return &componentCore{Core: c.(*componentCore).Core, levels: l.level, name: fullName}
+	})).Named(name)
+	return &Logger{zapLogger: zapLogger, level: l.level, name: fullName, spanEvents: l.spanEvents, drops: l.drops}
+}
+
+// With returns a child logger that adds the fields to every entry.
+func (l *Logger) With(fields ...zap.Field) *Logger {
+	return &Logger{zapLogger: l.zapLogger.With(fields...), level: l.level, name: l.name, spanEvents: l.spanEvents, drops: l.drops}
+}
+
 // Info logs a message at InfoLevel
 func (l *Logger) Info(msg string, fields ...zap.Field) {
 	l.zapLogger.Info(msg, fields...)
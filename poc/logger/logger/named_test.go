@@ -0,0 +1,103 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseSpec(t *testing.T) {
+	spec, err := parseSpec("info, coordinator=debug,client=WARN")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := spec.String(); got != "INFO,client=WARN,coordinator=DEBUG" {
+		t.Fatalf("String() = %q", got)
+	}
+	if spec, _ := parseSpec("client=error"); spec.level != zapcore.InfoLevel {
+		t.Fatalf("default level = %v, want INFO", spec.level)
+	}
+	for _, bad := range []string{"loud", "client=loud", "=debug"} {
+		if _, err := parseSpec(bad); err == nil {
+			t.Fatalf("parseSpec(%q) succeeded", bad)
+		}
+	}
+}
+
+type entry struct {
+	Level    string `json:"level"`
+	Logger   string `json:"logger"`
+	Msg      string `json:"msg"`
+	Ring     string `json:"ring"`
+	Peer     string `json:"peer"`
+	Repeated uint64 `json:"repeated"`
+}
+
+func entries(t *testing.T, buffer *bytes.Buffer) []entry {
+	t.Helper()
+	var res []entry
+	dec := json.NewDecoder(buffer)
+	for dec.More() {
+		var e entry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatal(err)
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+func TestNamedLoggerLevels(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	spec, _ := parseSpec("info,coordinator=debug,client=warn")
+	root := newLogger(spec, "json", zapcore.AddSync(buffer))
+	coordinator := root.Named("coordinator")
+	ring := coordinator.Named("ring").With(zap.String("ring", "orders"))
+	client := root.Named("client")
+
+	root.Debug("root debug")
+	root.Info("root info")
+	coordinator.Debug("coordinator debug")
+	ring.Debug("ring debug")
+	client.Info("client info")
+	client.Warn("client warn")
+
+	want := []entry{
+		{Level: "INFO", Msg: "root info"},
+		{Level: "DEBUG", Logger: "coordinator", Msg: "coordinator debug"},
+		{Level: "DEBUG", Logger: "coordinator.ring", Msg: "ring debug", Ring: "orders"},
+		{Level: "WARN", Logger: "client", Msg: "client warn"},
+	}
+	got := entries(t, buffer)
+	if len(got) != len(want) {
+		t.Fatalf("entries = %+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// The levels change at runtime for the loggers already created.
+	if err := root.SetLevels("warn,coordinator.ring=debug"); err != nil {
+		t.Fatal(err)
+	}
+	coordinator.Debug("hidden")
+	client.Info("hidden")
+	ring.Debug("ring debug")
+	if got := entries(t, buffer); len(got) != 1 || got[0].Msg != "ring debug" {
+		t.Fatalf("entries = %+v", got)
+	}
+	if coordinator.Level() != zapcore.WarnLevel || ring.Level() != zapcore.DebugLevel {
+		t.Fatalf("levels = %v, %v", coordinator.Level(), ring.Level())
+	}
+
+	// SetLevel only changes the default.
+	root.SetLevel(zapcore.ErrorLevel)
+	if got := root.Levels(); got != "ERROR,coordinator.ring=DEBUG" {
+		t.Fatalf("Levels() = %q", got)
+	}
+}
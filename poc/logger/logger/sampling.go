@@ -0,0 +1,283 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// SamplingConfig limits the entries of busy loggers. Sampling and duplicate
+// suppression are per level and message, the entries they drop are counted, see
+// Dropped.
+type SamplingConfig struct {
+	// Interval, First and Thereafter sample the entries: in each Interval the first
+	// First entries are logged, then one in Thereafter. A zero Interval disables it,
+	// First and Thereafter both zero mean 100 each.
+	Interval   time.Duration
+	First      int
+	Thereafter int
+	// DuplicateWindow suppresses the entries equal to one logged less than the window
+	// before, fields included, and then logs how many times it repeated. Zero disables it.
+	DuplicateWindow time.Duration
+}
+
+// Zap's production sampling, used when neither First nor Thereafter is set.
+const (
+	defaultSamplingFirst      = 100
+	defaultSamplingThereafter = 100
+)
+
+// withDefaults sets First and Thereafter when both are zero, the sampler would drop
+// every entry of the interval otherwise.
+func (cfg SamplingConfig) withDefaults() SamplingConfig {
+	if cfg.First == 0 && cfg.Thereafter == 0 {
+		cfg.First, cfg.Thereafter = defaultSamplingFirst, defaultSamplingThereafter
+	}
+	return cfg
+}
+
+// parseSampling reads LOG_SAMPLING, e.g. "interval=1s,first=100,thereafter=100,duplicates=5s".
+// First and Thereafter default to 100, setting both to zero is an error.
+func parseSampling(s string) (SamplingConfig, error) {
+	var cfg SamplingConfig
+	counts := false
+	for _, part := range strings.Split(s, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(part, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		var err error
+		switch key {
+		case "interval":
+			cfg.Interval, err = time.ParseDuration(value)
+		case "first":
+			cfg.First, err = strconv.Atoi(value)
+			counts = true
+		case "thereafter":
+			cfg.Thereafter, err = strconv.Atoi(value)
+			counts = true
+		case "duplicates":
+			cfg.DuplicateWindow, err = time.ParseDuration(value)
+		default:
+			return SamplingConfig{}, fmt.Errorf("unknown sampling option %q", key)
+		}
+		if err != nil {
+			return SamplingConfig{}, fmt.Errorf("sampling option %s: %w", key, err)
+		}
+	}
+	switch {
+	case cfg.Interval < 0 || cfg.First < 0 || cfg.Thereafter < 0 || cfg.DuplicateWindow < 0:
+		return SamplingConfig{}, fmt.Errorf("negative sampling option in %q", s)
+	case cfg.Interval > 0 && counts && cfg.First == 0 && cfg.Thereafter == 0:
+		return SamplingConfig{}, fmt.Errorf("sampling drops every entry, first or thereafter must be positive in %q", s)
+	case cfg.Interval > 0:
+		cfg = cfg.withDefaults()
+	}
+	return cfg, nil
+}
+
+// getSamplingFromEnv reads the LOG_SAMPLING from environment, no sampling if unset or invalid
+func getSamplingFromEnv() SamplingConfig {
+	cfg, err := parseSampling(os.Getenv("LOG_SAMPLING"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "logger: %v, not sampling\n", err)
+		return SamplingConfig{}
+	}
+	return cfg
+}
+
+// DropStats counts the entries that were not logged by level and message, like
+// "WARN broadcast failed".
+type DropStats struct {
+	Sampled    map[string]uint64 `json:"sampled"`
+	Duplicates map[string]uint64 `json:"duplicates"`
+}
+
+// Total returns the number of dropped entries.
+func (s DropStats) Total() uint64 {
+	var total uint64
+	for _, n := range s.Sampled {
+		total += n
+	}
+	for _, n := range s.Duplicates {
+		total += n
+	}
+	return total
+}
+
+// drops is shared by a logger and its children.
+type drops struct {
+	mu    sync.Mutex
+	stats DropStats
+}
+
+func newDrops() *drops {
+	return &drops{stats: DropStats{Sampled: map[string]uint64{}, Duplicates: map[string]uint64{}}}
+}
+
+func (d *drops) add(counts map[string]uint64, entry zapcore.Entry, n uint64) {
+	d.mu.Lock()
+	counts[entry.Level.CapitalString()+" "+entry.Message] += n
+	d.mu.Unlock()
+}
+
+func (d *drops) snapshot() DropStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s := DropStats{Sampled: map[string]uint64{}, Duplicates: map[string]uint64{}}
+	for k, v := range d.stats.Sampled {
+		s.Sampled[k] = v
+	}
+	for k, v := range d.stats.Duplicates {
+		s.Duplicates[k] = v
+	}
+	return s
+}
+
+// Sampled returns a logger whose entries, and the ones of its children, are sampled
+// as configured. The level filter applies first, disabled entries aren't counted.
+func (l *Logger) Sampled(cfg SamplingConfig) *Logger {
+	d := newDrops()
+	zapLogger := l.zapLogger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		component := c.(*componentCore)
+		return &componentCore{Core: sampleCore(component.Core, cfg, d), levels: component.levels, name: component.name}
+	}))
+	child := *l
+	child.zapLogger, child.drops = zapLogger, d
+	return &child
+}
+
+// Dropped returns the entries sampling and duplicate suppression dropped so far.
+func (l *Logger) Dropped() DropStats {
+	if l.drops == nil {
+		return newDrops().snapshot()
+	}
+	return l.drops.snapshot()
+}
+
+// sampleCore wraps the core with the duplicate suppression, then the sampling.
+func sampleCore(core zapcore.Core, cfg SamplingConfig, d *drops) zapcore.Core {
+	if cfg.Interval > 0 {
+		cfg = cfg.withDefaults()
+		core = zapcore.NewSamplerWithOptions(core, cfg.Interval, cfg.First, cfg.Thereafter,
+			zapcore.SamplerHook(func(entry zapcore.Entry, dec zapcore.SamplingDecision) {
+				if dec&zapcore.LogDropped != 0 {
+					d.add(d.stats.Sampled, entry, 1)
+				}
+			}))
+	}
+	if cfg.DuplicateWindow > 0 {
+		core = &dedupCore{Core: core, window: cfg.DuplicateWindow, drops: d, seen: &dedupState{entries: map[string]*duplicate{}}}
+	}
+	return core
+}
+
+// dedupCore logs the first of equal entries and counts the others until the window
+// after it ends, then logs the count.
+type dedupCore struct {
+	zapcore.Core
+	window time.Duration
+	drops  *drops
+	// context is the encoded fields of With, part of the key of the entries.
+	context string
+	seen    *dedupState
+}
+
+type dedupState struct {
+	mu      sync.Mutex
+	entries map[string]*duplicate
+}
+
+type duplicate struct {
+	core    zapcore.Core
+	entry   zapcore.Entry
+	fields  []zapcore.Field
+	repeats uint64
+	timer   *time.Timer
+}
+
+func (c *dedupCore) With(fields []zapcore.Field) zapcore.Core {
+	return &dedupCore{Core: c.Core.With(fields), window: c.window, drops: c.drops, context: c.context + encodeFields(fields), seen: c.seen}
+}
+
+func (c *dedupCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(entry.Level) {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *dedupCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	key := entry.Level.String() + "\x00" + entry.LoggerName + "\x00" + entry.Message + "\x00" + c.context + encodeFields(fields)
+	c.seen.mu.Lock()
+	if d, ok := c.seen.entries[key]; ok {
+		d.repeats++
+		c.seen.mu.Unlock()
+		c.drops.add(c.drops.stats.Duplicates, entry, 1)
+		return nil
+	}
+	d := &duplicate{core: c.Core, entry: entry, fields: append([]zapcore.Field(nil), fields...)}
+	c.seen.entries[key] = d
+	d.timer = time.AfterFunc(c.window, func() { c.flush(key, d) })
+	c.seen.mu.Unlock()
+	return write(c.Core, entry, fields)
+}
+
+// flush ends the window of an entry and logs how many times it repeated.
+func (c *dedupCore) flush(key string, d *duplicate) {
+	c.seen.mu.Lock()
+	if c.seen.entries[key] != d {
+		c.seen.mu.Unlock()
+		return
+	}
+	delete(c.seen.entries, key)
+	c.seen.mu.Unlock()
+	if d.repeats == 0 {
+		return
+	}
+	entry := d.entry
+	entry.Time = time.Now()
+	entry.Message = fmt.Sprintf("%s (repeated %d times)", d.entry.Message, d.repeats)
+	write(d.core, entry, append(d.fields, zap.Uint64("repeated", d.repeats)))
+}
+
+// Sync logs the counts of the pending windows before syncing.
+func (c *dedupCore) Sync() error {
+	c.seen.mu.Lock()
+	pending := make(map[string]*duplicate, len(c.seen.entries))
+	for key, d := range c.seen.entries {
+		d.timer.Stop()
+		pending[key] = d
+	}
+	c.seen.mu.Unlock()
+	for key, d := range pending {
+		c.flush(key, d)
+	}
+	return c.Core.Sync()
+}
+
+// write passes the entry through the Check of the core, where the sampler decides.
+func write(core zapcore.Core, entry zapcore.Entry, fields []zapcore.Field) error {
+	if checked := core.Check(entry, nil); checked != nil {
+		checked.Write(fields...)
+	}
+	return nil
+}
+
+func encodeFields(fields []zapcore.Field) string {
+	if len(fields) == 0 {
+		return ""
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+	return fmt.Sprint(enc.Fields)
+}
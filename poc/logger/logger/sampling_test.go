@@ -0,0 +1,123 @@
+package logger
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestParseSampling(t *testing.T) {
+	cfg, err := parseSampling("interval=1s, first=100,thereafter=10,duplicates=5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := SamplingConfig{Interval: time.Second, First: 100, Thereafter: 10, DuplicateWindow: 5 * time.Second}
+	if cfg != want {
+		t.Fatalf("parseSampling = %+v, want %+v", cfg, want)
+	}
+	if cfg, _ := parseSampling("interval=1s"); cfg.First != 100 || cfg.Thereafter != 100 {
+		t.Fatalf("interval only = %+v, want first and thereafter 100", cfg)
+	}
+	if cfg, _ := parseSampling("interval=1s,first=10"); cfg.First != 10 || cfg.Thereafter != 0 {
+		t.Fatalf("first only = %+v, want first 10 and no thereafter", cfg)
+	}
+	for _, bad := range []string{"first=many", "interval=1", "rate=2", "interval=1s,first=0,thereafter=0", "interval=1s,first=-1"} {
+		if _, err := parseSampling(bad); err == nil {
+			t.Fatalf("parseSampling(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestSamplingFirstThenEveryNth(t *testing.T) {
+	base, buffer := newBufferLogger(zapcore.InfoLevel)
+	l := base.Sampled(SamplingConfig{Interval: time.Minute, First: 2, Thereafter: 3})
+
+	for i := 0; i < 10; i++ {
+		l.Info("tick")
+	}
+	l.Info("other")
+	// Disabled entries are not sampled nor counted.
+	l.Debug("tick")
+
+	got := entries(t, buffer)
+	if len(got) != 5 {
+		t.Fatalf("logged %d entries, want the 1st, 2nd, 5th and 8th tick and other: %+v", len(got), got)
+	}
+	drops := l.Named("child").Dropped()
+	if drops.Sampled["INFO tick"] != 6 || drops.Total() != 6 {
+		t.Fatalf("drops = %+v, want 6 ticks", drops)
+	}
+}
+
+func TestSamplingIntervalOnly(t *testing.T) {
+	base, buffer := newBufferLogger(zapcore.InfoLevel)
+	cfg, err := parseSampling("interval=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := base.Sampled(cfg)
+	// The zero config of Sampled gets the same defaults.
+	zero := base.Sampled(SamplingConfig{Interval: time.Minute})
+
+	for i := 0; i < 101; i++ {
+		l.Info("tick")
+		zero.Info("tock")
+	}
+	if got := len(entries(t, buffer)); got != 200 {
+		t.Fatalf("logged %d entries, want the first 100 of each", got)
+	}
+	if drops := l.Dropped(); drops.Sampled["INFO tick"] != 1 {
+		t.Fatalf("drops = %+v, want 1 tick", drops)
+	}
+}
+
+func TestDuplicateSuppression(t *testing.T) {
+	base, buffer := newBufferLogger(zapcore.InfoLevel)
+	l := base.Sampled(SamplingConfig{DuplicateWindow: time.Minute}).Named("coordinator")
+
+	for i := 0; i < 4; i++ {
+		l.Warn("broadcast failed", zap.String("peer", "a"))
+	}
+	l.Warn("broadcast failed", zap.String("peer", "b"))
+	l.With(zap.String("peer", "c")).Warn("broadcast failed")
+	l.Sync()
+
+	got := entries(t, buffer)
+	want := []entry{
+		{Level: "WARN", Msg: "broadcast failed", Logger: "coordinator", Peer: "a"},
+		{Level: "WARN", Msg: "broadcast failed", Logger: "coordinator", Peer: "b"},
+		{Level: "WARN", Msg: "broadcast failed", Logger: "coordinator", Peer: "c"},
+		{Level: "WARN", Msg: "broadcast failed (repeated 3 times)", Logger: "coordinator", Peer: "a", Repeated: 3},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("entries = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("entry %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+	if drops := l.Dropped(); drops.Duplicates["WARN broadcast failed"] != 3 {
+		t.Fatalf("drops = %+v, want 3 duplicates", drops)
+	}
+}
+
+func TestDuplicateSummaryAfterWindow(t *testing.T) {
+	// The summary is written by the timer of the window.
+	buffer := &bytes.Buffer{}
+	l := newLogger(levelSpec{level: zapcore.InfoLevel}, "json", zapcore.Lock(zapcore.AddSync(buffer))).
+		Sampled(SamplingConfig{DuplicateWindow: 50 * time.Millisecond})
+
+	l.Info("retry")
+	l.Info("retry")
+	time.Sleep(150 * time.Millisecond)
+	l.Info("retry")
+
+	got := entries(t, buffer)
+	if len(got) != 3 || got[1].Msg != "retry (repeated 1 times)" || got[2].Msg != "retry" {
+		t.Fatalf("entries = %+v", got)
+	}
+}
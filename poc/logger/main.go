@@ -2,6 +2,7 @@ package main
 
 import "github.com/nibrasmuhamed/go-modules/logger"
 
+// LOG_OUTPUT=/var/log/app/app.log LOG_ROTATE=size=100MB,files=10,compress=true logs to a rotated file
 func main() {
 	logger := logger.Init()
 
@@ -9,4 +10,7 @@ func main() {
 	logger.Warn("This is a warning message")
 	logger.Debug("This is a debug message")
 	logger.Error("This is an error message")
+
+	// LOG_LEVEL=info,coordinator=debug shows this one
+	logger.Named("coordinator").Debug("This is a coordinator debug message")
 }
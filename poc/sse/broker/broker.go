@@ -0,0 +1,267 @@
+// Package broker fans server-sent events out to HTTP clients subscribed to named
+// topics. Every event gets an id, the last events of each topic are kept so a client
+// reconnecting with Last-Event-ID gets what it missed, idle streams carry comment
+// heartbeats and a client is dropped as soon as its request context is done.
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultReplaySize   = 100
+	DefaultHeartbeat    = 15 * time.Second
+	DefaultClientBuffer = 16
+)
+
+// ErrClosed is returned by Publish once the broker is closed.
+var ErrClosed = errors.New("broker closed")
+
+// Config of a broker. Zero values take the defaults.
+type Config struct {
+	// ReplaySize is the number of events kept per topic for Last-Event-ID.
+	ReplaySize int
+	// Heartbeat is the interval of the comment lines sent to keep streams open.
+	Heartbeat time.Duration
+	// Retry is the reconnection delay sent to clients when they connect, none if 0.
+	Retry time.Duration
+	// ClientBuffer is the number of events queued per client. A client that falls
+	// further behind is disconnected and resumes with Last-Event-ID.
+	ClientBuffer int
+}
+
+// Broker is safe for concurrent use.
+type Broker struct {
+	cfg Config
+
+	mu     sync.Mutex
+	lastID uint64
+	topics map[string]*topic
+	closed bool
+	done   chan struct{}
+}
+
+type topic struct {
+	// replay is a ring of the last events, next is where the next one goes.
+	replay      []published
+	next        int
+	subscribers map[*subscriber]struct{}
+}
+
+type published struct {
+	seq   uint64
+	event Event
+}
+
+type subscriber struct {
+	events chan published
+	// dropped is closed when the subscriber fell behind.
+	dropped chan struct{}
+}
+
+func New(cfg Config) *Broker {
+	if cfg.ReplaySize <= 0 {
+		cfg.ReplaySize = DefaultReplaySize
+	}
+	if cfg.Heartbeat <= 0 {
+		cfg.Heartbeat = DefaultHeartbeat
+	}
+	if cfg.ClientBuffer <= 0 {
+		cfg.ClientBuffer = DefaultClientBuffer
+	}
+	return &Broker{cfg: cfg, topics: make(map[string]*topic), done: make(chan struct{})}
+}
+
+func (b *Broker) topic(name string) *topic {
+	t, ok := b.topics[name]
+	if !ok {
+		t = &topic{subscribers: make(map[*subscriber]struct{})}
+		b.topics[name] = t
+	}
+	return t
+}
+
+// Publish sends an event of the given type to the subscribers of the topic and
+// returns it with its id. Ids increase across all the topics of the broker.
+func (b *Broker) Publish(topicName, event, data string) (Event, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return Event{}, ErrClosed
+	}
+	b.lastID++
+	p := published{seq: b.lastID, event: Event{ID: strconv.FormatUint(b.lastID, 10), Event: event, Data: data}}
+
+	t := b.topic(topicName)
+	if len(t.replay) < b.cfg.ReplaySize {
+		t.replay = append(t.replay, p)
+	} else {
+		t.replay[t.next] = p
+	}
+	t.next = (t.next + 1) % b.cfg.ReplaySize
+
+	for s := range t.subscribers {
+		select {
+		case s.events <- p:
+		default:
+			b.drop(s)
+		}
+	}
+	return p.event, nil
+}
+
+// drop disconnects a subscriber that fell behind.
+func (b *Broker) drop(s *subscriber) {
+	for _, t := range b.topics {
+		delete(t.subscribers, s)
+	}
+	close(s.dropped)
+}
+
+// subscribe registers a subscriber and returns the buffered events after lastID of
+// the topics, oldest first, atomically with the registration.
+func (b *Broker) subscribe(topics []string, lastID uint64, resume bool) (*subscriber, []published, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return nil, nil, ErrClosed
+	}
+	s := &subscriber{events: make(chan published, b.cfg.ClientBuffer), dropped: make(chan struct{})}
+	var missed []published
+	for _, name := range topics {
+		t := b.topic(name)
+		t.subscribers[s] = struct{}{}
+		if !resume {
+			continue
+		}
+		for _, p := range t.replay {
+			if p.seq > lastID {
+				missed = append(missed, p)
+			}
+		}
+	}
+	sort.Slice(missed, func(i, j int) bool { return missed[i].seq < missed[j].seq })
+	return s, missed, nil
+}
+
+func (b *Broker) unsubscribe(s *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, t := range b.topics {
+		delete(t.subscribers, s)
+	}
+}
+
+// Subscribers returns the number of clients subscribed to the topic.
+func (b *Broker) Subscribers(topicName string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if t, ok := b.topics[topicName]; ok {
+		return len(t.subscribers)
+	}
+	return 0
+}
+
+// Close ends every stream. Publish fails afterwards.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.closed {
+		b.closed = true
+		close(b.done)
+	}
+}
+
+// ServeHTTP streams the topics named by the topic query parameters, e.g.
+// /events?topic=rings&topic=health.
+func (b *Broker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.serve(w, r, r.URL.Query()["topic"])
+}
+
+// Handler streams the given topics whatever the request asks for.
+func (b *Broker) Handler(topics ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.serve(w, r, topics)
+	})
+}
+
+func (b *Broker) serve(w http.ResponseWriter, r *http.Request, topics []string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	if len(topics) == 0 {
+		http.Error(w, "no topic", http.StatusBadRequest)
+		return
+	}
+	lastEventID := r.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = r.URL.Query().Get("lastEventId")
+	}
+	var lastID uint64
+	if lastEventID != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(lastEventID, 10, 64); err != nil {
+			http.Error(w, fmt.Sprintf("invalid Last-Event-ID %q", lastEventID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	s, missed, err := b.subscribe(topics, lastID, lastEventID != "")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer b.unsubscribe(s)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if b.cfg.Retry > 0 {
+		fmt.Fprintf(w, "retry: %d\n\n", b.cfg.Retry.Milliseconds())
+	}
+	// An event published between subscribe and the replay is both queued and
+	// replayed, sent tracks the last id written to skip it.
+	var sent uint64
+	for _, p := range missed {
+		if p.event.write(w) != nil {
+			return
+		}
+		sent = p.seq
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(b.cfg.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case p := <-s.events:
+			if p.seq <= sent {
+				continue
+			}
+			if p.event.write(w) != nil {
+				return
+			}
+			sent = p.seq
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case <-s.dropped:
+			return
+		case <-b.done:
+			return
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,229 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReaderParsesEvents(t *testing.T) {
+	stream := ": comment\n" +
+		"retry: 250\n\n" +
+		"id: 1\nevent: add\ndata: a\ndata: b\n\n" +
+		"data: no id\r\n\r\n" +
+		"id: 7\n\n" +
+		"data: after\n\n"
+	r := newReader(strings.NewReader(stream))
+	want := []Event{
+		{ID: "1", Event: "add", Data: "a\nb"},
+		{ID: "1", Data: "no id"},
+		{ID: "7", Data: "after"},
+	}
+	for _, w := range want {
+		e, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != w {
+			t.Fatalf("next() = %+v, want %+v", e, w)
+		}
+	}
+	if r.retry != 250*time.Millisecond {
+		t.Fatalf("retry = %v", r.retry)
+	}
+}
+
+// TestClientResumesBeforeACutEvent cuts the first stream after the id of the second
+// event; that id isn't the last one yet, so the event is asked for again.
+func TestClientResumesBeforeACutEvent(t *testing.T) {
+	lastIDs := make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIDs <- r.Header.Get("Last-Event-ID")
+		w.Header().Set("Content-Type", "text/event-stream")
+		if r.Header.Get("Last-Event-ID") == "" {
+			io.WriteString(w, "retry: 10\n\nid: 1\ndata: a\n\nid: 2\ndata: b\n")
+			return
+		}
+		io.WriteString(w, "id: 2\ndata: b\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c := NewClient(srv.URL)
+	c.OnError = func(error) {}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var got []string
+	c.Subscribe(ctx, func(e Event) {
+		got = append(got, e.ID+"="+e.Data)
+		if len(got) == 2 {
+			cancel()
+		}
+	})
+	if strings.Join(got, ",") != "1=a,2=b" || c.LastEventID() != "2" {
+		t.Fatalf("got %v, last id %s", got, c.LastEventID())
+	}
+	if first, second := <-lastIDs, <-lastIDs; first != "" || second != "1" {
+		t.Fatalf("Last-Event-ID headers %q, %q", first, second)
+	}
+}
+
+func waitSubscribers(t *testing.T, b *Broker, topic string, want int) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if b.Subscribers(topic) == want {
+			return
+		}
+	}
+	t.Fatalf("%s has %d subscribers, want %d", topic, b.Subscribers(topic), want)
+}
+
+func TestTopicsAndReplay(t *testing.T) {
+	b := New(Config{ReplaySize: 2})
+	srv := httptest.NewServer(b)
+	defer srv.Close()
+
+	b.Publish("a", "", "a1")
+	b.Publish("b", "", "b1")
+	b.Publish("a", "", "a2")
+	b.Publish("a", "", "a3")
+
+	// Resuming after id 1 replays what the buffers still hold: a1 fell out of a.
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"?topic=a&topic=b", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	r := newReader(resp.Body)
+	waitSubscribers(t, b, "a", 1)
+	b.Publish("c", "", "c1")
+	b.Publish("b", "ping", "b2")
+	for _, want := range []Event{{ID: "2", Data: "b1"}, {ID: "3", Data: "a2"}, {ID: "4", Data: "a3"}, {ID: "6", Event: "ping", Data: "b2"}} {
+		e, err := r.next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e != want {
+			t.Fatalf("next() = %+v, want %+v", e, want)
+		}
+	}
+}
+
+func TestHeartbeatAndDisconnect(t *testing.T) {
+	b := New(Config{Heartbeat: 10 * time.Millisecond})
+	srv := httptest.NewServer(b.Handler("a"))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	buf := make([]byte, 64)
+	n, err := resp.Body.Read(buf)
+	if err != nil || string(buf[:n]) != ": heartbeat\n\n" {
+		t.Fatalf("Read = %q, %v", buf[:n], err)
+	}
+	waitSubscribers(t, b, "a", 1)
+	cancel()
+	waitSubscribers(t, b, "a", 0)
+}
+
+func TestSlowClientIsDropped(t *testing.T) {
+	b := New(Config{ClientBuffer: 1})
+	s, _, err := b.subscribe([]string{"a"}, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Publish("a", "", "1")
+	b.Publish("a", "", "2")
+	select {
+	case <-s.dropped:
+	default:
+		t.Fatal("subscriber was not dropped")
+	}
+	if n := b.Subscribers("a"); n != 0 {
+		t.Fatalf("Subscribers = %d", n)
+	}
+}
+
+// TestClientReconnects ends the first stream after its first event; the events
+// published meanwhile come on the next connection, made after the retry delay of the
+// server with the id of the last event.
+func TestClientReconnects(t *testing.T) {
+	b := New(Config{Retry: 50 * time.Millisecond})
+	b.Publish("a", "", "1")
+	ended := make(chan struct{}, 1)
+	lastIDs := make(chan string, 2)
+	first := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lastIDs <- r.Header.Get("Last-Event-ID")
+		if !first {
+			b.Handler("a").ServeHTTP(w, r)
+			return
+		}
+		first = false
+		ctx, cancel := context.WithCancel(r.Context())
+		go func() {
+			waitSubscribers(t, b, "a", 1)
+			b.Publish("a", "", "2")
+			time.Sleep(20 * time.Millisecond)
+			cancel()
+		}()
+		b.Handler("a").ServeHTTP(w, r.WithContext(ctx))
+		ended <- struct{}{}
+	}))
+	defer srv.Close()
+	go func() {
+		<-ended
+		b.Publish("a", "", "3")
+		b.Publish("a", "", "4")
+	}()
+
+	c := NewClient(srv.URL)
+	var streamErrs []error
+	c.OnError = func(err error) { streamErrs = append(streamErrs, err) }
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// LastEventID may be read while the client runs.
+	go func() {
+		for ctx.Err() == nil {
+			c.LastEventID()
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	var got []string
+	start := time.Now()
+	err := c.Subscribe(ctx, func(e Event) {
+		got = append(got, e.Data)
+		if len(got) == 3 {
+			cancel()
+		}
+	})
+	if err != context.Canceled {
+		t.Fatalf("Subscribe = %v", err)
+	}
+	if len(streamErrs) != 1 || !errors.Is(streamErrs[0], io.ErrUnexpectedEOF) {
+		t.Fatalf("OnError got %v, want one unexpected EOF", streamErrs)
+	}
+	// The first connection has no Last-Event-ID and starts with the next event.
+	if strings.Join(got, ",") != "2,3,4" || c.LastEventID() != "4" {
+		t.Fatalf("got %v, last id %s", got, c.LastEventID())
+	}
+	if first, second := <-lastIDs, <-lastIDs; first != "" || second != "2" {
+		t.Fatalf("Last-Event-ID headers %q, %q", first, second)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("reconnecting took %v, the retry hint was ignored", time.Since(start))
+	}
+}
@@ -0,0 +1,110 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultRetry is the reconnection delay until the server sends one.
+const DefaultRetry = 3 * time.Second
+
+// Client reads an event stream and reconnects when it ends, resuming after the last
+// event it received with Last-Event-ID.
+type Client struct {
+	// OnError is called when the stream ends, before reconnecting. Defaults to logging.
+	OnError func(error)
+
+	url        string
+	httpClient *http.Client
+	retry      time.Duration
+
+	mu     sync.Mutex
+	lastID string
+}
+
+func NewClient(url string) *Client {
+	return &Client{
+		OnError:    func(err error) { log.Println("Event stream ended, reconnecting:", err) },
+		url:        url,
+		httpClient: &http.Client{},
+		retry:      DefaultRetry,
+	}
+}
+
+// LastEventID returns the id of the last event received, also while Subscribe runs.
+func (c *Client) LastEventID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastID
+}
+
+func (c *Client) setLastEventID(id string) {
+	c.mu.Lock()
+	c.lastID = id
+	c.mu.Unlock()
+}
+
+// Subscribe calls handle for every event until the context is done, reconnecting
+// after the delay of the last retry field of the server. It returns the cause of
+// the context.
+func (c *Client) Subscribe(ctx context.Context, handle func(Event)) error {
+	for {
+		err := c.stream(ctx, handle)
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		if c.OnError != nil {
+			c.OnError(err)
+		}
+		select {
+		case <-time.After(c.retry):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+func (c *Client) stream(ctx context.Context, handle func(Event)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	lastID := c.LastEventID()
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	r := newReader(resp.Body)
+	r.lastID = lastID
+	for {
+		e, err := r.next()
+		if r.retry > 0 {
+			c.retry = r.retry
+		}
+		if err != nil {
+			// Keeps the ids of the blocks without data that ended before the error.
+			c.setLastEventID(r.lastID)
+			if errors.Is(err, io.EOF) {
+				return io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		handle(e)
+		c.setLastEventID(r.lastID)
+	}
+}
@@ -0,0 +1,103 @@
+package broker
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Event is a server-sent event.
+type Event struct {
+	ID string
+	// Event is the event type, "message" when empty.
+	Event string
+	Data  string
+	// Retry is the reconnection delay sent with the event, 0 if none.
+	Retry time.Duration
+}
+
+// write sends the event in the text/event-stream format. Multi-line data is sent as
+// one data field per line.
+func (e Event) write(w io.Writer) error {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", e.Event)
+	}
+	if e.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", e.Retry.Milliseconds())
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", line)
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// reader parses a text/event-stream.
+type reader struct {
+	r *bufio.Reader
+	// lastID persists across events, as an event without an id keeps the previous one.
+	// An id only becomes the last one once the blank line ends its block.
+	lastID string
+	// retry is the last reconnection delay received, even outside of an event.
+	retry time.Duration
+}
+
+func newReader(r io.Reader) *reader {
+	return &reader{r: bufio.NewReader(r)}
+}
+
+// next returns the next event. Comments are skipped, and so are events without data
+// but their id and retry fields still apply. A block cut short by an error leaves
+// lastID as it was.
+func (r *reader) next() (Event, error) {
+	var e Event
+	var data []string
+	hasData := false
+	id := r.lastID
+	for {
+		line, err := r.r.ReadString('\n')
+		if err != nil {
+			return Event{}, err
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if line == "" {
+			r.lastID = id
+			if !hasData {
+				e = Event{}
+				continue
+			}
+			e.ID = id
+			e.Data = strings.Join(data, "\n")
+			return e, nil
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, _ := strings.Cut(line, ":")
+		value = strings.TrimPrefix(value, " ")
+		switch field {
+		case "event":
+			e.Event = value
+		case "data":
+			data = append(data, value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				id = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				e.Retry = time.Duration(ms) * time.Millisecond
+				r.retry = e.Retry
+			}
+		}
+	}
+}
@@ -1,3 +1,8 @@
 module x
 
 go 1.21.4
+
+require lifecycle v0.0.0-00010101000000-000000000000
+
+// lifecycle is a module of its own next to this one, shared with distributed-lb.
+replace lifecycle => ../lifecycle
@@ -0,0 +1,348 @@
+// Package k8swatch keeps up with a Kubernetes resource the way informers do, without
+// client-go: it lists the objects, watches from the resourceVersion of the list,
+// resumes from the last resourceVersion seen when the watch ends and relists when
+// that version is too old (410 Gone). Objects are decoded into typed structs.
+package k8swatch
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// EventType is the type of a watch event.
+type EventType string
+
+const (
+	Added    EventType = "ADDED"
+	Modified EventType = "MODIFIED"
+	Deleted  EventType = "DELETED"
+	// Bookmark only carries a resourceVersion, it is not passed to the handler.
+	Bookmark EventType = "BOOKMARK"
+	Error    EventType = "ERROR"
+)
+
+const (
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+)
+
+// minWatchLifetime is how long a watch without events must last to count as
+// established, a proxy closing every watch at once must not reset the backoff.
+const minWatchLifetime = 10 * time.Second
+
+// errGone means the resourceVersion is too old to watch from and a relist is needed.
+var errGone = errors.New("resource version expired")
+
+// Event is a change of an object.
+type Event[T Object] struct {
+	Type   EventType
+	Object T
+}
+
+type Config struct {
+	// Server is the API server URL, e.g. https://192.168.72.2:16443.
+	Server string
+	// Path is the list path of the resource, e.g. /api/v1/namespaces/default/endpoints.
+	Path          string
+	FieldSelector string
+	LabelSelector string
+	// TLS configures the connection, see TLSConfig. Nil uses the system roots.
+	TLS *tls.Config
+	// Token is a bearer token, e.g. of a service account.
+	Token      string
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// OnError is called with the errors the watcher recovers from. Defaults to logging.
+	OnError func(error)
+}
+
+// TLSConfig verifies the API server with the CA of caFile and authenticates with the
+// client certificate of certFile and keyFile, if given.
+func TLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	ca, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %s", caFile)
+	}
+	cfg := &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Watcher keeps the objects of a resource and reports their changes.
+type Watcher[T Object] struct {
+	cfg        Config
+	httpClient *http.Client
+
+	mu              sync.Mutex
+	objects         map[string]T
+	resourceVersion string
+}
+
+func New[T Object](cfg Config) *Watcher[T] {
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.OnError == nil {
+		cfg.OnError = func(err error) { log.Println("Watch error:", err) }
+	}
+	return &Watcher[T]{
+		cfg:        cfg,
+		httpClient: &http.Client{Transport: &http.Transport{TLSClientConfig: cfg.TLS, Proxy: http.ProxyFromEnvironment}},
+		objects:    make(map[string]T),
+	}
+}
+
+func key(m ObjectMeta) string {
+	return m.Namespace + "/" + m.Name
+}
+
+// Objects returns the objects as of the last event.
+func (w *Watcher[T]) Objects() []T {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	objects := make([]T, 0, len(w.objects))
+	for _, o := range w.objects {
+		objects = append(objects, o)
+	}
+	return objects
+}
+
+// ResourceVersion returns the version the next watch starts from.
+func (w *Watcher[T]) ResourceVersion() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.resourceVersion
+}
+
+// Run lists and watches until the context is done, calling handle for every change.
+// The first list reports every object as added, a relist reports the differences
+// with the objects already known. Failures are retried with exponential backoff, which
+// only resets once a watch delivered events or lasted minWatchLifetime. An expired
+// resourceVersion is relisted at once, unless it expires again before any progress.
+func (w *Watcher[T]) Run(ctx context.Context, handle func(Event[T])) error {
+	backoff := w.cfg.MinBackoff
+	listed := false
+	// relisted is set by a relist at once and cleared by progress.
+	relisted := false
+	for {
+		var err error
+		if !listed {
+			err = w.list(ctx, handle)
+			listed = err == nil
+		}
+		if err == nil {
+			var progressed bool
+			progressed, err = w.watch(ctx, handle)
+			if progressed {
+				backoff = w.cfg.MinBackoff
+				relisted = false
+			}
+		}
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		switch {
+		case errors.Is(err, errGone):
+			listed = false
+			if !relisted {
+				// Relist at once, the watch cannot resume.
+				relisted = true
+				continue
+			}
+			// Gone again without progress, relisting at once would hit the server
+			// in a loop. It is not reported, the next relist is the recovery.
+		case err != nil:
+			w.cfg.OnError(err)
+		}
+		// A nil error is the server ending the watch, as it does after its timeout.
+		// The next one still waits for the backoff in case it ends at once again.
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+		backoff = min(2*backoff, w.cfg.MaxBackoff)
+	}
+}
+
+func (w *Watcher[T]) request(ctx context.Context, query url.Values) (*http.Response, error) {
+	if w.cfg.FieldSelector != "" {
+		query.Set("fieldSelector", w.cfg.FieldSelector)
+	}
+	if w.cfg.LabelSelector != "" {
+		query.Set("labelSelector", w.cfg.LabelSelector)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.cfg.Server+w.cfg.Path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if w.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+w.cfg.Token)
+	}
+	resp, err := w.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusGone {
+		return nil, errGone
+	}
+	var s status
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(body, &s) == nil && s.Message != "" {
+		return nil, fmt.Errorf("%s: %s", resp.Status, s.Message)
+	}
+	return nil, fmt.Errorf("%s: %s", resp.Status, body)
+}
+
+func (w *Watcher[T]) list(ctx context.Context, handle func(Event[T])) error {
+	resp, err := w.request(ctx, url.Values{})
+	if err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+	defer resp.Body.Close()
+	var l list[T]
+	if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+		return fmt.Errorf("list: %w", err)
+	}
+
+	var events []Event[T]
+	w.mu.Lock()
+	seen := make(map[string]bool, len(l.Items))
+	for _, o := range l.Items {
+		m := o.GetMetadata()
+		k := key(m)
+		seen[k] = true
+		old, ok := w.objects[k]
+		switch {
+		case !ok:
+			events = append(events, Event[T]{Type: Added, Object: o})
+		case old.GetMetadata().ResourceVersion != m.ResourceVersion:
+			events = append(events, Event[T]{Type: Modified, Object: o})
+		}
+		w.objects[k] = o
+	}
+	for k, o := range w.objects {
+		if !seen[k] {
+			delete(w.objects, k)
+			events = append(events, Event[T]{Type: Deleted, Object: o})
+		}
+	}
+	w.resourceVersion = l.Metadata.ResourceVersion
+	w.mu.Unlock()
+
+	for _, e := range events {
+		handle(e)
+	}
+	return nil
+}
+
+// watch follows the changes after the current resourceVersion until the stream ends.
+// progressed reports whether the watch delivered an event or lasted minWatchLifetime.
+func (w *Watcher[T]) watch(ctx context.Context, handle func(Event[T])) (progressed bool, err error) {
+	resp, err := w.request(ctx, url.Values{
+		"watch":               {"true"},
+		"resourceVersion":     {w.ResourceVersion()},
+		"allowWatchBookmarks": {"true"},
+	})
+	if err != nil {
+		return false, fmt.Errorf("watch: %w", err)
+	}
+	defer resp.Body.Close()
+
+	established := time.Now()
+	events := 0
+	dec := json.NewDecoder(bufio.NewReader(resp.Body))
+	for {
+		var e struct {
+			Type   EventType       `json:"type"`
+			Object json.RawMessage `json:"object"`
+		}
+		if err := dec.Decode(&e); err != nil {
+			progressed = events > 0 || time.Since(established) >= minWatchLifetime
+			if errors.Is(err, io.EOF) {
+				return progressed, nil
+			}
+			// The stream can't be resynchronized after a decode error, the watch
+			// resumes from the last resourceVersion instead.
+			return progressed, fmt.Errorf("watch: %w", err)
+		}
+		if err := w.apply(e.Type, e.Object, handle); err != nil {
+			return events > 0, err
+		}
+		events++
+	}
+}
+
+func (w *Watcher[T]) apply(typ EventType, raw json.RawMessage, handle func(Event[T])) error {
+	switch typ {
+	case Error:
+		var s status
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return fmt.Errorf("watch: %w", err)
+		}
+		if s.Code == http.StatusGone {
+			return errGone
+		}
+		return fmt.Errorf("watch: %s (%d): %s", s.Reason, s.Code, s.Message)
+	case Bookmark:
+		var o struct {
+			Metadata ObjectMeta `json:"metadata"`
+		}
+		if err := json.Unmarshal(raw, &o); err != nil {
+			return fmt.Errorf("watch: %w", err)
+		}
+		w.mu.Lock()
+		w.resourceVersion = o.Metadata.ResourceVersion
+		w.mu.Unlock()
+		return nil
+	case Added, Modified, Deleted:
+	default:
+		return fmt.Errorf("watch: unknown event type %q", typ)
+	}
+
+	var o T
+	if err := json.Unmarshal(raw, &o); err != nil {
+		return fmt.Errorf("watch: %w", err)
+	}
+	m := o.GetMetadata()
+	w.mu.Lock()
+	if typ == Deleted {
+		delete(w.objects, key(m))
+	} else {
+		w.objects[key(m)] = o
+	}
+	w.resourceVersion = m.ResourceVersion
+	w.mu.Unlock()
+	handle(Event[T]{Type: typ, Object: o})
+	return nil
+}
@@ -0,0 +1,245 @@
+package k8swatch
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const endpointsPath = "/api/v1/namespaces/default/endpoints"
+
+// fakeAPI lists its items and answers each watch with the next script.
+type fakeAPI struct {
+	mu    sync.Mutex
+	items []Endpoints
+	rv    string
+
+	watchRVs chan string
+	scripts  chan func(w http.ResponseWriter)
+}
+
+func newFakeAPI() *fakeAPI {
+	return &fakeAPI{watchRVs: make(chan string, 10), scripts: make(chan func(http.ResponseWriter), 10)}
+}
+
+func (f *fakeAPI) set(rv string, items ...Endpoints) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rv, f.items = rv, items
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != endpointsPath || r.URL.Query().Get("fieldSelector") != "metadata.name=svc" {
+		http.Error(w, "unexpected request "+r.URL.String(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Query().Get("watch") != "true" {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		l := list[Endpoints]{Items: f.items}
+		l.Metadata.ResourceVersion = f.rv
+		json.NewEncoder(w).Encode(l)
+		return
+	}
+	f.watchRVs <- r.URL.Query().Get("resourceVersion")
+	select {
+	case script := <-f.scripts:
+		script(w)
+	case <-r.Context().Done():
+	}
+}
+
+func endpoints(name, rv string) Endpoints {
+	return Endpoints{Metadata: ObjectMeta{Name: name, Namespace: "default", ResourceVersion: rv}}
+}
+
+func events(lines ...any) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		for _, line := range lines {
+			if s, ok := line.(string); ok {
+				fmt.Fprintln(w, s)
+				continue
+			}
+			json.NewEncoder(w).Encode(line)
+		}
+	}
+}
+
+type watchEvent struct {
+	Type   EventType `json:"type"`
+	Object any       `json:"object"`
+}
+
+func writeCA(t *testing.T, srv *httptest.Server) string {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(path, ca, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWatchResumesAndRelists(t *testing.T) {
+	api := newFakeAPI()
+	srv := httptest.NewTLSServer(api)
+	defer srv.Close()
+	tlsConfig, err := TLSConfig(writeCA(t, srv), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	api.set("10", endpoints("a", "5"), endpoints("b", "6"))
+	api.scripts <- events(
+		watchEvent{Modified, endpoints("a", "11")},
+		watchEvent{Bookmark, map[string]any{"metadata": map[string]string{"resourceVersion": "12"}}},
+	)
+	api.scripts <- func(w http.ResponseWriter) {
+		api.set("15", endpoints("a", "13"), endpoints("c", "14"))
+		events(watchEvent{Error, status{Kind: "Status", Code: http.StatusGone, Reason: "Expired"}})(w)
+	}
+	api.scripts <- func(w http.ResponseWriter) { w.WriteHeader(http.StatusGone) }
+	api.scripts <- events(`{"type": "MODIFIED", "object": {`)
+	api.scripts <- events(watchEvent{Deleted, endpoints("c", "16")})
+
+	var errs []error
+	w := New[Endpoints](Config{
+		Server:        srv.URL,
+		Path:          endpointsPath,
+		FieldSelector: "metadata.name=svc",
+		TLS:           tlsConfig,
+		MinBackoff:    time.Millisecond,
+		OnError:       func(err error) { errs = append(errs, err) },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var got []string
+	err = w.Run(ctx, func(e Event[Endpoints]) {
+		got = append(got, fmt.Sprintf("%s %s %s", e.Type, e.Object.Metadata.Name, e.Object.Metadata.ResourceVersion))
+		if len(got) == 7 {
+			cancel()
+		}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Run = %v", err)
+	}
+	want := []string{
+		"ADDED a 5", "ADDED b 6", "MODIFIED a 11",
+		// The relist after the 410 reports what changed meanwhile.
+		"MODIFIED a 13", "ADDED c 14", "DELETED b 6",
+		"DELETED c 16",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %q, want %q", got, want)
+	}
+	close(api.watchRVs)
+	var rvs []string
+	for rv := range api.watchRVs {
+		rvs = append(rvs, rv)
+	}
+	// Resumes after the bookmark, from the relist after each 410, and from the last
+	// version after the decode error.
+	if want := []string{"10", "12", "15", "15", "15"}; !reflect.DeepEqual(rvs, want) {
+		t.Fatalf("watch resourceVersions = %q, want %q", rvs, want)
+	}
+	if len(errs) != 1 {
+		t.Fatalf("errors = %v, want the decode error only", errs)
+	}
+	if objects := w.Objects(); len(objects) != 1 || objects[0].Metadata.Name != "a" {
+		t.Fatalf("objects = %+v", objects)
+	}
+}
+
+func TestWatchBacksOffOnStreamsClosedAtOnce(t *testing.T) {
+	var watches atomic.Int32
+	// A proxy accepting every watch and closing it before any event.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			watches.Add(1)
+			return
+		}
+		json.NewEncoder(w).Encode(list[Endpoints]{})
+	}))
+	defer srv.Close()
+
+	w := New[Endpoints](Config{
+		Server:     srv.URL,
+		Path:       endpointsPath,
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 100 * time.Millisecond,
+		OnError:    func(err error) { t.Errorf("unexpected error: %v", err) },
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	w.Run(ctx, func(Event[Endpoints]) {})
+
+	// 20+40+80+100+100... ms between the watches: about 7 in 500ms.
+	if n := watches.Load(); n < 3 || n > 10 {
+		t.Fatalf("%d watches in 500ms, want the backoff between them", n)
+	}
+}
+
+func TestWatchBacksOffOnRepeatedGone(t *testing.T) {
+	var lists atomic.Int32
+	// A server whose watches are all gone, even right after a list.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("watch") == "true" {
+			w.WriteHeader(http.StatusGone)
+			return
+		}
+		lists.Add(1)
+		json.NewEncoder(w).Encode(list[Endpoints]{})
+	}))
+	defer srv.Close()
+
+	w := New[Endpoints](Config{
+		Server:     srv.URL,
+		Path:       endpointsPath,
+		MinBackoff: 20 * time.Millisecond,
+		MaxBackoff: 100 * time.Millisecond,
+		OnError:    func(error) {},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	w.Run(ctx, func(Event[Endpoints]) {})
+
+	// One relist at once, then the backoff between the others.
+	if n := lists.Load(); n < 3 || n > 10 {
+		t.Fatalf("%d lists in 500ms, want the backoff between them", n)
+	}
+}
+
+func TestWatchVerifiesTLS(t *testing.T) {
+	api := newFakeAPI()
+	srv := httptest.NewTLSServer(api)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var failure error
+	w := New[Endpoints](Config{
+		Server:     srv.URL,
+		Path:       endpointsPath,
+		MinBackoff: time.Millisecond,
+		OnError: func(err error) {
+			failure = err
+			cancel()
+		},
+	})
+	w.Run(ctx, func(Event[Endpoints]) { t.Error("unexpected event") })
+	var unknownAuthority x509.UnknownAuthorityError
+	if !errors.As(failure, &unknownAuthority) {
+		t.Fatalf("error = %v, want an unknown authority", failure)
+	}
+}
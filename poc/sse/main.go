@@ -1,21 +1,25 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
 	"sync"
-	"syscall"
 	"time"
 	"unsafe"
+
+	"lifecycle"
+	"x/broker"
+	"x/k8swatch"
+	"x/snowflake"
 )
 
 type Product struct {
@@ -42,212 +46,130 @@ func main() {
 	// size of optimized product struct: 16 bytes
 }
 func mainSignals() {
-	// Create a channel to receive OS signals
 	pid := os.Getpid()
 	fmt.Printf("PID: %d\n", pid)
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT)
-
-	// Create a channel to wait for program termination
-	done := make(chan bool, 1)
-
-	// Create a goroutine to handle signals
-	go func() {
-		// Wait for a signal
-		sig := <-sigCh
-		fmt.Printf("Received signal: %v\n", sig)
-
-		// Handle the signal
-		switch sig {
-		case syscall.SIGINT:
-			fmt.Println("Received SIGINT. Exiting gracefully...")
-		case syscall.SIGTERM:
-			fmt.Println("Received SIGTERM. Exiting gracefully...")
-		case syscall.SIGQUIT:
-			fmt.Println("SIGQUIT")
-		}
-
-		// Notify main goroutine that it can exit
-		done <- true
-	}()
 
-	// Your application logic goes here
-	// For example, you can run your server or perform other tasks
-	// ...
-
-	// Block the main goroutine until a signal is received
-	<-done
+	// The lifecycle manager waits for SIGINT or SIGTERM and stops the registered
+	// components in order, a second signal exits at once.
+	lc := lifecycle.New()
+	if err := lc.Register(lifecycle.Hook{
+		Name: "app",
+		Start: func(ctx context.Context) error {
+			// Your application logic goes here
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			fmt.Println("Exiting gracefully...")
+			return nil
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if err := lc.Run(context.Background()); err != nil {
+		fmt.Println("Shutdown:", err)
+	}
 }
 
 func sseClient() {
 	url := "http://localhost:8080/events?watch=true"
 
-	// Create a new HTTP request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		fmt.Println("Error creating request:", err)
-		return
-	}
-
-	// Perform the request
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		fmt.Println("Error making request:", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Check if the response status code is OK
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Unexpected status code:", resp.Status)
-		return
-	}
-
-	// Create a new scanner to read from the response body
-	scanner := bufio.NewScanner(resp.Body)
-
-	// Read lines from the response body
-	for scanner.Scan() {
-		line := scanner.Text()
-		fmt.Println("Received event:", line)
-	}
-
-	// Check for errors from scanning
-	if err := scanner.Err(); err != nil {
-		fmt.Println("Error reading response:", err)
-	}
+	// The client reconnects when the stream ends and resumes after the last event.
+	c := broker.NewClient(url)
+	err := c.Subscribe(context.Background(), func(e broker.Event) {
+		fmt.Printf("Received event: %s %s %s\n", e.ID, e.Event, e.Data)
+	})
+	fmt.Println("Error reading events:", err)
 }
 func sseServer() {
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte("sent request to /events?watch=true"))
 	})
 
-	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
-		flusher, ok := w.(http.Flusher)
-		if !ok {
-			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
-			return
+	b := broker.New(broker.Config{Retry: 2 * time.Second})
+	go func() {
+		// Simulate pushing data to the clients every 2 seconds
+		for range time.Tick(2 * time.Second) {
+			b.Publish("time", "", time.Now().Format(time.RFC3339))
 		}
-
-		w.Header().Set("Content-Type", "text/event-stream")
-		w.Header().Set("Cache-Control", "no-cache")
-		w.Header().Set("Connection", "keep-alive")
-
+	}()
+	events := b.Handler("time")
+	http.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
 		watch := r.URL.Query().Get("watch")
 		if watch != "true" {
 			http.Error(w, "Watching not requested", http.StatusBadRequest)
 			return
 		}
-
-		for {
-			// Simulate pushing data to the client every 2 seconds
-			fmt.Fprintf(w, "data: %s\n\n", time.Now().Format(time.RFC3339))
-			flusher.Flush()
-			time.Sleep(2 * time.Second)
-		}
+		events.ServeHTTP(w, r)
 	})
+	// Any topic, e.g. /topics?topic=time
+	http.Handle("/topics", b)
 
 	serverAddr := "localhost:8080"
-	fmt.Printf("Server listening on http://%s\n", serverAddr)
-	err := http.ListenAndServe(serverAddr, nil)
-	if err != nil {
-		fmt.Println("Error starting server:", err)
+	server := &http.Server{Addr: serverAddr}
+	var listener net.Listener
+	lc := lifecycle.New()
+	if err := lc.Register(lifecycle.Hook{
+		Name:     "listener",
+		Priority: lifecycle.StopAccepting,
+		Start: func(ctx context.Context) error {
+			var err error
+			if listener, err = net.Listen("tcp", serverAddr); err != nil {
+				return err
+			}
+			fmt.Printf("Server listening on http://%s\n", serverAddr)
+			go func() {
+				if err := server.Serve(listener); err != http.ErrServerClosed && !errors.Is(err, net.ErrClosed) {
+					fmt.Println("Error serving:", err)
+				}
+			}()
+			return nil
+		},
+		Stop: func(ctx context.Context) error {
+			return listener.Close()
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if err := lc.Register(lifecycle.Hook{
+		Name:     "sse",
+		Priority: lifecycle.Drain,
+		Stop: func(ctx context.Context) error {
+			// Ends the streams, Shutdown then waits for their handlers to return.
+			b.Close()
+			return server.Shutdown(ctx)
+		},
+	}); err != nil {
+		log.Fatal(err)
+	}
+	if err := lc.Run(context.Background()); err != nil {
+		fmt.Println("Shutdown:", err)
 	}
-}
-
-type Response struct {
-	Type   string `json:"type"`
-	Object struct {
-		Kind       string `json:"kind"`
-		APIVersion string `json:"apiVersion"`
-		Metadata   struct {
-			Name              string    `json:"name"`
-			Namespace         string    `json:"namespace"`
-			UID               string    `json:"uid"`
-			ResourceVersion   string    `json:"resourceVersion"`
-			CreationTimestamp time.Time `json:"creationTimestamp"`
-			Annotations       struct {
-				EndpointsKubernetesIoLastChangeTriggerTime time.Time `json:"endpoints.kubernetes.io/last-change-trigger-time"`
-			} `json:"annotations"`
-		} `json:"metadata"`
-		Subsets []struct {
-			Addresses []struct {
-				IP        string `json:"ip"`
-				NodeName  string `json:"nodeName"`
-				TargetRef struct {
-					Kind      string `json:"kind"`
-					Namespace string `json:"namespace"`
-					Name      string `json:"name"`
-					UID       string `json:"uid"`
-				} `json:"targetRef"`
-			} `json:"addresses"`
-			Ports []struct {
-				Port     int    `json:"port"`
-				Protocol string `json:"protocol"`
-			} `json:"ports"`
-		} `json:"subsets"`
-	} `json:"object"`
 }
 
 func k8sdata() {
 	certPath := "./client-cert.pem"
 	keyPath := "./client-key.pem"
+	// The CA of the cluster, e.g. the certificate-authority-data of the kubeconfig.
+	caPath := "./ca.pem"
 
-	url := "https://192.168.72.2:16443/api/v1/watch/namespaces/default/endpoints?fieldSelector=metadata.name=usage-engine-service"
-
-	req, err := http.NewRequest("GET", url, nil)
+	tlsConfig, err := k8swatch.TLSConfig(caPath, certPath, keyPath)
 	if err != nil {
-		fmt.Println("Error creating request:", err)
+		fmt.Println("Error loading certificates:", err)
 		return
 	}
-
-	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
-	if err != nil {
-		fmt.Println("Error loading client certificate and key:", err)
-		return
-	}
-
-	caCert, err := ioutil.ReadFile(certPath)
-	if err != nil {
-		log.Fatalf("Error opening cert file %s, Error: %s", certPath, err)
-	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-	t := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			Certificates:       []tls.Certificate{cert},
-			RootCAs:            caCertPool,
-			InsecureSkipVerify: true,
-		},
-	}
-	client := &http.Client{
-		Timeout:   0,
-		Transport: t,
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Println("Error making request:", err)
-		return
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		fmt.Println("Unexpected status code:", resp.StatusCode)
-		return
-	}
-
-	decoder := json.NewDecoder(resp.Body)
-	for {
-		var data Response
-		if err := decoder.Decode(&data); err == nil {
-			fmt.Printf("Received event: %+v\n", data)
-		} else {
-			break
-		}
-	}
+	w := k8swatch.New[k8swatch.Endpoints](k8swatch.Config{
+		Server:        "https://192.168.72.2:16443",
+		Path:          "/api/v1/namespaces/default/endpoints",
+		FieldSelector: "metadata.name=usage-engine-service",
+		TLS:           tlsConfig,
+	})
+	err = w.Run(context.Background(), func(e k8swatch.Event[k8swatch.Endpoints]) {
+		fmt.Printf("Received event: %s %+v\n", e.Type, e.Object)
+	})
+	fmt.Println("Error watching endpoints:", err)
 }
 
+// getTLSConfig reads the CA once, see tlsreload for certificates that rotate.
 func getTLSConfig(host, caCertFile string, certOpt tls.ClientAuthType) *tls.Config {
 	var caCert []byte
 	var err error
@@ -269,20 +191,29 @@ func getTLSConfig(host, caCertFile string, certOpt tls.ClientAuthType) *tls.Conf
 	}
 }
 
+// SessionCounter hands out values that increase across goroutines and are unique
+// across nodes as long as every node has its own Node, see snowflake.
 type SessionCounter struct {
-	mu    sync.Mutex
-	count int
+	Node int64
+
+	once sync.Once
+	gen  *snowflake.Generator
+	err  error
 }
 
+// GetUniqueValue returns a new value, the session id does not take part in it anymore.
 func (sc *SessionCounter) GetUniqueValue(sessionID string) int {
-	sum := 0
-	for _, v := range sessionID {
-		sum += int(v)
+	sc.once.Do(func() {
+		sc.gen, sc.err = snowflake.New(snowflake.Config{Node: sc.Node})
+	})
+	if sc.err != nil {
+		log.Fatal(sc.err)
 	}
-	// Use the current timestamp and a counter to generate a unique value
-	uniqueValue := ((int(time.Now().UnixMilli())) - times) + sum
-
-	return uniqueValue
+	id, err := sc.gen.Next()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return int(id)
 }
 
 // Simple hash function for string
@@ -296,8 +227,6 @@ func hashString(s string) int {
 	return hash
 }
 
-var times int
-
 func mains() {
 	// k8sdata()
 	maxInt32 := int32(2147483647)
@@ -312,7 +241,6 @@ func mains() {
 	timeFormatted := timeNow.Format("2006-01-02T15:04:05Z")
 	fmt.Println(timeFormatted)
 	ss := math.MaxInt32
-	times = int(time.Now().UnixMilli())
 	fmt.Println("Maximum representable integer value:", ss)
 	fmt.Println(int(time.Now().UnixMilli()))
 	// Test with multiple goroutines
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"fmt"
 	"testing"
-	"time"
+	"x/snowflake"
 )
 
 func TestSessionCounter(t *testing.T) {
-	counter := SessionCounter{}
+	counter := SessionCounter{Node: 3}
 
 	// Keep track of the previous unique value
 	var prevUniqueValue int
@@ -20,13 +19,10 @@ func TestSessionCounter(t *testing.T) {
 			t.Errorf("Generated unique value is not strictly increasing. Previous: %d, Current: %d", prevUniqueValue, uniqueValue)
 		}
 
-		// Print the session ID and unique value for verification
-		fmt.Printf("Session ID: %s, Unique Value: %d\n", "firstworld", uniqueValue)
-
 		// Set the previous unique value for the next iteration
 		prevUniqueValue = uniqueValue
-
-		// Simulate some processing time
-		time.Sleep(time.Millisecond * 100)
+	}
+	if node := snowflake.Parse(snowflake.ID(prevUniqueValue), snowflake.DefaultEpoch).Node; node != 3 {
+		t.Errorf("node of the last value = %d, want 3", node)
 	}
 }
@@ -0,0 +1,146 @@
+// Package snowflake generates 63 bit ids that increase across goroutines and are
+// unique across nodes without coordination. An id packs, from the high bits:
+//
+//	41 bits  milliseconds since the epoch, about 69 years
+//	10 bits  node id, 0 to 1023
+//	12 bits  sequence within the millisecond, 4096 ids per millisecond and node
+package snowflake
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	nodeBits     = 10
+	sequenceBits = 12
+	timeBits     = 63 - nodeBits - sequenceBits
+
+	MaxNode     = 1<<nodeBits - 1
+	maxSequence = 1<<sequenceBits - 1
+	maxTime     = 1<<timeBits - 1
+
+	// DefaultMaxRollback is how far the clock may go back before Next fails
+	// instead of waiting for it to catch up.
+	DefaultMaxRollback = 10 * time.Millisecond
+)
+
+// DefaultEpoch is the start of the timestamps, 2024-01-01 UTC.
+var DefaultEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	ErrClockRollback = errors.New("snowflake: clock moved backwards")
+	ErrTimeOverflow  = errors.New("snowflake: timestamp does not fit in 41 bits")
+)
+
+// ID is a generated id.
+type ID int64
+
+func (id ID) String() string {
+	return strconv.FormatInt(int64(id), 10)
+}
+
+// Parts are the components of an id.
+type Parts struct {
+	Time     time.Time
+	Node     int64
+	Sequence int64
+}
+
+// Parse splits an id generated with the epoch into its components.
+func Parse(id ID, epoch time.Time) Parts {
+	return Parts{
+		Time:     epoch.Add(time.Duration(int64(id)>>(nodeBits+sequenceBits)) * time.Millisecond),
+		Node:     int64(id) >> sequenceBits & MaxNode,
+		Sequence: int64(id) & maxSequence,
+	}
+}
+
+// Config of a generator. Node must be unique among the generators sharing the epoch.
+type Config struct {
+	Node int64
+	// Epoch defaults to DefaultEpoch.
+	Epoch time.Time
+	// MaxRollback defaults to DefaultMaxRollback.
+	MaxRollback time.Duration
+}
+
+// Generator is safe for concurrent use.
+type Generator struct {
+	node        int64
+	epoch       time.Time
+	maxRollback time.Duration
+	now         func() time.Time
+	sleep       func(time.Duration)
+
+	mu       sync.Mutex
+	last     int64
+	sequence int64
+}
+
+func New(cfg Config) (*Generator, error) {
+	if cfg.Node < 0 || cfg.Node > MaxNode {
+		return nil, fmt.Errorf("snowflake: node %d not in [0, %d]", cfg.Node, MaxNode)
+	}
+	if cfg.Epoch.IsZero() {
+		cfg.Epoch = DefaultEpoch
+	}
+	if cfg.MaxRollback <= 0 {
+		cfg.MaxRollback = DefaultMaxRollback
+	}
+	return &Generator{
+		node:        cfg.Node,
+		epoch:       cfg.Epoch,
+		maxRollback: cfg.MaxRollback,
+		now:         time.Now,
+		sleep:       time.Sleep,
+		last:        -1,
+	}, nil
+}
+
+func (g *Generator) millis() int64 {
+	return g.now().Sub(g.epoch).Milliseconds()
+}
+
+// Next returns an id greater than every id returned before by the generator. When
+// the 4096 ids of the current millisecond are used up it waits for the next one,
+// and so it does when the clock moved back by up to MaxRollback.
+func (g *Generator) Next() (ID, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	ts := g.millis()
+	if ts < g.last {
+		behind := time.Duration(g.last-ts) * time.Millisecond
+		if behind > g.maxRollback {
+			return 0, fmt.Errorf("%w by %v", ErrClockRollback, behind)
+		}
+		for ts < g.last {
+			g.sleep(time.Duration(g.last-ts) * time.Millisecond)
+			ts = g.millis()
+		}
+	}
+	if ts == g.last {
+		g.sequence = (g.sequence + 1) & maxSequence
+		if g.sequence == 0 {
+			for ts <= g.last {
+				g.sleep(g.epoch.Add(time.Duration(g.last+1) * time.Millisecond).Sub(g.now()))
+				ts = g.millis()
+			}
+		}
+	} else {
+		g.sequence = 0
+	}
+	if ts < 0 || ts > maxTime {
+		return 0, ErrTimeOverflow
+	}
+	g.last = ts
+	return ID(ts<<(nodeBits+sequenceBits) | g.node<<sequenceBits | g.sequence), nil
+}
+
+// Parse splits an id of this generator into its components.
+func (g *Generator) Parse(id ID) Parts {
+	return Parse(id, g.epoch)
+}
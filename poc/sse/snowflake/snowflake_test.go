@@ -0,0 +1,137 @@
+package snowflake
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to or when the generator sleeps.
+type fakeClock struct {
+	t     time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) sleep(d time.Duration) {
+	c.slept += d
+	c.t = c.t.Add(d)
+}
+
+func newFake(t *testing.T, node int64) (*Generator, *fakeClock) {
+	t.Helper()
+	g, err := New(Config{Node: node})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: DefaultEpoch.Add(time.Hour)}
+	g.now, g.sleep = clock.now, clock.sleep
+	return g, clock
+}
+
+func next(t *testing.T, g *Generator) ID {
+	t.Helper()
+	id, err := g.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func TestParse(t *testing.T) {
+	g, clock := newFake(t, 513)
+	next(t, g)
+	id := next(t, g)
+	want := Parts{Time: clock.t, Node: 513, Sequence: 1}
+	if got := g.Parse(id); got != want {
+		t.Fatalf("Parse(%s) = %+v, want %+v", id, got, want)
+	}
+}
+
+func TestSequenceExhaustionWaitsForNextMillisecond(t *testing.T) {
+	g, clock := newFake(t, 1)
+	start := clock.t
+	var prev ID
+	for i := 0; i <= maxSequence+1; i++ {
+		id := next(t, g)
+		if id <= prev {
+			t.Fatalf("id %d: %s <= %s", i, id, prev)
+		}
+		prev = id
+	}
+	if clock.slept != time.Millisecond {
+		t.Fatalf("slept %v, want 1ms", clock.slept)
+	}
+	if p := g.Parse(prev); p.Sequence != 0 || p.Time != start.Add(time.Millisecond) {
+		t.Fatalf("last id parts = %+v", p)
+	}
+}
+
+func TestClockRollback(t *testing.T) {
+	g, clock := newFake(t, 1)
+	prev := next(t, g)
+
+	// A small step back is waited out.
+	clock.t = clock.t.Add(-5 * time.Millisecond)
+	if id := next(t, g); id <= prev {
+		t.Fatalf("%s <= %s", id, prev)
+	}
+	if clock.slept != 5*time.Millisecond {
+		t.Fatalf("slept %v, want 5ms", clock.slept)
+	}
+
+	// A large one fails until the clock catches up.
+	clock.t = clock.t.Add(-time.Second)
+	if _, err := g.Next(); !errors.Is(err, ErrClockRollback) {
+		t.Fatalf("Next error = %v, want ErrClockRollback", err)
+	}
+	clock.t = clock.t.Add(time.Second)
+	next(t, g)
+}
+
+func TestInvalidNode(t *testing.T) {
+	for _, node := range []int64{-1, MaxNode + 1} {
+		if _, err := New(Config{Node: node}); err == nil {
+			t.Fatalf("New(node %d) succeeded", node)
+		}
+	}
+}
+
+func TestConcurrentIDsAreUniqueAndIncreasing(t *testing.T) {
+	g, err := New(Config{Node: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const workers, perWorker = 8, 5000
+	var wg sync.WaitGroup
+	results := make([][]ID, workers)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < perWorker; i++ {
+				id, err := g.Next()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				results[w] = append(results[w], id)
+			}
+		}(w)
+	}
+	wg.Wait()
+	seen := make(map[ID]bool, workers*perWorker)
+	for _, ids := range results {
+		for i, id := range ids {
+			if i > 0 && id <= ids[i-1] {
+				t.Fatalf("%s after %s", id, ids[i-1])
+			}
+			if seen[id] {
+				t.Fatalf("duplicate id %s", id)
+			}
+			seen[id] = true
+		}
+	}
+}
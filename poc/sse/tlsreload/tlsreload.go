@@ -0,0 +1,231 @@
+// Package tlsreload serves TLS with certificates that rotate on disk. It builds the
+// same config as getTLSConfig, from a certificate, its key and optionally a CA for
+// client certificates, and swaps in a new one when the files change. A reload that
+// fails leaves the last good certificates in place.
+package tlsreload
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultInterval is how often Watch checks the files.
+const DefaultInterval = 10 * time.Second
+
+type Config struct {
+	CertFile string
+	KeyFile  string
+	// CAFile verifies client certificates, it is required when ClientAuth verifies them.
+	CAFile     string
+	ClientAuth tls.ClientAuthType
+	ServerName string
+	Interval   time.Duration
+	// OnError is called when a reload fails. Defaults to logging.
+	OnError func(error)
+}
+
+// Status reports the certificates in use.
+type Status struct {
+	// CertExpiry is the NotAfter of the served certificate.
+	CertExpiry time.Time
+	// CAExpiry is the earliest NotAfter of the CA certificates, zero without a CA.
+	CAExpiry time.Time
+	LoadedAt time.Time
+	// LastError is the error of the last reload, nil if it succeeded.
+	LastError error
+}
+
+type loaded struct {
+	cert   *tls.Certificate
+	config *tls.Config
+	status Status
+}
+
+// Source is safe for concurrent use.
+type Source struct {
+	cfg     Config
+	current atomic.Pointer[loaded]
+
+	mu        sync.Mutex
+	lastError error
+	versions  map[string]fileVersion
+}
+
+type fileVersion struct {
+	modTime time.Time
+	size    int64
+}
+
+// New loads the files, they must be valid.
+func New(cfg Config) (*Source, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("tlsreload: a certificate and a key are required")
+	}
+	if cfg.ClientAuth > tls.RequestClientCert && cfg.CAFile == "" {
+		return nil, fmt.Errorf("tlsreload: %v needs a CA file", cfg.ClientAuth)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultInterval
+	}
+	if cfg.OnError == nil {
+		cfg.OnError = func(err error) { log.Println("TLS reload failed, keeping the previous certificates:", err) }
+	}
+	s := &Source{cfg: cfg}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Source) files() []string {
+	files := []string{s.cfg.CertFile, s.cfg.KeyFile}
+	if s.cfg.CAFile != "" {
+		files = append(files, s.cfg.CAFile)
+	}
+	return files
+}
+
+func (s *Source) load() (*loaded, error) {
+	cert, err := tls.LoadX509KeyPair(s.cfg.CertFile, s.cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf = leaf
+	l := &loaded{cert: &cert, status: Status{CertExpiry: leaf.NotAfter, LoadedAt: time.Now()}}
+
+	var caCertPool *x509.CertPool
+	if s.cfg.CAFile != "" {
+		caCert, err := os.ReadFile(s.cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool = x509.NewCertPool()
+		for rest := caCert; ; {
+			var block *pem.Block
+			if block, rest = pem.Decode(rest); block == nil {
+				break
+			}
+			ca, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", s.cfg.CAFile, err)
+			}
+			caCertPool.AddCert(ca)
+			if l.status.CAExpiry.IsZero() || ca.NotAfter.Before(l.status.CAExpiry) {
+				l.status.CAExpiry = ca.NotAfter
+			}
+		}
+		if l.status.CAExpiry.IsZero() {
+			return nil, fmt.Errorf("no certificate in %s", s.cfg.CAFile)
+		}
+	}
+	l.config = &tls.Config{
+		ServerName:   s.cfg.ServerName,
+		ClientAuth:   s.cfg.ClientAuth,
+		ClientCAs:    caCertPool,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12, // as getTLSConfig
+	}
+	return l, nil
+}
+
+// Reload loads the files now. On failure the previous certificates stay in use.
+func (s *Source) Reload() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.stat()
+	l, err := s.load()
+	s.lastError = err
+	if err != nil {
+		return err
+	}
+	s.versions = versions
+	s.current.Store(l)
+	return nil
+}
+
+// stat returns the versions of the files, a file that can't be read has none.
+func (s *Source) stat() map[string]fileVersion {
+	versions := make(map[string]fileVersion)
+	for _, f := range s.files() {
+		if fi, err := os.Stat(f); err == nil {
+			versions[f] = fileVersion{modTime: fi.ModTime(), size: fi.Size()}
+		}
+	}
+	return versions
+}
+
+func (s *Source) changed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	versions := s.stat()
+	if len(versions) != len(s.versions) {
+		return true
+	}
+	for f, v := range versions {
+		if s.versions[f] != v {
+			return true
+		}
+	}
+	return false
+}
+
+// Watch reloads the files when they change until the context is done. Files are
+// compared by modification time and size, symlinks swapped by a mounted secret
+// are followed.
+func (s *Source) Watch(ctx context.Context) {
+	ticker := time.NewTicker(s.cfg.Interval)
+	defer ticker.Stop()
+	var failed bool
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		// A failed reload is retried until it succeeds, the files may have been
+		// caught halfway through a rotation.
+		if !failed && !s.changed() {
+			continue
+		}
+		err := s.Reload()
+		if err != nil {
+			s.cfg.OnError(err)
+		}
+		failed = err != nil
+	}
+}
+
+// Status returns the expiry times of the certificates in use and the last error.
+func (s *Source) Status() Status {
+	status := s.current.Load().status
+	s.mu.Lock()
+	status.LastError = s.lastError
+	s.mu.Unlock()
+	return status
+}
+
+// TLSConfig returns a server config that always serves the current certificates.
+func (s *Source) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return s.current.Load().cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return s.current.Load().config, nil
+		},
+	}
+}
@@ -0,0 +1,206 @@
+package tlsreload
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// issued is a certificate with its key, self-signed when it has no parent.
+type issued struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func issue(t *testing.T, serial int64, ca *issued, notAfter time.Time) *issued {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	parent, signer := tmpl, key
+	if ca == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = ca.cert, ca.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &issued{cert: cert, key: key, der: der}
+}
+
+func (i *issued) certPEM() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: i.der})
+}
+
+func (i *issued) keyPEM(t *testing.T) []byte {
+	der, err := x509.MarshalECPrivateKey(i.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+func (i *issued) tlsCert(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(i.certPEM(), i.keyPEM(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte, mtime time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Rewrites within the same second must look changed on coarse file systems.
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedSerial connects with a client trusting ca and returns the serial of the
+// server certificate.
+func servedSerial(t *testing.T, url string, ca *issued, client *tls.Certificate) (int64, error) {
+	t.Helper()
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: roots}
+	if client != nil {
+		cfg.Certificates = []tls.Certificate{*client}
+	}
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+	resp, err := c.Get(url)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.TLS.PeerCertificates[0].SerialNumber.Int64(), nil
+}
+
+func TestReloadSwapsCertificates(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem")
+	ca := issue(t, 1, nil, time.Now().Add(48*time.Hour))
+	first := issue(t, 10, ca, time.Now().Add(24*time.Hour))
+	start := time.Now().Add(-time.Minute)
+	writeFile(t, certFile, first.certPEM(), start)
+	writeFile(t, keyFile, first.keyPEM(t), start)
+	writeFile(t, caFile, ca.certPEM(), start)
+
+	var reloadErrs = make(chan error, 10)
+	src, err := New(Config{
+		CertFile: certFile, KeyFile: keyFile, CAFile: caFile,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		Interval:   5 * time.Millisecond,
+		OnError:    func(err error) { reloadErrs <- err },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st := src.Status(); !st.CertExpiry.Equal(first.cert.NotAfter) || !st.CAExpiry.Equal(ca.cert.NotAfter) {
+		t.Fatalf("status = %+v", st)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	srv.TLS = src.TLSConfig()
+	srv.StartTLS()
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go src.Watch(ctx)
+
+	client := first.tlsCert(t)
+	if serial, err := servedSerial(t, srv.URL, ca, &client); err != nil || serial != 10 {
+		t.Fatalf("served %d, %v", serial, err)
+	}
+
+	// The daily rotation: a new certificate from the same CA.
+	second := issue(t, 11, ca, time.Now().Add(25*time.Hour))
+	writeFile(t, certFile, second.certPEM(), start.Add(time.Second))
+	writeFile(t, keyFile, second.keyPEM(t), start.Add(time.Second))
+	waitFor(t, func() bool { return src.Status().CertExpiry.Equal(second.cert.NotAfter) })
+	if serial, err := servedSerial(t, srv.URL, ca, &client); err != nil || serial != 11 {
+		t.Fatalf("served %d, %v", serial, err)
+	}
+
+	// A broken key keeps the last good certificate and is reported. Errors of the
+	// rotation, caught between the certificate and the key, are dropped first.
+	for len(reloadErrs) > 0 {
+		<-reloadErrs
+	}
+	writeFile(t, keyFile, []byte("garbage"), start.Add(2*time.Second))
+	select {
+	case <-reloadErrs:
+	case <-time.After(2 * time.Second):
+		t.Fatal("the failed reload was not reported")
+	}
+	if st := src.Status(); st.LastError == nil || !st.CertExpiry.Equal(second.cert.NotAfter) {
+		t.Fatalf("status = %+v", st)
+	}
+	if serial, err := servedSerial(t, srv.URL, ca, &client); err != nil || serial != 11 {
+		t.Fatalf("served %d, %v", serial, err)
+	}
+
+	// A new CA: clients of the old one are refused.
+	newCA := issue(t, 2, nil, time.Now().Add(72*time.Hour))
+	third := issue(t, 12, newCA, time.Now().Add(24*time.Hour))
+	writeFile(t, certFile, third.certPEM(), start.Add(3*time.Second))
+	writeFile(t, keyFile, third.keyPEM(t), start.Add(3*time.Second))
+	writeFile(t, caFile, newCA.certPEM(), start.Add(3*time.Second))
+	waitFor(t, func() bool { return src.Status().LastError == nil && src.Status().CAExpiry.Equal(newCA.cert.NotAfter) })
+	if _, err := servedSerial(t, srv.URL, newCA, &client); err == nil {
+		t.Fatal("a client certificate of the old CA was accepted")
+	}
+	newClient := third.tlsCert(t)
+	if serial, err := servedSerial(t, srv.URL, newCA, &newClient); err != nil || serial != 12 {
+		t.Fatalf("served %d, %v", serial, err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal("condition not met")
+}
+
+func TestNewRequiresValidFiles(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := New(Config{CertFile: filepath.Join(dir, "missing.pem"), KeyFile: filepath.Join(dir, "missing-key.pem")}); err == nil {
+		t.Fatal("New succeeded without files")
+	}
+	if _, err := New(Config{CertFile: "c", KeyFile: "k", ClientAuth: tls.RequireAndVerifyClientCert}); err == nil {
+		t.Fatal("New succeeded without a CA for client certificates")
+	}
+}